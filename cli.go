@@ -0,0 +1,723 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/aldi"
+	"github.com/tjhowse/aus_grocery_price_database/internal/analysis"
+	"github.com/tjhowse/aus_grocery_price_database/internal/catalogue"
+	"github.com/tjhowse/aus_grocery_price_database/internal/coles"
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+	"github.com/tjhowse/aus_grocery_price_database/internal/outbox"
+	"github.com/tjhowse/aus_grocery_price_database/internal/replay"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+	"github.com/tjhowse/aus_grocery_price_database/internal/watchlist"
+	"github.com/tjhowse/aus_grocery_price_database/internal/woolworths"
+)
+
+// How many products flushProducts reads from a store at a time.
+const FLUSH_BATCH_SIZE = 1000
+
+// command is one of the binary's subcommands, E.G. `run-app db stats`.
+type command struct {
+	name    string // Nested commands are separated by spaces, E.G. "db stats"
+	args    string // Positional arguments shown in the usage, E.G. "<id>"
+	summary string
+	run     func(ctx context.Context, cfg *config, args []string) error
+}
+
+var commands = []command{
+	{"serve", "", "scrape the stores, write to the timeseries DB and serve the API (the default)", serveCommand},
+	{"scrape-once", "", "scrape every due department once, write to the timeseries DB and exit", scrapeOnceCommand},
+	{"export", "", "export products from the local DBs as CSV or JSON", exportCommand},
+	{"replay", "", "rebuild the timeseries DB from the local price history", replayCommand},
+	{"false-sales", "", "print a report of suspected false sales", falseSalesCommand},
+	{"db migrate", "", "bring every local DB up to the current schema", dbMigrateCommand},
+	{"db stats", "", "print the size of every local DB", dbStatsCommand},
+	{"product show", "<id>", "print a product and its price history", productShowCommand},
+	{"departments list", "", "list the departments in the local DBs", departmentsListCommand},
+}
+
+// findCommand picks the command named by the leading arguments, returning the rest of the arguments for it.
+// No arguments at all means serve.
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [arguments]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "\nRun a command with -h to see its flags.\n\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// localStore is a grocery store's local DB, for commands that work on what's already been scraped.
+type localStore interface {
+	StoreName() string
+	GetSharedProducts() ([]shared.ProductInfo, error)
+	GetProduct(id string) (shared.ProductInfo, error)
+	GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error)
+	GetPromotedProducts() ([]shared.ProductInfo, error)
+	GetProductsWithPriceChanges(minEntries int) ([]shared.ProductInfo, error)
+	GetDepartments() ([]shared.DepartmentInfo, error)
+	GetTotalProductCount() (int, error)
+	GetSharedProductsChangedAfter(changeSeq int64, count int) ([]shared.ProductInfo, int64, error)
+	LatestChangeSeq() (int64, error)
+	ScrapeDepartment(ctx context.Context, department string) (shared.ScrapeSummary, error)
+	RunOnce(ctx context.Context) (shared.ScrapeSummary, error)
+}
+
+// openStoreDB opens the DB the stores share, in Postgres if it's configured, importing any of their legacy DBs
+// that haven't been already. A legacy DB that can't be imported is an error, so nothing's scraped into the
+// store before it is.
+func openStoreDB(cfg *config) (*store.DB, error) {
+	var db *store.DB
+	var err error
+	if cfg.StoreDBURL != "" {
+		db, err = store.OpenPostgres(cfg.StoreDBURL)
+	} else {
+		db, err = store.Open(cfg.LocalDBPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store DB: %w", err)
+	}
+	for _, l := range legacyDBs(cfg) {
+		if err := db.ImportLegacyDB(l.name, l.path); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to import legacy %s DB %s: %w", l.name, l.path, err)
+		}
+	}
+	return db, nil
+}
+
+// legacyDBs are the DBs each store had before they shared one.
+func legacyDBs(cfg *config) []struct{ name, path string } {
+	return []struct{ name, path string }{
+		{"Woolworths", cfg.LocalWoolworthsDBPath},
+		{"Coles", cfg.LocalColesDBPath},
+		{"Aldi", cfg.LocalAldiDBPath},
+	}
+}
+
+// openStoreDBReadOnly opens the DB the stores share without changing anything, for commands that only look at
+// it. Bringing it up to date is left to serve and db migrate, so it's an error if it needs migrating or there's
+// a legacy DB still to import.
+func openStoreDBReadOnly(cfg *config) (*store.DB, error) {
+	for _, l := range legacyDBs(cfg) {
+		if _, err := os.Stat(l.path); err == nil {
+			return nil, fmt.Errorf("legacy %s DB %s hasn't been imported yet, run db migrate first", l.name, l.path)
+		}
+	}
+	var db *store.DB
+	var err error
+	if cfg.StoreDBURL != "" {
+		db, err = store.OpenPostgresReadOnly(cfg.StoreDBURL)
+	} else {
+		db, err = store.OpenReadOnly(cfg.LocalDBPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store DB, run db migrate if it needs migrating: %w", err)
+	}
+	return db, nil
+}
+
+// openStores opens the local DB of each enabled store, bringing it up to date. Nothing is scraped unless
+// asked for.
+func openStores(cfg *config) ([]localStore, error) {
+	db, err := openStoreDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return initStores(cfg, db)
+}
+
+// openStoresReadOnly opens the local DB of each enabled store without changing it, E.G. to export from it.
+func openStoresReadOnly(cfg *config) ([]localStore, error) {
+	db, err := openStoreDBReadOnly(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return initStores(cfg, db)
+}
+
+// initStores sets up each enabled store on the DB they share.
+func initStores(cfg *config, db *store.DB) ([]localStore, error) {
+	maxAge := time.Duration(cfg.MaxProductAgeMinutes) * time.Minute
+	w := woolworths.Woolworths{}
+	if err := w.Init(cfg.WoolworthsURL, db, maxAge); err != nil {
+		return nil, fmt.Errorf("failed to initialise Woolworths: %w", err)
+	}
+	c := coles.Coles{}
+	if err := c.Init(cfg.ColesURL, db, maxAge); err != nil {
+		return nil, fmt.Errorf("failed to initialise Coles: %w", err)
+	}
+	stores := []localStore{&w, &c}
+	if cfg.AldiURL != "" {
+		a := aldi.Aldi{}
+		if err := a.Init(cfg.AldiURL, db, maxAge); err != nil {
+			return nil, fmt.Errorf("failed to initialise Aldi: %w", err)
+		}
+		stores = append(stores, &a)
+	}
+	return stores, nil
+}
+
+// filterStores returns the stores named in a comma-separated list, ignoring case. An empty list means all of them.
+func filterStores(stores []localStore, names string) ([]localStore, error) {
+	if names == "" {
+		return stores, nil
+	}
+	var filtered []localStore
+	for _, name := range strings.Split(names, ",") {
+		i := slices.IndexFunc(stores, func(s localStore) bool { return strings.EqualFold(s.StoreName(), strings.TrimSpace(name)) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown or disabled store %q", name)
+		}
+		filtered = append(filtered, stores[i])
+	}
+	return filtered, nil
+}
+
+// scrapeOnceCommand makes a single pass over the stores, writes what was scraped to the timeseries DB and
+// exits, E.G. for running on a schedule instead of as a service. It fails if any page couldn't be scraped
+// or the timeseries DB couldn't be written to.
+func scrapeOnceCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("scrape-once", flag.ExitOnError)
+	storeNames := flags.String("store", "", "comma-separated stores to scrape, E.G. coles, or all of them if empty")
+	department := flags.String("department", "", "a department ID or name to scrape whether or not it's due, E.G. bakery, or every due department if empty")
+	flags.Parse(args)
+
+	stores, err := openStores(cfg)
+	if err != nil {
+		return err
+	}
+	if stores, err = filterStores(stores, *storeNames); err != nil {
+		return err
+	}
+	var errs []error
+	var summaries []shared.ScrapeSummary
+	for _, store := range stores {
+		var summary shared.ScrapeSummary
+		if *department != "" {
+			summary, err = store.ScrapeDepartment(ctx, *department)
+		} else {
+			summary, err = store.RunOnce(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to scrape %s: %w", store.StoreName(), err))
+		}
+		summaries = append(summaries, summary)
+	}
+	written, err := flushProducts(cfg, stores)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if err := writeScrapeSummaries(os.Stdout, summaries, written); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// flushProducts queues the products saved since the outbox last read each store, then delivers everything
+// in the outbox to the timeseries backends. It returns how many products were queued.
+func flushProducts(cfg *config, stores []localStore) (int, error) {
+	tsDB, backends, err := newTimeseriesDB(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialise timeseries DB: %w", err)
+	}
+	defer tsDB.Close()
+	ob := outbox.Outbox{}
+	if err := ob.Init(cfg.LocalOutboxDBPath); err != nil {
+		return 0, fmt.Errorf("failed to initialise outbox: %w", err)
+	}
+	for _, b := range backends {
+		if err := ob.AddSink(b.name, b.backend); err != nil {
+			return 0, fmt.Errorf("failed to add outbox sink %s: %w", b.name, err)
+		}
+	}
+	cat := catalogue.Catalogue{}
+	if err := cat.Init(cfg.LocalCatalogueDBPath, nil); err != nil {
+		return 0, fmt.Errorf("failed to initialise catalogue: %w", err)
+	}
+
+	var queued int
+	for _, store := range stores {
+		changeSeq, err := resumeChangeSeq(&ob, store)
+		if err != nil {
+			return queued, fmt.Errorf("failed to get %s change sequence: %w", store.StoreName(), err)
+		}
+		for {
+			products, next, err := store.GetSharedProductsChangedAfter(changeSeq, FLUSH_BATCH_SIZE)
+			if err != nil {
+				return queued, fmt.Errorf("failed to get %s products: %w", store.StoreName(), err)
+			}
+			if next == changeSeq {
+				break
+			}
+			for i := range products {
+				products[i].CanonicalID = cat.GetCanonicalID(products[i].ID)
+			}
+			if err := ob.AddFrom(store.StoreName(), products, next); err != nil {
+				return queued, fmt.Errorf("failed to queue products for the timeseries DB: %w", err)
+			}
+			queued += len(products)
+			changeSeq = next
+		}
+	}
+	tsDB.WriteArbitrarySystemDatapoint(shared.SYSTEM_VERSION_FIELD, VERSION)
+	if err := ob.Flush(); err != nil {
+		return queued, fmt.Errorf("failed to write to the timeseries DB, the products will be retried next time: %w", err)
+	}
+	return queued, nil
+}
+
+func writeScrapeSummaries(w io.Writer, summaries []shared.ScrapeSummary, written int) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tDEPARTMENTS\tPAGES\tFAILED\tPRODUCTS")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", s.Store, s.Departments, s.Pages, s.FailedPages, s.Products)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\nQueued %d products for the timeseries DB\n", written)
+	return err
+}
+
+// exportCommand writes every named product in the local DBs to a file or stdout.
+func exportCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	storeNames := flags.String("stores", "", "comma-separated stores to export, E.G. Woolworths,Coles")
+	format := flags.String("format", "csv", "csv or json")
+	output := flags.String("o", "", "the file to write to, or stdout if empty")
+	flags.Parse(args)
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	if stores, err = filterStores(stores, *storeNames); err != nil {
+		return err
+	}
+	var products []shared.ProductInfo
+	for _, store := range stores {
+		storeProducts, err := store.GetSharedProducts()
+		if err != nil {
+			return fmt.Errorf("failed to get %s products: %w", store.StoreName(), err)
+		}
+		products = append(products, storeProducts...)
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	switch *format {
+	case "csv":
+		err = writeProductsCSV(out, products)
+	case "json":
+		err = json.NewEncoder(out).Encode(products)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	slog.Info("Exported products", "count", len(products))
+	return nil
+}
+
+// writeProductsCSV writes products as CSV with a header row.
+func writeProductsCSV(w io.Writer, products []shared.ProductInfo) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "store", "department", "name", "barcode", "price_cents", "previous_price_cents",
+		"unit_price_cents", "unit_type", "weight_grams", "promotion_type", "was_price_cents", "updated"})
+	for _, p := range products {
+		cw.Write([]string{p.ID, p.Store, p.Department, p.Name, p.Barcode, strconv.Itoa(p.PriceCents),
+			strconv.Itoa(p.PreviousPriceCents), strconv.FormatFloat(p.UnitPriceCents, 'f', 2, 64), p.UnitType,
+			strconv.Itoa(p.WeightGrams), p.Promotion.Type, strconv.Itoa(p.Promotion.WasPriceCents),
+			p.Timestamp.Format(time.RFC3339)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseReplayTime parses a date, E.G. 2024-01-31, or an RFC3339 timestamp. An empty string is the zero time.
+func parseReplayTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// replayCommand rebuilds the timeseries database from the price history in the local store databases,
+// E.G. after losing the timeseries database or adding a new backend.
+func replayCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	from := flags.String("from", "", "only replay prices observed at or after this date or RFC3339 time")
+	to := flags.String("to", "", "only replay prices observed before this date or RFC3339 time")
+	dryRun := flags.Bool("dry-run", false, "count the datapoints without writing them")
+	pointsPerSecond := flags.Float64("rate", 1000, "the most datapoints to write per second, or 0 for no limit")
+	storeNames := flags.String("stores", "", "comma-separated stores to replay, E.G. Woolworths,Coles")
+	departments := flags.String("departments", "", "comma-separated departments to replay")
+	flags.Parse(args)
+
+	opts := replay.Options{DryRun: *dryRun, PointsPerSecond: *pointsPerSecond}
+	var err error
+	if opts.From, err = parseReplayTime(*from); err != nil {
+		return fmt.Errorf("failed to parse -from: %w", err)
+	}
+	if opts.To, err = parseReplayTime(*to); err != nil {
+		return fmt.Errorf("failed to parse -to: %w", err)
+	}
+	if *departments != "" {
+		opts.Departments = strings.Split(*departments, ",")
+	}
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	if stores, err = filterStores(stores, *storeNames); err != nil {
+		return err
+	}
+	replayStores := make([]replay.Store, 0, len(stores))
+	for _, store := range stores {
+		replayStores = append(replayStores, store)
+	}
+
+	var writer replay.Writer
+	if !opts.DryRun {
+		tsDB, backends, err := newTimeseriesDB(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialise timeseries DB: %w", err)
+		}
+		defer tsDB.Close()
+		writer = backendsWriter(backends)
+	}
+
+	start := time.Now()
+	result, err := replay.Replay(ctx, replayStores, writer, opts)
+	if err != nil {
+		return err
+	}
+	slog.Info("Replay complete", "products", result.Products, "points", result.Points, "dryRun", opts.DryRun, "duration", time.Since(start))
+	return nil
+}
+
+// backendsWriter writes replayed datapoints to each timeseries backend in turn, waiting for each to
+// acknowledge them, and stops at the first that fails.
+type backendsWriter []namedBackend
+
+func (b backendsWriter) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	for _, backend := range b {
+		if err := backend.backend.WriteProductDatapoints(infos); err != nil {
+			return fmt.Errorf("failed to write to %s: %w", backend.name, err)
+		}
+	}
+	return nil
+}
+
+// falseSalesCommand analyses the local price history for false sales and prints the findings.
+func falseSalesCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("false-sales", flag.ExitOnError)
+	flags.Parse(args)
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	analysisStores := make([]analysis.Store, 0, len(stores))
+	for _, store := range stores {
+		analysisStores = append(analysisStores, store)
+	}
+	analyser := analysis.Analyser{}
+	if err := analyser.Init(cfg.LocalAnalysisDBPath, analysisStores, nil); err != nil {
+		return fmt.Errorf("failed to initialise analyser: %w", err)
+	}
+	findings, err := analyser.DetectFalseSales(time.Now())
+	if err != nil {
+		return err
+	}
+	return analysis.WriteFalseSaleReport(os.Stdout, findings)
+}
+
+// localDB is one of the local SQLite databases, or Postgres for the stores if it's configured.
+type localDB struct {
+	name   string
+	path   string
+	schema migrate.Schema
+	open   func() error                 // Opens the DB, bringing it up to the current schema
+	check  func() (migrate.Plan, error) // Checks the DB if it's not a SQLite file at path
+}
+
+func localDBs(cfg *config) []localDB {
+	stores := localDB{"stores", cfg.LocalDBPath, store.DB_SCHEMA, func() error {
+		db, err := openStoreDB(cfg)
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	}, nil}
+	if cfg.StoreDBURL != "" {
+		stores.path = "postgres"
+		stores.schema = store.POSTGRES_DB_SCHEMA
+		stores.check = func() (migrate.Plan, error) { return store.CheckPostgres(cfg.StoreDBURL) }
+	}
+	return []localDB{
+		stores,
+		{"catalogue", cfg.LocalCatalogueDBPath, catalogue.DB_SCHEMA, func() error {
+			return (&catalogue.Catalogue{}).Init(cfg.LocalCatalogueDBPath, nil)
+		}, nil},
+		{"analysis", cfg.LocalAnalysisDBPath, analysis.DB_SCHEMA, func() error {
+			return (&analysis.Analyser{}).Init(cfg.LocalAnalysisDBPath, nil, nil)
+		}, nil},
+		{"watchlist", cfg.LocalWatchlistDBPath, watchlist.DB_SCHEMA, func() error {
+			return (&watchlist.Watchlist{}).Init(cfg.LocalWatchlistDBPath, nil, nil)
+		}, nil},
+		{"outbox", cfg.LocalOutboxDBPath, outbox.DB_SCHEMA, func() error {
+			return (&outbox.Outbox{}).Init(cfg.LocalOutboxDBPath)
+		}, nil},
+	}
+}
+
+// dbMigrateCommand opens every local DB, which brings it up to the current schema. With -dry-run it only
+// says what it would do.
+func dbMigrateCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("db migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print the migrations each DB needs without applying them")
+	flags.Parse(args)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "DATABASE\tPATH\tFROM\tTO\tCHANGES")
+	for _, db := range localDBs(cfg) {
+		check := func() (migrate.Plan, error) { return migrate.Check(db.path, db.schema) }
+		if db.check != nil {
+			check = db.check
+		}
+		plan, err := check()
+		if err != nil {
+			return fmt.Errorf("failed to check %s DB: %w", db.name, err)
+		}
+		if !*dryRun {
+			if err := db.open(); err != nil {
+				return fmt.Errorf("failed to migrate %s DB: %w", db.name, err)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", db.name, db.path, plan.From, plan.To, describePlan(plan))
+	}
+	return nil
+}
+
+// describePlan summarises what bringing a DB up to date involves, E.G. "12: give products a change sequence".
+func describePlan(plan migrate.Plan) string {
+	switch {
+	case plan.UpToDate():
+		return "up to date"
+	case plan.From == 0:
+		return "create"
+	case plan.Recreate:
+		return "too old to migrate, back up and recreate"
+	}
+	var steps []string
+	for _, m := range plan.Pending {
+		steps = append(steps, fmt.Sprintf("%d: %s", m.Version, m.Description))
+	}
+	return strings.Join(steps, "; ")
+}
+
+// dbStatsCommand prints the size of every local DB, and how many products and departments each store has.
+func dbStatsCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("db stats", flag.ExitOnError)
+	flags.Parse(args)
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tPRODUCTS\tDEPARTMENTS")
+	for _, store := range stores {
+		products, err := store.GetTotalProductCount()
+		if err != nil {
+			return err
+		}
+		departments, err := store.GetDepartments()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", store.StoreName(), products, len(departments))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATABASE\tPATH\tSIZE")
+	for _, db := range localDBs(cfg) {
+		size := "missing"
+		if info, err := os.Stat(db.path); err == nil {
+			size = fmt.Sprintf("%.1f MB", float64(info.Size())/1e6)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", db.name, db.path, size)
+	}
+	return tw.Flush()
+}
+
+// productShowCommand prints a product and its price history.
+func productShowCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("product show", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("expected one product ID, E.G. woolworths_sku_123456")
+	}
+	id := flags.Arg(0)
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	for _, store := range stores {
+		product, err := store.GetProduct(id)
+		if errors.Is(err, shared.ErrProductMissing) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		history, err := store.GetProductPriceHistory(id)
+		if err != nil {
+			return err
+		}
+		return writeProduct(os.Stdout, product, history)
+	}
+	return fmt.Errorf("product %s not found", id)
+}
+
+// writeProduct writes a product's details and price history in a human readable form.
+func writeProduct(w io.Writer, p shared.ProductInfo, history []shared.PriceHistoryEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", p.ID)
+	fmt.Fprintf(tw, "Name\t%s\n", p.Name)
+	fmt.Fprintf(tw, "Store\t%s\n", p.Store)
+	fmt.Fprintf(tw, "Department\t%s\n", p.Department)
+	if p.Barcode != "" {
+		fmt.Fprintf(tw, "Barcode\t%s\n", p.Barcode)
+	}
+	fmt.Fprintf(tw, "Price\t$%.2f\n", float64(p.PriceCents)/100)
+	if p.UnitType != "" {
+		fmt.Fprintf(tw, "Unit price\t$%.2f (%s)\n", p.UnitPriceCents/100, p.UnitType)
+	}
+	if p.Promotion.Active() {
+		fmt.Fprintf(tw, "Promotion\t%s, was $%.2f\n", strings.ToLower(p.Promotion.Type), float64(p.Promotion.WasPriceCents)/100)
+	}
+	fmt.Fprintf(tw, "Updated\t%s\n", p.Timestamp.Format(time.RFC3339))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBSERVED\tPRICE\tPROMOTION")
+	for _, entry := range history {
+		fmt.Fprintf(tw, "%s\t$%.2f\t%s\n", entry.Observed.Format(time.RFC3339), float64(entry.PriceCents)/100, strings.ToLower(entry.PromotionType))
+	}
+	return tw.Flush()
+}
+
+// departmentsListCommand lists the departments each store has in its local DB.
+func departmentsListCommand(ctx context.Context, cfg *config, args []string) error {
+	flags := flag.NewFlagSet("departments list", flag.ExitOnError)
+	storeNames := flags.String("stores", "", "comma-separated stores to list, E.G. Coles")
+	flags.Parse(args)
+
+	stores, err := openStoresReadOnly(cfg)
+	if err != nil {
+		return err
+	}
+	if stores, err = filterStores(stores, *storeNames); err != nil {
+		return err
+	}
+	var departments []shared.DepartmentInfo
+	for _, store := range stores {
+		storeDepartments, err := store.GetDepartments()
+		if err != nil {
+			return fmt.Errorf("failed to get %s departments: %w", store.StoreName(), err)
+		}
+		departments = append(departments, storeDepartments...)
+	}
+	return writeDepartments(os.Stdout, departments)
+}
+
+func writeDepartments(w io.Writer, departments []shared.DepartmentInfo) error {
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tID\tDEPARTMENT\tPRODUCTS\tUPDATED\tREFRESHED\tLAST RUN")
+	for _, d := range departments {
+		refreshed := "never"
+		if !d.LastRefreshed.IsZero() {
+			refreshed = formatAge(now.Sub(d.LastRefreshed))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", d.Store, d.ID, d.Description, d.ProductCount, d.Updated.Format(time.RFC3339), refreshed, describeRun(d.LastRun))
+	}
+	return tw.Flush()
+}
+
+// formatAge rounds a duration to its largest whole unit, E.G. "7h ago".
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age/time.Minute))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age/time.Hour))
+	}
+	return fmt.Sprintf("%dd ago", int(age/(24*time.Hour)))
+}
+
+// describeRun summarises a scrape run, E.G. "3/12 pages failed, 40 changed".
+func describeRun(run *shared.ScrapeRun) string {
+	switch {
+	case run == nil:
+		return "-"
+	case run.Finished.IsZero():
+		return fmt.Sprintf("in progress, %d pages", run.Pages)
+	}
+	return fmt.Sprintf("%d/%d pages failed, %d changed", run.FailedPages, run.Pages, run.ChangedProducts)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+)
+
+func TestFindCommand(t *testing.T) {
+	cmd, args, ok := findCommand(nil)
+	if !ok {
+		t.Fatal("Expected to find a command")
+	}
+	if want, got := "serve", cmd.name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	cmd, args, ok = findCommand([]string{"product", "show", "coles_id_123"})
+	if !ok {
+		t.Fatal("Expected to find a command")
+	}
+	if want, got := "product show", cmd.name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 1, len(args); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "coles_id_123", args[0]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	cmd, args, _ = findCommand([]string{"replay", "-dry-run"})
+	if want, got := "replay", cmd.name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "-dry-run", args[0]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if _, _, ok := findCommand([]string{"db"}); ok {
+		t.Errorf("Expected no command")
+	}
+	if _, _, ok := findCommand([]string{"nonsense"}); ok {
+		t.Errorf("Expected no command")
+	}
+}
+
+func TestWriteProductsCSV(t *testing.T) {
+	var b bytes.Buffer
+	err := writeProductsCSV(&b, []shared.ProductInfo{{
+		ID:         "woolworths_sku_1",
+		Name:       "Milk, 2L",
+		Store:      "Woolworths",
+		PriceCents: 310,
+		Promotion:  shared.Promotion{Type: shared.PROMOTION_TYPE_SPECIAL, WasPriceCents: 350},
+		Timestamp:  time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(records); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "Milk, 2L", records[1][3]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "310", records[1][5]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "2024-01-31T00:00:00Z", records[1][12]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestParseReplayTime(t *testing.T) {
+	got, err := parseReplayTime("2024-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local); !want.Equal(got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	got, err = parseReplayTime("2024-01-31T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC); !want.Equal(got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got, _ := parseReplayTime(""); !got.IsZero() {
+		t.Errorf("Expected the zero time, got %v", got)
+	}
+	if _, err := parseReplayTime("yesterday"); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestDescribeFreshness(t *testing.T) {
+	if want, got := "7h ago", formatAge(7*time.Hour+20*time.Minute); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "2d ago", formatAge(50*time.Hour); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	run := &shared.ScrapeRun{Started: time.Now(), Pages: 12}
+	if want, got := "in progress, 12 pages", describeRun(run); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	run.Finished, run.FailedPages, run.ChangedProducts = time.Now(), 3, 40
+	if want, got := "3/12 pages failed, 40 changed", describeRun(run); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "-", describeRun(nil); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestOpenStoreDBReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{
+		LocalDBPath:           filepath.Join(dir, "groceries.db3"),
+		LocalWoolworthsDBPath: filepath.Join(dir, "woolworths.db3"),
+		LocalColesDBPath:      filepath.Join(dir, "coles.db3"),
+		LocalAldiDBPath:       filepath.Join(dir, "aldi.db3"),
+	}
+	if _, err := openStoreDBReadOnly(cfg); err == nil {
+		t.Error("Expected an error before the DB has been created")
+	}
+	if _, err := os.Stat(cfg.LocalDBPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the DB not to be created, got %v", err)
+	}
+
+	// A legacy DB waiting to be imported is left where it is.
+	if err := os.WriteFile(cfg.LocalColesDBPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.Open(cfg.LocalDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := openStoreDBReadOnly(cfg); err == nil {
+		t.Error("Expected an error while there's a legacy DB to import")
+	}
+	if _, err := os.Stat(cfg.LocalColesDBPath); err != nil {
+		t.Errorf("Expected the legacy DB to be left alone, got %v", err)
+	}
+
+	os.Remove(cfg.LocalColesDBPath)
+	db, err = openStoreDBReadOnly(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestBackendsWriter(t *testing.T) {
+	first, second := &MockInfluxDB{}, &MockInfluxDB{}
+	writer := backendsWriter{{"influxdb", first}, {"timescaledb", second}}
+	products := []shared.ProductInfo{{ID: "1"}, {ID: "2"}}
+	if err := writer.WriteProductDatapoints(products); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(second.writtenProductDataPoints); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	second.err = errors.New("connection refused")
+	if err := writer.WriteProductDatapoints(products); !errors.Is(err, second.err) {
+		t.Errorf("Expected %v, got %v", second.err, err)
+	}
+}
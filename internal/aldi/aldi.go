@@ -0,0 +1,89 @@
+package aldi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+	"golang.org/x/time/rate"
+)
+
+const DEFAULT_LISTING_PAGE_CHECK_INTERVAL = 1 * time.Minute
+
+// Aldi satisfies the ProductInfoGetter interface to provide a stream of product information from Aldi.
+type Aldi struct {
+	baseURL                  string
+	client                   *shared.RLHTTPClient
+	cookieJar                *cookiejar.Jar // TODO This might not be threadsafe.
+	filteredDepartmentIDsSet map[string]bool
+	filterDepartments        bool
+	logger                   *slog.Logger
+	*store.Scraper
+}
+
+// Init initialises the Aldi struct.
+func (a *Aldi) Init(baseURL string, db *store.DB, productMaxAge time.Duration) error {
+	var err error
+
+	a.logger = slog.With("store", "Aldi")
+
+	a.cookieJar, err = cookiejar.New(nil)
+	if err != nil {
+		return fmt.Errorf("error creating cookie jar: %v", err)
+	}
+	a.baseURL = baseURL
+	a.client = &shared.RLHTTPClient{
+		Client: &http.Client{
+			Jar:     a.cookieJar,
+			Timeout: 30 * time.Second,
+		},
+		Ratelimiter: rate.NewLimiter(rate.Every(1000*time.Millisecond), 1),
+		Store:       "Aldi",
+	}
+	a.Scraper = &store.Scraper{
+		Repository: db.Repository(store.StoreConfig{
+			Name:            "Aldi",
+			IDPrefix:        ALDI_ID_PREFIX,
+			DescribeProduct: describeProduct,
+		}),
+		Source:          a,
+		PageSize:        PRODUCTS_PER_PAGE,
+		Workers:         1,
+		MaxAge:          productMaxAge,
+		RefreshInterval: DEFAULT_LISTING_PAGE_CHECK_INTERVAL,
+		Logger:          a.logger,
+	}
+	// These are the urlSlugText values of the top-level departments.
+	a.filteredDepartmentIDsSet = map[string]bool{
+		"fruits-vegetables":  true,
+		"meat-seafood":       true,
+		"deli-chilled-meats": true,
+		"dairy-eggs-fridge":  true,
+		"pantry":             true,
+		"bakery":             true,
+		"freezer":            true,
+		"drinks":             true,
+		// "liquor":            true,
+		// "health-beauty":     true,
+		// "baby":              true,
+		// "cleaning-household": true,
+		// "pets":              true,
+	}
+	a.filterDepartments = true
+
+	return nil
+}
+
+// describeProduct reads the brand and size out of a product's raw JSON.
+func describeProduct(rawJSON []byte) (string, string, error) {
+	var info productSearchProduct
+	if err := json.Unmarshal(rawJSON, &info); err != nil {
+		return "", "", err
+	}
+	return info.BrandName, info.SellingSize, nil
+}
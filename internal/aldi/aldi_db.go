@@ -0,0 +1,169 @@
+package aldi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+)
+
+// saveProductInfoes saves the product info to the database transactionfully.
+func (a *Aldi) saveProductInfoes(products []aldiProductInfo) error {
+	storeProducts := make([]store.Product, len(products))
+	for i, product := range products {
+		storeProducts[i] = a.productFromInfo(product)
+	}
+	return a.SaveProducts(storeProducts)
+}
+
+var sellingSizeRegex = regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(kg|g|ml|l)\b`)
+
+// calcWeightInGrams reads the weight out of the free-text selling size, E.G. "500g" or "1.5L".
+func calcWeightInGrams(productInfo aldiProductInfo) (int, error) {
+	matches := sellingSizeRegex.FindStringSubmatch(productInfo.Info.SellingSize)
+	if len(matches) != 3 {
+		return 0, fmt.Errorf("cannot find a weight in selling size `%s`", productInfo.Info.SellingSize)
+	}
+	quantity, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse quantity `%s`: %w", matches[1], err)
+	}
+	scalar := 0.0
+	switch strings.ToLower(matches[2]) {
+	case "g":
+		scalar = 1.0
+	case "kg":
+		scalar = 1000.0
+	case "ml":
+		scalar = 1.0
+	case "l":
+		scalar = 1000.0
+	}
+	return int(quantity * scalar), nil
+}
+
+// calcUnitPrice normalises the comparison price to cents per kilogram, litre or item, falling back to the weight.
+func calcUnitPrice(productInfo aldiProductInfo) (float64, string, error) {
+	unitPriceCents, unitType, err := shared.ParseUnitPrice(productInfo.Info.Price.ComparisonDisplay)
+	if err == nil {
+		return unitPriceCents, unitType, nil
+	}
+	return shared.UnitPriceFromWeight(productInfo.Info.Price.Amount, productInfo.WeightGrams)
+}
+
+// parseDisplayPriceCents converts a display price such as "$4.99" into cents.
+func parseDisplayPriceCents(display string) (int, error) {
+	price, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(display), "$"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse display price `%s`: %w", display, err)
+	}
+	return int(price*100 + 0.5), nil
+}
+
+// productFromInfo maps a product from the web to how it's kept in the DB.
+func (a *Aldi) productFromInfo(productInfo aldiProductInfo) store.Product {
+	var err error
+	productInfo.WeightGrams, err = calcWeightInGrams(productInfo)
+	if err != nil {
+		a.logger.Debug("Couldn't calculate weight in grams", "productID", productInfo.ID, "error", err)
+		productInfo.WeightGrams = 0
+	}
+
+	unitPriceCents, unitType, err := calcUnitPrice(productInfo)
+	if err != nil {
+		a.logger.Debug("Couldn't calculate unit price", "productID", productInfo.ID, "error", err)
+	}
+
+	promotion := a.promotionFromProductInfo(productInfo)
+	return store.Product{
+		ID:             string(productInfo.ID),
+		Name:           productInfo.Info.Name,
+		Description:    productInfo.Info.SellingSize,
+		PriceCents:     productInfo.Info.Price.Amount,
+		WeightGrams:    productInfo.WeightGrams,
+		UnitPriceCents: unitPriceCents,
+		UnitType:       unitType,
+		Promotion:      promotion,
+		OnSpecial:      promotion.Type != "",
+		RawJSON:        productInfo.RawJSON,
+		DepartmentID:   productInfo.departmentID,
+		Updated:        productInfo.Updated,
+	}
+}
+
+// saveProductInfo saves a single product to the database transactionfully. It returns whether the product
+// is new or its price or promotion changed.
+func (a *Aldi) saveProductInfo(tx *store.Tx, productInfo aldiProductInfo) (bool, error) {
+	return tx.SaveProduct(a.productFromInfo(productInfo))
+}
+
+// promotionFromProductInfo extracts the promotion the product is currently on, if any.
+func (a *Aldi) promotionFromProductInfo(productInfo aldiProductInfo) shared.Promotion {
+	var promotion shared.Promotion
+	if productInfo.Info.Price.WasPriceDisplay != nil {
+		wasPriceCents, err := parseDisplayPriceCents(*productInfo.Info.Price.WasPriceDisplay)
+		if err != nil {
+			a.logger.Debug("Couldn't parse was price", "productID", productInfo.ID, "error", err)
+		} else {
+			promotion.WasPriceCents = wasPriceCents
+			promotion.Type = shared.PROMOTION_TYPE_SPECIAL
+		}
+	}
+	return promotion
+}
+
+// loadProductInfo loads cached extended product info from the database
+func (a *Aldi) loadProductInfo(productID productID) (aldiProductInfo, error) {
+	var aProdInfo aldiProductInfo
+	product, err := a.LoadProduct(string(productID))
+	if err != nil {
+		return aProdInfo, err
+	}
+	aProdInfo.ID = productID
+	aProdInfo.Info.Name = product.Name
+	aProdInfo.Info.SellingSize = product.Description
+	aProdInfo.Info.Price.Amount = product.PriceCents
+	aProdInfo.PreviousPriceCents = product.PreviousPriceCents
+	aProdInfo.WeightGrams = product.WeightGrams
+	aProdInfo.RawJSON = product.RawJSON
+	aProdInfo.departmentID = product.DepartmentID
+	aProdInfo.departmentDescription = product.DepartmentDescription
+	aProdInfo.Updated = product.Updated
+	return aProdInfo, nil
+}
+
+// departmentFromInfo maps a department from the web to how it's kept in the DB.
+func departmentFromInfo(departmentInfo departmentInfo) store.Department {
+	return store.Department{
+		ID:           departmentInfo.ID,
+		Description:  departmentInfo.Name,
+		ProductCount: departmentInfo.ProductCount,
+		Updated:      departmentInfo.Updated,
+		Slug:         departmentInfo.URLSlugText,
+	}
+}
+
+// Saves department info to the database
+func (a *Aldi) saveDepartment(departmentInfo departmentInfo) error {
+	return a.SaveDepartment(departmentFromInfo(departmentInfo))
+}
+
+func (a *Aldi) loadDepartmentInfoList() ([]departmentInfo, error) {
+	var departmentInfos []departmentInfo
+	departments, err := a.LoadDepartments()
+	if err != nil {
+		return departmentInfos, err
+	}
+	for _, dept := range departments {
+		departmentInfos = append(departmentInfos, departmentInfo{
+			ID:           dept.ID,
+			Name:         dept.Description,
+			ProductCount: dept.ProductCount,
+			Updated:      dept.Updated,
+		})
+	}
+	return departmentInfos, nil
+}
@@ -0,0 +1,227 @@
+package aldi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+func TestCalcWeightInGrams(t *testing.T) {
+	var cases = []struct {
+		sellingSize string
+		want        int
+		err         bool
+	}{
+		{"500g", 500, false},
+		{"1kg", 1000, false},
+		{"1.5L", 1500, false},
+		{"375 mL", 375, false},
+		{"per kg", 0, true},
+		{"4 pack", 0, true},
+	}
+
+	for _, tc := range cases {
+		weight, err := calcWeightInGrams(aldiProductInfo{Info: productSearchProduct{SellingSize: tc.sellingSize}})
+		if err != nil != tc.err {
+			t.Fatalf("Unexpected error state calculating weight for %s: %v", tc.sellingSize, err)
+		}
+		if want, got := tc.want, weight; want != got {
+			t.Errorf("Expected %d, got %d for %s", want, got, tc.sellingSize)
+		}
+	}
+}
+
+func TestCalcUnitPrice(t *testing.T) {
+	unitPriceCents, unitType, err := calcUnitPrice(aldiProductInfo{Info: productSearchProduct{Price: productSearchPrice{Amount: 349, ComparisonDisplay: "$3.49 per 1 kg"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 349.0, unitPriceCents; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := shared.UNIT_TYPE_MASS, unitType; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// Without a comparison price, fall back to the weight.
+	unitPriceCents, _, err = calcUnitPrice(aldiProductInfo{WeightGrams: 250, Info: productSearchProduct{Price: productSearchPrice{Amount: 299}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1196.0, unitPriceCents; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+}
+
+func TestSaveProductInfo(t *testing.T) {
+	a := getInitialisedAldi()
+	dp := departmentPage{ID: "950000000", page: 1}
+	products, _, err := a.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
+	if err != nil {
+		t.Fatalf("Failed to get products: %v", err)
+	}
+
+	savedProduct := products[1]
+	if err := a.saveProductInfoes([]aldiProductInfo{savedProduct}); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedProduct, err := a.loadProductInfo(savedProduct.ID)
+	if err != nil {
+		t.Fatalf("Failed to load product info: %v", err)
+	}
+	if want, got := savedProduct.Info.Name, loadedProduct.Info.Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := savedProduct.Info.Price.Amount, loadedProduct.Info.Price.Amount; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1000, loadedProduct.WeightGrams; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "950000000", loadedProduct.departmentID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestDepartmentInfo(t *testing.T) {
+	a := getInitialisedAldi()
+	dept := departmentInfo{ID: "950000000", Name: "Fruits & Vegetables", Updated: time.Now()}
+	a.saveDepartment(dept)
+	departmentIDs, err := a.loadDepartmentInfoList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(departmentIDs); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "950000000", departmentIDs[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Fruits & Vegetables", departmentIDs[0].Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestGetSharedProductsChangedAfter(t *testing.T) {
+	a := getInitialisedAldi()
+	var infoList []aldiProductInfo
+	infoList = append(infoList, aldiProductInfo{ID: "123455", Info: productSearchProduct{Name: "1", Price: productSearchPrice{Amount: 150}}, Updated: time.Now().Add(-5 * time.Minute)})
+	infoList = append(infoList, aldiProductInfo{ID: "123456", Info: productSearchProduct{Name: "2", Price: productSearchPrice{Amount: 240}}, Updated: time.Now().Add(-4 * time.Minute)})
+	infoList = append(infoList, aldiProductInfo{ID: "123458", Info: productSearchProduct{Name: "4", Price: productSearchPrice{Amount: 420}}, Updated: time.Now().Add(-1 * time.Minute)})
+	// Put this one in twice to test the PreviousPriceCents is updated.
+	infoList = append(infoList, aldiProductInfo{ID: "123459", Info: productSearchProduct{Name: "5", Price: productSearchPrice{Amount: 500}}, Updated: time.Now()})
+	infoList = append(infoList, aldiProductInfo{ID: "123459", Info: productSearchProduct{Name: "5", Price: productSearchPrice{Amount: 510}}, Updated: time.Now()})
+	// This last one is to test that we don't get products that have a blank name.
+	infoList = append(infoList, aldiProductInfo{ID: "123460", Info: productSearchProduct{Name: "", Price: productSearchPrice{Amount: 600}}, Updated: time.Now()})
+
+	if err := a.saveProductInfoes(infoList); err != nil {
+		t.Fatal(err)
+	}
+
+	// The products before the cursor, the first save of the duplicate and the product with a blank name
+	// are all skipped.
+	products, changeSeq, err := a.GetSharedProductsChangedAfter(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := int64(6), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := ALDI_ID_PREFIX+"123458", products[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Aldi", products[0].Store; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 500, products[1].PreviousPriceCents; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := 510, products[1].PriceCents; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if total, err := a.GetTotalProductCount(); err != nil {
+		t.Fatal(err)
+	} else {
+		if want, got := 5, total; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+}
+
+func TestPriceHistory(t *testing.T) {
+	a := getInitialisedAldi()
+	wasPrice := "$4.99"
+	var infoList []aldiProductInfo
+	infoList = append(infoList, aldiProductInfo{ID: "123455", Info: productSearchProduct{Name: "1", Price: productSearchPrice{Amount: 499}}, Updated: time.Now().Add(-3 * time.Minute)})
+	infoList = append(infoList, aldiProductInfo{ID: "123455", Info: productSearchProduct{Name: "1", Price: productSearchPrice{Amount: 499}}, Updated: time.Now().Add(-2 * time.Minute)})
+	infoList = append(infoList, aldiProductInfo{ID: "123455", Info: productSearchProduct{Name: "1", Price: productSearchPrice{Amount: 399, WasPriceDisplay: &wasPrice}}, Updated: time.Now().Add(-1 * time.Minute)})
+	if err := a.saveProductInfoes(infoList); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := a.GetProductPriceHistory(ALDI_ID_PREFIX + "123455")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(history); want != got {
+		t.Fatalf("Expected %d history entries, got %d", want, got)
+	}
+	if want, got := 399, history[1].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 499, history[1].WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := true, history[1].OnSpecial; want != got {
+		t.Errorf("Expected %t, got %t", want, got)
+	}
+}
+
+func TestSearchAndGetProduct(t *testing.T) {
+	a := getInitialisedAldi()
+	a.saveDepartment(departmentInfo{ID: "950000000", Name: "Fruits & Vegetables", ProductCount: 2, Updated: time.Now()})
+	var infoList []aldiProductInfo
+	infoList = append(infoList, aldiProductInfo{ID: "123455", departmentID: "950000000", Info: productSearchProduct{Name: "Bananas", Price: productSearchPrice{Amount: 349}}, Updated: time.Now()})
+	infoList = append(infoList, aldiProductInfo{ID: "123456", departmentID: "950000000", Info: productSearchProduct{Name: "Royal Gala Apples", Price: productSearchPrice{Amount: 449}}, Updated: time.Now()})
+	if err := a.saveProductInfoes(infoList); err != nil {
+		t.Fatal(err)
+	}
+
+	products, err := a.SearchProducts("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	// Results are ordered by name.
+	if want, got := "Bananas", products[0].Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	product, err := a.GetProduct(ALDI_ID_PREFIX + "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Fruits & Vegetables", product.Department; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if _, err := a.GetProduct(ALDI_ID_PREFIX + "999999"); err != shared.ErrProductMissing {
+		t.Errorf("Expected %v, got %v", shared.ErrProductMissing, err)
+	}
+
+	departments, err := a.GetDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+}
@@ -0,0 +1,123 @@
+package aldi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFetchDepartments(t *testing.T) {
+	a := getInitialisedAldi()
+	departments, err := a.FetchDepartments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 8, len(departments); want != got {
+		t.Errorf("Expected %d departments, got %d", want, got)
+	}
+}
+
+func TestFetchProducts(t *testing.T) {
+	a := getInitialisedAldi()
+	products, err := a.FetchProducts(context.Background(), "950000000", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, product := range products {
+		if product.ID == "000000000000412724" {
+			t.Error("Expected the not-for-sale product to be skipped")
+		}
+		if product.ID == "000000000000412710" {
+			found = true
+			if want, got := "Watermelon Quarter", product.Name; want != got {
+				t.Errorf("Expected %s, got %s", want, got)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected the watermelon to be fetched")
+	}
+}
+
+func ValidateProduct(t *testing.T, a *Aldi, id productID, expectedName string) error {
+	prod, err := a.loadProductInfo(id)
+	if err != nil {
+		return fmt.Errorf("Failed to get product ID %s: %v", id, err)
+	}
+	if prod.Info.Name != expectedName {
+		t.Logf("Expected '%s', got '%s'", expectedName, prod.Info.Name)
+		return fmt.Errorf("Expected '%s', got '%s'", expectedName, prod.Info.Name)
+	}
+	if want, got := "Fruits & Vegetables", prod.departmentDescription; want != got {
+		t.Fatalf("Expected '%s', got '%s'", want, got)
+		return fmt.Errorf("Expected '%s', got '%s'", want, got)
+	}
+	return nil
+}
+
+func TestScheduler(t *testing.T) {
+	a := getInitialisedAldi()
+	a.RefreshInterval = 1 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(stopped)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			err1 := ValidateProduct(t, a, "000000000000412500", "Bananas")
+			err2 := ValidateProduct(t, a, "000000000000412717", "Gold Kiwifruit")
+			if err1 == nil && err2 == nil {
+				close(done)
+				return
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}()
+
+	select {
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for scheduler to finish")
+	case <-done:
+
+	}
+
+	cancel()
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for scheduler to stop")
+	case <-stopped:
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	a := getInitialisedAldi()
+	summary, err := a.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 8, summary.Departments; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, summary.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	count, _ := a.GetTotalProductCount()
+	if want, got := count, summary.Products; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Everything was just updated, so nothing's due on the next pass.
+	summary, err = a.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, summary.Departments; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,186 @@
+package aldi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+)
+
+const CATEGORY_TREE_URL_FORMAT = "%s/v2/product-category-tree"
+const PRODUCT_SEARCH_URL_FORMAT = "%s/v3/product-search"
+const PRODUCTS_PER_PAGE = 30
+
+// getJSON performs a GET request against the Aldi API with the given query parameters
+// and returns the bytes of the response.
+func (a *Aldi) getJSON(ctx context.Context, url string, params map[string]string) ([]byte, error) {
+	var req *http.Request
+	var resp *http.Response
+	var err error
+	var body []byte
+
+	if req, err = http.NewRequestWithContext(ctx, "GET", url, nil); err != nil {
+		return body, err
+	}
+	q := req.URL.Query()
+	for key, value := range params {
+		q.Add(key, value)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:129.0) Gecko/20100101 Firefox/129.0")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err = a.client.Do(req)
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("failed to get %s: %s", url, resp.Status)
+	}
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return body, err
+	}
+	return body, nil
+}
+
+// getCategoryTreeJSON returns the bytes of the Aldi product category tree.
+func (a *Aldi) getCategoryTreeJSON(ctx context.Context) ([]byte, error) {
+	return a.getJSON(ctx, fmt.Sprintf(CATEGORY_TREE_URL_FORMAT, a.baseURL), map[string]string{
+		"serviceType": "walk-in",
+	})
+}
+
+// getProductSearchJSON returns the bytes of the given page of products in a category.
+// Pages are numbered from 1.
+func (a *Aldi) getProductSearchJSON(ctx context.Context, categoryKey string, page int) ([]byte, error) {
+	return a.getJSON(ctx, fmt.Sprintf(PRODUCT_SEARCH_URL_FORMAT, a.baseURL), map[string]string{
+		"currency":    "AUD",
+		"serviceType": "walk-in",
+		"categoryKey": categoryKey,
+		"limit":       strconv.Itoa(PRODUCTS_PER_PAGE),
+		"offset":      strconv.Itoa((page - 1) * PRODUCTS_PER_PAGE),
+		"sort":        "relevance",
+	})
+}
+
+// getProductsAndTotalCountForCategoryPage fetches the specified page of the specified category
+// and returns the products and the total count of products in the category.
+func (a *Aldi) getProductsAndTotalCountForCategoryPage(ctx context.Context, dp departmentPage) ([]aldiProductInfo, int, error) {
+	body, err := a.getProductSearchJSON(ctx, dp.ID, dp.page)
+	if err != nil {
+		return nil, 0, err
+	}
+	var searchPage productSearchPage
+	err = json.Unmarshal(body, &searchPage)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal product search page: %w", err)
+	}
+	var products []aldiProductInfo
+	for _, result := range searchPage.Data {
+		if result.NotForSale {
+			continue
+		}
+		var product aldiProductInfo
+		product.Info = result
+		product.RawJSON, err = json.Marshal(result)
+		if err != nil {
+			a.logger.Warn("Failed to marshal product info for storage", "error", err)
+		}
+		product.departmentID = dp.ID
+		product.ID = productID(result.SKU)
+		product.Updated = time.Now()
+		products = append(products, product)
+	}
+	return products, searchPage.Meta.Pagination.TotalCount, nil
+}
+
+// extractDepartmentInfos decodes the top-level departments from the category tree.
+func extractDepartmentInfos(body []byte) ([]departmentInfo, error) {
+	var tree categoryTree
+	if err := json.Unmarshal(body, &tree); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal category tree: %w", err)
+	}
+	if len(tree.Data) == 0 {
+		return nil, fmt.Errorf("no departments found")
+	}
+	return tree.Data, nil
+}
+
+func (a *Aldi) getDepartmentInfos(ctx context.Context) ([]departmentInfo, error) {
+	body, err := a.getCategoryTreeJSON(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departmentInfos, err := extractDepartmentInfos(body)
+	if err != nil {
+		return nil, err
+	}
+	// The category tree doesn't include product counts, so read them from the first page of each department.
+	// Departments whose count can't be read are left out rather than saved with none, so they're retried on the
+	// next refresh instead of never being scraped.
+	var counted []departmentInfo
+	for _, departmentInfo := range a.filterOutDepartments(departmentInfos) {
+		_, count, err := a.getProductsAndTotalCountForCategoryPage(ctx, departmentPage{ID: departmentInfo.ID, page: 1})
+		if err != nil {
+			a.logger.Warn("Failed to get product count for department", "department", departmentInfo.ID, "error", err)
+			continue
+		}
+		departmentInfo.ProductCount = count
+		counted = append(counted, departmentInfo)
+	}
+	return counted, nil
+}
+
+// isDepartmentFilteredOut returns true if the department is not in the filteredDepartmentIDsSet
+func (a *Aldi) isDepartmentFilteredOut(department departmentInfo) bool {
+	if !a.filterDepartments {
+		return false
+	}
+	_, ok := a.filteredDepartmentIDsSet[department.URLSlugText]
+	return !ok
+}
+
+// filterOutDepartments filters out the departments that are not in the filteredDepartmentIDsSet
+func (a *Aldi) filterOutDepartments(departments []departmentInfo) []departmentInfo {
+	filteredDepartments := []departmentInfo{}
+	for _, dp := range departments {
+		if !a.isDepartmentFilteredOut(dp) {
+			filteredDepartments = append(filteredDepartments, dp)
+		}
+	}
+	return filteredDepartments
+}
+
+// FetchDepartments fetches the departments that aren't filtered out, for the Scraper.
+func (a *Aldi) FetchDepartments(ctx context.Context) ([]store.Department, error) {
+	departmentInfos, err := a.getDepartmentInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departments := make([]store.Department, len(departmentInfos))
+	for i, departmentInfo := range departmentInfos {
+		departments[i] = departmentFromInfo(departmentInfo)
+	}
+	return departments, nil
+}
+
+// FetchProducts fetches a page of a department's products, for the Scraper.
+func (a *Aldi) FetchProducts(ctx context.Context, department string, page int) ([]store.Product, error) {
+	productInfos, _, err := a.getProductsAndTotalCountForCategoryPage(ctx, departmentPage{ID: department, page: page})
+	if err != nil {
+		return nil, err
+	}
+	products := make([]store.Product, len(productInfos))
+	for i, productInfo := range productInfos {
+		products[i] = a.productFromInfo(productInfo)
+	}
+	return products, nil
+}
@@ -0,0 +1,212 @@
+package aldi
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
+	"github.com/tjhowse/aus_grocery_price_database/internal/utils"
+	"golang.org/x/time/rate"
+)
+
+var aldiServer = AldiHTTPServer()
+
+// openTestDB opens a fresh in-memory DB.
+func openTestDB() *store.DB {
+	db, err := store.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func getInitialisedAldi() *Aldi {
+	a := &Aldi{}
+	err := a.Init(aldiServer.URL, openTestDB(), 10*time.Minute)
+	if err != nil {
+		slog.Error("Failed to initialise Aldi", "error", err)
+	}
+	a.client.Ratelimiter = rate.NewLimiter(rate.Every(1*time.Millisecond), 1)
+	return a
+}
+
+// This mocks enough of the Aldi API to test various stuff
+func AldiHTTPServer() *httptest.Server {
+	var err error
+
+	filesToLoad := []string{
+		"data/product-category-tree.json",
+		"data/product-search_950000000_1.json",
+		"data/product-search_950000000_2.json",
+	}
+	fileContents := make(map[string][]byte)
+	for _, filename := range filesToLoad {
+		fileContents[filename], err = utils.ReadEntireFile(filename)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Failed to read file %s: %v\n", filename, err))
+		}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var responseFilename string
+
+		if strings.HasPrefix(r.URL.Path, "/v2/product-category-tree") {
+			responseFilename = "data/product-category-tree.json"
+		} else if strings.HasPrefix(r.URL.Path, "/v3/product-search") {
+			category := r.URL.Query().Get("categoryKey")
+			offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+			if err != nil {
+				slog.Error("Failed to parse offset from URL", "error", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			responseFilename = fmt.Sprintf("data/product-search_%s_%d.json", category, offset/PRODUCTS_PER_PAGE+1)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			slog.Error("Simulated aldi server can't find requested URL.", "url", r.URL.Path)
+			return
+		}
+
+		if responseData, knownFile := fileContents[responseFilename]; !knownFile && strings.HasPrefix(r.URL.Path, "/v3/product-search") {
+			// Departments without fixtures are empty.
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"meta":{"pagination":{"offset":0,"limit":30,"totalCount":0}},"data":[]}`))
+			return
+		} else if !knownFile {
+			slog.Error("Simulated aldi server can't find requested file.", "filename", responseFilename)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else {
+			w.WriteHeader(http.StatusOK)
+			w.Write(responseData)
+		}
+	}))
+}
+
+func TestExtractDepartmentInfos(t *testing.T) {
+	body, err := utils.ReadEntireFile("data/product-category-tree.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	departments, err := extractDepartmentInfos(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 13, len(departments); want != got {
+		t.Errorf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := "950000000", departments[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Fruits & Vegetables", departments[0].Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if _, err := extractDepartmentInfos([]byte(`{"data":[]}`)); err == nil {
+		t.Error("Expected an error for an empty category tree")
+	}
+}
+
+func TestGetDepartmentInfos(t *testing.T) {
+	a := getInitialisedAldi()
+
+	departments, err := a.getDepartmentInfos(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get department list: %v", err)
+	}
+	if want, got := 8, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := "fruits-vegetables", departments[0].URLSlugText; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 33, departments[0].ProductCount; want != got {
+		t.Errorf("Expected %d products, got %d", want, got)
+	}
+
+	if want, got := 0, departments[1].ProductCount; want != got {
+		t.Errorf("Expected %d products, got %d", want, got)
+	}
+
+	a.filterDepartments = false
+	departments, err = a.getDepartmentInfos(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get department list: %v", err)
+	}
+	if want, got := 13, len(departments); want != got {
+		t.Errorf("Expected %d departments, got %d", want, got)
+	}
+}
+
+func TestGetDepartmentInfosFailedCount(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("categoryKey") == "950000000" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		aldiServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer failing.Close()
+	a := getInitialisedAldi()
+	a.baseURL = failing.URL
+
+	departments, err := a.getDepartmentInfos(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get department list: %v", err)
+	}
+	// The department is left out, rather than saved with no products and never scraped.
+	if want, got := 7, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	for _, department := range departments {
+		if department.ID == "950000000" {
+			t.Error("Expected the department whose count failed to be left out")
+		}
+	}
+}
+
+func TestGetProductsAndTotalCountForCategoryPage(t *testing.T) {
+	a := getInitialisedAldi()
+
+	{
+		dp := departmentPage{ID: "950000000", page: 1}
+		products, totalRecordCount, err := a.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
+		if err != nil {
+			t.Fatalf("Failed to get products: %v", err)
+		}
+		if want, got := 30, len(products); want != got {
+			t.Errorf("Expected %d products, got %d", want, got)
+		}
+		if want, got := "Bananas", products[0].Info.Name; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+		if want, got := productID("000000000000412500"), products[0].ID; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+		if want, got := 349, products[0].Info.Price.Amount; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+		if want, got := 33, totalRecordCount; want != got {
+			t.Errorf("Expected %d total record count, got %d", want, got)
+		}
+	}
+	{
+		// The last product on the second page is not for sale, so it should be skipped.
+		dp := departmentPage{ID: "950000000", page: 2}
+		products, _, err := a.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
+		if err != nil {
+			t.Fatalf("Failed to get products: %v", err)
+		}
+		if want, got := 2, len(products); want != got {
+			t.Errorf("Expected %d products, got %d", want, got)
+		}
+		if want, got := "Watermelon Quarter", products[0].Info.Name; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
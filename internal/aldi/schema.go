@@ -0,0 +1,89 @@
+package aldi
+
+import (
+	"time"
+)
+
+type productID string
+
+// Prefix for product IDs when exported outside of aldi-world
+const ALDI_ID_PREFIX = "aldi_sku_"
+
+type aldiProductInfo struct {
+	ID                    productID
+	departmentID          string
+	departmentDescription string
+	Info                  productSearchProduct
+	WeightGrams           int
+	PreviousPriceCents    int
+	RawJSON               []byte
+	Updated               time.Time
+}
+
+type productSearchPrice struct {
+	Amount                int     `json:"amount"`
+	AmountRelevant        int     `json:"amountRelevant"`
+	AmountRelevantDisplay string  `json:"amountRelevantDisplay"`
+	BottleDeposit         int     `json:"bottleDeposit"`
+	FeeText               string  `json:"feeText"`
+	WasPriceDisplay       *string `json:"wasPriceDisplay"`
+	Comparison            int     `json:"comparison"`
+	ComparisonDisplay     string  `json:"comparisonDisplay"`
+}
+
+type productSearchCategory struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	URLSlugText string `json:"urlSlugText"`
+}
+
+type productSearchProduct struct {
+	SKU             string                  `json:"sku"`
+	Name            string                  `json:"name"`
+	BrandName       string                  `json:"brandName"`
+	URLSlugText     string                  `json:"urlSlugText"`
+	SellingSize     string                  `json:"sellingSize"`
+	Price           productSearchPrice      `json:"price"`
+	NotForSale      bool                    `json:"notForSale"`
+	QuantityMin     int                     `json:"quantityMin"`
+	QuantityMax     int                     `json:"quantityMax"`
+	QuantityUnit    string                  `json:"quantityUnit"`
+	QuantityDefault int                     `json:"quantityDefault"`
+	WeightType      string                  `json:"weightType"`
+	Categories      []productSearchCategory `json:"categories"`
+	Assets          []struct {
+		URL       string `json:"url"`
+		MaxWidth  int    `json:"maxWidth"`
+		MaxHeight int    `json:"maxHeight"`
+		AssetType string `json:"assetType"`
+	} `json:"assets"`
+}
+
+type productSearchPage struct {
+	Meta struct {
+		Pagination struct {
+			Offset     int `json:"offset"`
+			Limit      int `json:"limit"`
+			TotalCount int `json:"totalCount"`
+		} `json:"pagination"`
+	} `json:"meta"`
+	Data []productSearchProduct `json:"data"`
+}
+
+type departmentPage struct {
+	ID   string
+	page int
+}
+
+type departmentInfo struct {
+	ID           string           `json:"id"`
+	Name         string           `json:"name"`
+	URLSlugText  string           `json:"urlSlugText"`
+	Children     []departmentInfo `json:"children"`
+	ProductCount int              // Excluded from JSON deserialisation
+	Updated      time.Time        // Excluded from JSON deserialisation
+}
+
+type categoryTree struct {
+	Data []departmentInfo `json:"data"`
+}
@@ -0,0 +1,134 @@
+package analysis
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const DB_SCHEMA_VERSION = 2
+
+// The schema version initBlankDB creates. DBs older than this can't be migrated, so they're backed up
+// and recreated.
+const DB_BASELINE_SCHEMA_VERSION = 2
+
+// DB_SCHEMA brings the DB up to DB_SCHEMA_VERSION. To change the schema add a migration to the end and bump
+// DB_SCHEMA_VERSION to match.
+var DB_SCHEMA = migrate.Schema{
+	Version:         DB_SCHEMA_VERSION,
+	BaselineVersion: DB_BASELINE_SCHEMA_VERSION,
+	Baseline:        initBlankDB,
+}
+
+// Store is a grocery store whose price history can be analysed.
+type Store interface {
+	GetPromotedProducts() ([]shared.ProductInfo, error)
+	GetProductsWithPriceChanges(minEntries int) ([]shared.ProductInfo, error)
+	GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error)
+}
+
+// FindingWriter records analysis findings, E.G. to the timeseries database.
+type FindingWriter interface {
+	WriteFalseSaleDatapoint(finding shared.FalseSaleFinding)
+	WritePriceCycleDatapoint(cycle shared.PriceCycle)
+}
+
+// Analyser looks for patterns in the local price history of every store.
+type Analyser struct {
+	db     *sql.DB
+	stores []Store
+	writer FindingWriter
+	logger *slog.Logger
+}
+
+// Init opens the analysis database. The writer may be nil if findings should only be kept locally.
+func (a *Analyser) Init(dbPath string, stores []Store, writer FindingWriter) error {
+	a.logger = slog.With("component", "analysis")
+	a.stores = stores
+	a.writer = writer
+	return a.initDB(dbPath)
+}
+
+// initBlankDB creates the schema at DB_BASELINE_SCHEMA_VERSION. Don't change it, add a migration to
+// DB_SCHEMA instead.
+func initBlankDB(tx *sql.Tx) error {
+	_, err := tx.Exec(`	CREATE TABLE IF NOT EXISTS false_sales
+						(	productID TEXT,
+							name TEXT,
+							store TEXT,
+							promotionType TEXT,
+							reason TEXT,
+							priceCents INTEGER,
+							wasPriceCents INTEGER,
+							medianPriceCents INTEGER,
+							wasPriceChargedFraction REAL,
+							firstDetected DATETIME,
+							detected DATETIME,
+							UNIQUE(productID, reason)
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS price_cycles
+						(	productID TEXT PRIMARY KEY,
+							name TEXT,
+							store TEXT,
+							periodDays INTEGER,
+							strength REAL,
+							lowPriceCents INTEGER,
+							highPriceCents INTEGER,
+							nextLowStart DATETIME,
+							nextLowEnd DATETIME,
+							detected DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func openDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// initDB opens the database, bringing it up to the current schema.
+func (a *Analyser) initDB(dbPath string) error {
+	var err error
+	a.db, err = migrate.Open(dbPath, openDB, DB_SCHEMA, a.logger)
+	return err
+}
+
+// Run periodically analyses the price history of every store until cancelled.
+func (a *Analyser) Run(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		findings, err := a.DetectFalseSales(start)
+		if err != nil {
+			a.logger.Error("Failed to detect false sales", "error", err)
+		} else {
+			a.logger.Info("Detected false sales", "count", len(findings), "duration", time.Since(start))
+		}
+		start = time.Now()
+		cycles, err := a.DetectPriceCycles(start)
+		if err != nil {
+			a.logger.Error("Failed to detect price cycles", "error", err)
+		} else {
+			a.logger.Info("Detected price cycles", "count", len(cycles), "duration", time.Since(start))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
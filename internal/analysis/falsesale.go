@@ -0,0 +1,215 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// How far back to look when judging a promotion.
+const FALSE_SALE_WINDOW = 90 * 24 * time.Hour
+
+// Products need at least this much history before the current promotion to be judged.
+const FALSE_SALE_MIN_HISTORY = 28 * 24 * time.Hour
+
+// A was price charged for less than this fraction of the window is considered fictional.
+const FALSE_SALE_MIN_WAS_PRICE_FRACTION = 0.1
+
+// weightedPrice is a price that was charged for a period of time.
+type weightedPrice struct {
+	PriceCents int
+	Duration   time.Duration
+}
+
+// pricesBefore converts a price history into the prices charged between the start of the window and the
+// end, weighted by how long each was charged for.
+func pricesBefore(history []shared.PriceHistoryEntry, windowStart time.Time, end time.Time) ([]weightedPrice, time.Duration) {
+	var prices []weightedPrice
+	var total time.Duration
+	for i, entry := range history {
+		from := entry.Observed
+		if from.Before(windowStart) {
+			from = windowStart
+		}
+		to := end
+		if i+1 < len(history) && history[i+1].Observed.Before(end) {
+			to = history[i+1].Observed
+		}
+		if !to.After(from) {
+			continue
+		}
+		prices = append(prices, weightedPrice{PriceCents: entry.PriceCents, Duration: to.Sub(from)})
+		total += to.Sub(from)
+	}
+	return prices, total
+}
+
+// weightedMedian returns the price that was charged for at least half of the time.
+func weightedMedian(prices []weightedPrice) int {
+	if len(prices) == 0 {
+		return 0
+	}
+	sorted := make([]weightedPrice, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PriceCents < sorted[j].PriceCents })
+	var total, cumulative time.Duration
+	for _, p := range sorted {
+		total += p.Duration
+	}
+	for _, p := range sorted {
+		cumulative += p.Duration
+		if cumulative*2 >= total {
+			return p.PriceCents
+		}
+	}
+	return sorted[len(sorted)-1].PriceCents
+}
+
+// judgePromotion checks a promoted product's price against its history, returning any findings.
+func judgePromotion(product shared.ProductInfo, history []shared.PriceHistoryEntry, now time.Time) []shared.FalseSaleFinding {
+	var findings []shared.FalseSaleFinding
+	if len(history) == 0 {
+		return findings
+	}
+	// The promotion began when the latest price was first observed, so only look at the prices before then.
+	promotionStart := history[len(history)-1].Observed
+	prices, covered := pricesBefore(history[:len(history)-1], now.Add(-FALSE_SALE_WINDOW), promotionStart)
+	if covered < FALSE_SALE_MIN_HISTORY {
+		return findings
+	}
+
+	finding := shared.FalseSaleFinding{
+		ProductID:        product.ID,
+		Name:             product.Name,
+		Store:            product.Store,
+		PromotionType:    product.Promotion.Type,
+		PriceCents:       product.PriceCents,
+		WasPriceCents:    product.Promotion.WasPriceCents,
+		MedianPriceCents: weightedMedian(prices),
+		Detected:         now,
+	}
+
+	if finding.WasPriceCents > 0 {
+		var charged time.Duration
+		for _, p := range prices {
+			if p.PriceCents >= finding.WasPriceCents {
+				charged += p.Duration
+			}
+		}
+		finding.WasPriceChargedFraction = float64(charged) / float64(covered)
+		if finding.WasPriceChargedFraction < FALSE_SALE_MIN_WAS_PRICE_FRACTION {
+			finding.Reason = shared.FALSE_SALE_REASON_WAS_PRICE_RARELY_CHARGED
+			findings = append(findings, finding)
+		}
+	}
+
+	// A multibuy doesn't discount a single item, so its price isn't expected to be lower.
+	if product.Promotion.Type != shared.PROMOTION_TYPE_MULTIBUY && product.PriceCents >= finding.MedianPriceCents {
+		finding.Reason = shared.FALSE_SALE_REASON_NOT_BELOW_MEDIAN
+		findings = append(findings, finding)
+	}
+	return findings
+}
+
+// DetectFalseSales checks every promoted product against its price history, records the findings and
+// forgets findings for promotions that have ended or no longer look suspicious.
+func (a *Analyser) DetectFalseSales(now time.Time) ([]shared.FalseSaleFinding, error) {
+	var findings []shared.FalseSaleFinding
+	for _, store := range a.stores {
+		products, err := store.GetPromotedProducts()
+		if err != nil {
+			return findings, fmt.Errorf("failed to get promoted products: %w", err)
+		}
+		for _, product := range products {
+			history, err := store.GetProductPriceHistory(product.ID)
+			if err != nil {
+				return findings, fmt.Errorf("failed to get price history: %w", err)
+			}
+			findings = append(findings, judgePromotion(product, history, now)...)
+		}
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return findings, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, finding := range findings {
+		_, err := tx.Exec(`
+			INSERT INTO false_sales (productID, name, store, promotionType, reason, priceCents, wasPriceCents,
+				medianPriceCents, wasPriceChargedFraction, firstDetected, detected)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(productID, reason) DO UPDATE SET
+				name = excluded.name,
+				store = excluded.store,
+				promotionType = excluded.promotionType,
+				priceCents = excluded.priceCents,
+				wasPriceCents = excluded.wasPriceCents,
+				medianPriceCents = excluded.medianPriceCents,
+				wasPriceChargedFraction = excluded.wasPriceChargedFraction,
+				detected = excluded.detected`,
+			finding.ProductID, finding.Name, finding.Store, finding.PromotionType, finding.Reason, finding.PriceCents,
+			finding.WasPriceCents, finding.MedianPriceCents, finding.WasPriceChargedFraction, now, now)
+		if err != nil {
+			return findings, fmt.Errorf("failed to save false sale: %w", err)
+		}
+	}
+	_, err = tx.Exec("DELETE FROM false_sales WHERE detected != ?", now)
+	if err != nil {
+		return findings, fmt.Errorf("failed to delete stale false sales: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return findings, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if a.writer != nil {
+		for _, finding := range findings {
+			a.writer.WriteFalseSaleDatapoint(finding)
+		}
+	}
+	return findings, nil
+}
+
+// GetFalseSales returns the current findings, optionally limited to one store.
+func (a *Analyser) GetFalseSales(store string) ([]shared.FalseSaleFinding, error) {
+	var findings []shared.FalseSaleFinding
+	rows, err := a.db.Query(`
+		SELECT productID, name, store, promotionType, reason, priceCents, wasPriceCents,
+			medianPriceCents, wasPriceChargedFraction, firstDetected, detected
+		FROM false_sales
+		WHERE ? = '' OR store = ? COLLATE NOCASE
+		ORDER BY store, name, reason`, store, store)
+	if err != nil {
+		return findings, fmt.Errorf("failed to query false sales: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var finding shared.FalseSaleFinding
+		err := rows.Scan(&finding.ProductID, &finding.Name, &finding.Store, &finding.PromotionType, &finding.Reason,
+			&finding.PriceCents, &finding.WasPriceCents, &finding.MedianPriceCents, &finding.WasPriceChargedFraction,
+			&finding.FirstDetected, &finding.Detected)
+		if err != nil {
+			return findings, fmt.Errorf("failed to scan false sale: %w", err)
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+// WriteFalseSaleReport writes the findings as a human-readable table.
+func WriteFalseSaleReport(w io.Writer, findings []shared.FalseSaleFinding) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STORE\tPRODUCT\tPROMOTION\tPRICE\tWAS\tMEDIAN\tWAS CHARGED\tREASON")
+	for _, f := range findings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t$%.2f\t$%.2f\t$%.2f\t%.0f%%\t%s\n",
+			f.Store, f.Name, strings.ToLower(f.PromotionType),
+			float64(f.PriceCents)/100, float64(f.WasPriceCents)/100, float64(f.MedianPriceCents)/100,
+			f.WasPriceChargedFraction*100, strings.ReplaceAll(f.Reason, "_", " "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,156 @@
+package analysis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockStore struct {
+	products []shared.ProductInfo
+	history  map[string][]shared.PriceHistoryEntry
+}
+
+func (m *MockStore) GetPromotedProducts() ([]shared.ProductInfo, error) {
+	return m.products, nil
+}
+
+func (m *MockStore) GetProductsWithPriceChanges(minEntries int) ([]shared.ProductInfo, error) {
+	var products []shared.ProductInfo
+	for _, product := range m.products {
+		if len(m.history[product.ID]) >= minEntries {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+func (m *MockStore) GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error) {
+	return m.history[id], nil
+}
+
+type MockFindingWriter struct {
+	falseSales  []shared.FalseSaleFinding
+	priceCycles []shared.PriceCycle
+}
+
+func (m *MockFindingWriter) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {
+	m.falseSales = append(m.falseSales, finding)
+}
+
+func (m *MockFindingWriter) WritePriceCycleDatapoint(cycle shared.PriceCycle) {
+	m.priceCycles = append(m.priceCycles, cycle)
+}
+
+func daysAgo(now time.Time, days int) time.Time {
+	return now.Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+func TestWeightedMedian(t *testing.T) {
+	prices := []weightedPrice{
+		{PriceCents: 500, Duration: 10 * time.Hour},
+		{PriceCents: 250, Duration: 1 * time.Hour},
+		{PriceCents: 600, Duration: 2 * time.Hour},
+	}
+	if want, got := 500, weightedMedian(prices); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, weightedMedian(nil); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestDetectFalseSales(t *testing.T) {
+	now := time.Now()
+	store := &MockStore{
+		products: []shared.ProductInfo{
+			// The was price has never been charged and the special is the usual price.
+			{ID: "woolworths_sku_1", Name: "Cheese", Store: "Woolworths", PriceCents: 400, Promotion: shared.Promotion{Type: shared.PROMOTION_TYPE_HALF_PRICE, WasPriceCents: 800}},
+			// A genuine half price special.
+			{ID: "woolworths_sku_2", Name: "Biscuits", Store: "Woolworths", PriceCents: 250, Promotion: shared.Promotion{Type: shared.PROMOTION_TYPE_HALF_PRICE, WasPriceCents: 500}},
+			// Too new to judge.
+			{ID: "woolworths_sku_3", Name: "Crackers", Store: "Woolworths", PriceCents: 400, Promotion: shared.Promotion{Type: shared.PROMOTION_TYPE_SPECIAL, WasPriceCents: 500}},
+			// Multibuys don't discount the single price.
+			{ID: "woolworths_sku_4", Name: "Soup", Store: "Woolworths", PriceCents: 300, Promotion: shared.Promotion{Type: shared.PROMOTION_TYPE_MULTIBUY, MultibuyQuantity: 2, MultibuyPriceCents: 500}},
+		},
+		history: map[string][]shared.PriceHistoryEntry{
+			"woolworths_sku_1": {
+				{PriceCents: 400, Observed: daysAgo(now, 85)},
+				{PriceCents: 400, WasPriceCents: 800, OnSpecial: true, PromotionType: shared.PROMOTION_TYPE_HALF_PRICE, Observed: daysAgo(now, 5)},
+			},
+			"woolworths_sku_2": {
+				{PriceCents: 500, Observed: daysAgo(now, 120)},
+				{PriceCents: 250, WasPriceCents: 500, OnSpecial: true, PromotionType: shared.PROMOTION_TYPE_HALF_PRICE, Observed: daysAgo(now, 3)},
+			},
+			"woolworths_sku_3": {
+				{PriceCents: 500, Observed: daysAgo(now, 10)},
+				{PriceCents: 400, WasPriceCents: 500, OnSpecial: true, PromotionType: shared.PROMOTION_TYPE_SPECIAL, Observed: daysAgo(now, 1)},
+			},
+			"woolworths_sku_4": {
+				{PriceCents: 300, Observed: daysAgo(now, 60)},
+				{PriceCents: 300, PromotionType: shared.PROMOTION_TYPE_MULTIBUY, Observed: daysAgo(now, 2)},
+			},
+		},
+	}
+	writer := &MockFindingWriter{}
+	a := Analyser{}
+	if err := a.Init(":memory:", []Store{store}, writer); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := a.DetectFalseSales(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(findings); want != got {
+		t.Fatalf("Expected %d findings, got %d", want, got)
+	}
+	if want, got := 2, len(writer.falseSales); want != got {
+		t.Errorf("Expected %d datapoints, got %d", want, got)
+	}
+
+	saved, err := a.GetFalseSales("woolworths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(saved); want != got {
+		t.Fatalf("Expected %d findings, got %d", want, got)
+	}
+	// Ordered by reason.
+	if want, got := shared.FALSE_SALE_REASON_NOT_BELOW_MEDIAN, saved[0].Reason; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 400, saved[0].MedianPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := shared.FALSE_SALE_REASON_WAS_PRICE_RARELY_CHARGED, saved[1].Reason; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 0.0, saved[1].WasPriceChargedFraction; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+
+	var report bytes.Buffer
+	if err := WriteFalseSaleReport(&report, saved); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "was price rarely charged") {
+		t.Errorf("Expected the report to explain the finding, got %s", report.String())
+	}
+
+	// Once the promotion ends, its findings are forgotten.
+	store.products = store.products[1:]
+	if _, err := a.DetectFalseSales(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err = a.GetFalseSales("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(saved); want != got {
+		t.Errorf("Expected %d findings, got %d", want, got)
+	}
+}
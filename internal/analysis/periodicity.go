@@ -0,0 +1,251 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// How far back to look for price cycles.
+const PERIODICITY_WINDOW = 180 * 24 * time.Hour
+
+// The shortest and longest cycles we look for, in days.
+const PERIODICITY_MIN_PERIOD_DAYS = 4
+const PERIODICITY_MAX_PERIOD_DAYS = 60
+
+// A cycle must repeat at least this many times within the history to be trusted.
+const PERIODICITY_MIN_CYCLES = 3
+
+// The autocorrelation at the period must be at least this strong to count as a cycle.
+const PERIODICITY_MIN_STRENGTH = 0.5
+
+// Products need at least this many price history entries to be worth analysing. A cycle has at
+// least two price changes, a drop and a rise, per repeat.
+const PERIODICITY_MIN_PRICE_CHANGES = 2 * PERIODICITY_MIN_CYCLES
+
+// resampleDaily converts a price history into the price in effect at the same time each day, ending at
+// the given time. Days before the first recorded price are skipped.
+func resampleDaily(history []shared.PriceHistoryEntry, windowStart time.Time, end time.Time) []float64 {
+	var series []float64
+	if len(history) == 0 {
+		return series
+	}
+	start := windowStart
+	if history[0].Observed.After(start) {
+		start = history[0].Observed
+	}
+	days := int(end.Sub(start)/(24*time.Hour)) + 1
+	i := 0
+	for d := 0; d < days; d++ {
+		t := end.Add(-time.Duration(days-1-d) * 24 * time.Hour)
+		for i+1 < len(history) && !history[i+1].Observed.After(t) {
+			i++
+		}
+		series = append(series, float64(history[i].PriceCents))
+	}
+	return series
+}
+
+// autocorrelation returns the correlation of the series with itself shifted by lag days, from -1 to 1.
+func autocorrelation(series []float64, lag int) float64 {
+	if lag >= len(series) {
+		return 0
+	}
+	var mean float64
+	for _, v := range series {
+		mean += v
+	}
+	mean /= float64(len(series))
+	var variance, covariance float64
+	for i, v := range series {
+		variance += (v - mean) * (v - mean)
+		if i+lag < len(series) {
+			covariance += (v - mean) * (series[i+lag] - mean)
+		}
+	}
+	if variance == 0 {
+		return 0
+	}
+	return covariance / variance
+}
+
+// dominantPeriod returns the strongest cycle length in the series, in days, and its autocorrelation.
+// Multiples of a cycle correlate nearly as well as the cycle itself, so the shortest peak that is close
+// to the strongest is preferred. It returns 0 if there is no convincing cycle.
+func dominantPeriod(series []float64) (int, float64) {
+	maxLag := len(series) / PERIODICITY_MIN_CYCLES
+	if maxLag > PERIODICITY_MAX_PERIOD_DAYS {
+		maxLag = PERIODICITY_MAX_PERIOD_DAYS
+	}
+	if maxLag < PERIODICITY_MIN_PERIOD_DAYS {
+		return 0, 0
+	}
+	correlations := make([]float64, maxLag+2)
+	for lag := PERIODICITY_MIN_PERIOD_DAYS - 1; lag <= maxLag+1; lag++ {
+		correlations[lag] = autocorrelation(series, lag)
+	}
+	var peaks []int
+	var strongest float64
+	for lag := PERIODICITY_MIN_PERIOD_DAYS; lag <= maxLag; lag++ {
+		c := correlations[lag]
+		if c >= correlations[lag-1] && c > correlations[lag+1] && c >= PERIODICITY_MIN_STRENGTH {
+			peaks = append(peaks, lag)
+			strongest = math.Max(strongest, c)
+		}
+	}
+	for _, lag := range peaks {
+		if correlations[lag] >= 0.9*strongest {
+			return lag, correlations[lag]
+		}
+	}
+	return 0, 0
+}
+
+// findPriceCycle looks for a repeating pattern in a product's price history and predicts when the price
+// will next be low. It returns false if there is no convincing cycle.
+func findPriceCycle(product shared.ProductInfo, history []shared.PriceHistoryEntry, now time.Time) (shared.PriceCycle, bool) {
+	series := resampleDaily(history, now.Add(-PERIODICITY_WINDOW), now)
+	period, strength := dominantPeriod(series)
+	if period == 0 {
+		return shared.PriceCycle{}, false
+	}
+
+	// Average the price at each point in the cycle to find which days are typically cheap.
+	phaseMeans := make([]float64, period)
+	phaseCounts := make([]int, period)
+	for i, price := range series {
+		phaseMeans[i%period] += price
+		phaseCounts[i%period]++
+	}
+	low, high := math.Inf(1), math.Inf(-1)
+	for phase := range phaseMeans {
+		phaseMeans[phase] /= float64(phaseCounts[phase])
+		low = math.Min(low, phaseMeans[phase])
+		high = math.Max(high, phaseMeans[phase])
+	}
+	if high == low {
+		return shared.PriceCycle{}, false
+	}
+	threshold := (low + high) / 2
+	isLow := func(day int) bool { return phaseMeans[day%period] < threshold }
+
+	// The last sample is today. Walk forward to the next cheap day, then to the end of that cheap spell.
+	today := len(series) - 1
+	startDay := today
+	for !isLow(startDay) {
+		startDay++
+	}
+	endDay := startDay
+	for endDay+1 < startDay+period && isLow(endDay+1) {
+		endDay++
+	}
+
+	return shared.PriceCycle{
+		ProductID:      product.ID,
+		Name:           product.Name,
+		Store:          product.Store,
+		PeriodDays:     period,
+		Strength:       strength,
+		LowPriceCents:  int(math.Round(low)),
+		HighPriceCents: int(math.Round(high)),
+		NextLowStart:   now.Add(time.Duration(startDay-today) * 24 * time.Hour),
+		NextLowEnd:     now.Add(time.Duration(endDay-today+1) * 24 * time.Hour),
+		Detected:       now,
+	}, true
+}
+
+// DetectPriceCycles looks for price cycles in every product with enough history, records them and
+// forgets cycles that have stopped repeating.
+func (a *Analyser) DetectPriceCycles(now time.Time) ([]shared.PriceCycle, error) {
+	var cycles []shared.PriceCycle
+	for _, store := range a.stores {
+		products, err := store.GetProductsWithPriceChanges(PERIODICITY_MIN_PRICE_CHANGES)
+		if err != nil {
+			return cycles, fmt.Errorf("failed to get products with price changes: %w", err)
+		}
+		for _, product := range products {
+			history, err := store.GetProductPriceHistory(product.ID)
+			if err != nil {
+				return cycles, fmt.Errorf("failed to get price history: %w", err)
+			}
+			if cycle, ok := findPriceCycle(product, history, now); ok {
+				cycles = append(cycles, cycle)
+			}
+		}
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return cycles, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, cycle := range cycles {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO price_cycles (productID, name, store, periodDays, strength, lowPriceCents,
+				highPriceCents, nextLowStart, nextLowEnd, detected)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			cycle.ProductID, cycle.Name, cycle.Store, cycle.PeriodDays, cycle.Strength, cycle.LowPriceCents,
+			cycle.HighPriceCents, cycle.NextLowStart, cycle.NextLowEnd, now)
+		if err != nil {
+			return cycles, fmt.Errorf("failed to save price cycle: %w", err)
+		}
+	}
+	_, err = tx.Exec("DELETE FROM price_cycles WHERE detected != ?", now)
+	if err != nil {
+		return cycles, fmt.Errorf("failed to delete stale price cycles: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return cycles, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if a.writer != nil {
+		for _, cycle := range cycles {
+			a.writer.WritePriceCycleDatapoint(cycle)
+		}
+	}
+	return cycles, nil
+}
+
+const priceCycleQuery = `
+	SELECT productID, name, store, periodDays, strength, lowPriceCents, highPriceCents,
+		nextLowStart, nextLowEnd, detected
+	FROM price_cycles`
+
+// queryPriceCycles runs priceCycleQuery with the given conditions.
+func (a *Analyser) queryPriceCycles(conditions string, args ...interface{}) ([]shared.PriceCycle, error) {
+	var cycles []shared.PriceCycle
+	rows, err := a.db.Query(priceCycleQuery+" "+conditions, args...)
+	if err != nil {
+		return cycles, fmt.Errorf("failed to query price cycles: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cycle shared.PriceCycle
+		err := rows.Scan(&cycle.ProductID, &cycle.Name, &cycle.Store, &cycle.PeriodDays, &cycle.Strength,
+			&cycle.LowPriceCents, &cycle.HighPriceCents, &cycle.NextLowStart, &cycle.NextLowEnd, &cycle.Detected)
+		if err != nil {
+			return cycles, fmt.Errorf("failed to scan price cycle: %w", err)
+		}
+		cycles = append(cycles, cycle)
+	}
+	return cycles, nil
+}
+
+// GetPriceCycle returns the price cycle of a single product, or shared.ErrProductMissing if it has none.
+func (a *Analyser) GetPriceCycle(productID string) (shared.PriceCycle, error) {
+	cycles, err := a.queryPriceCycles("WHERE productID = ?", productID)
+	if err != nil {
+		return shared.PriceCycle{}, err
+	}
+	if len(cycles) == 0 {
+		return shared.PriceCycle{}, shared.ErrProductMissing
+	}
+	return cycles[0], nil
+}
+
+// GetPriceCycles returns every known price cycle, optionally limited to one store, soonest low first.
+func (a *Analyser) GetPriceCycles(store string) ([]shared.PriceCycle, error) {
+	return a.queryPriceCycles("WHERE ? = '' OR store = ? COLLATE NOCASE ORDER BY nextLowStart, name", store, store)
+}
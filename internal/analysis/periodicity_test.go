@@ -0,0 +1,136 @@
+package analysis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// fortnightlyHistory builds a price history that is $4.50 for ten days then $3.00 for four, starting
+// 130 days before now. That leaves now four days into a cycle, six days before the next low.
+func fortnightlyHistory(now time.Time) []shared.PriceHistoryEntry {
+	var history []shared.PriceHistoryEntry
+	for start := daysAgo(now, 130); !start.After(now); start = start.Add(14 * 24 * time.Hour) {
+		history = append(history,
+			shared.PriceHistoryEntry{PriceCents: 450, Observed: start},
+			shared.PriceHistoryEntry{PriceCents: 300, OnSpecial: true, Observed: start.Add(10 * 24 * time.Hour)},
+		)
+	}
+	return history
+}
+
+func TestResampleDaily(t *testing.T) {
+	now := time.Now()
+	history := []shared.PriceHistoryEntry{
+		{PriceCents: 100, Observed: daysAgo(now, 3)},
+		{PriceCents: 200, Observed: daysAgo(now, 1).Add(time.Hour)},
+	}
+	series := resampleDaily(history, daysAgo(now, 10), now)
+	if want, got := 4, len(series); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	for i, want := range []float64{100, 100, 100, 200} {
+		if got := series[i]; want != got {
+			t.Errorf("Expected %f, got %f", want, got)
+		}
+	}
+}
+
+func TestFindPriceCycle(t *testing.T) {
+	now := time.Now()
+	product := shared.ProductInfo{ID: "woolworths_sku_1", Name: "Navel Oranges", Store: "Woolworths"}
+
+	cycle, ok := findPriceCycle(product, fortnightlyHistory(now), now)
+	if !ok {
+		t.Fatal("Expected a price cycle")
+	}
+	if want, got := 14, cycle.PeriodDays; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300, cycle.LowPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 450, cycle.HighPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 6, cycle.DaysUntilLow(now); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := now.Add(10*24*time.Hour), cycle.NextLowEnd; !want.Equal(got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A single price change isn't a cycle.
+	history := []shared.PriceHistoryEntry{
+		{PriceCents: 450, Observed: daysAgo(now, 100)},
+		{PriceCents: 500, Observed: daysAgo(now, 50)},
+	}
+	if _, ok := findPriceCycle(product, history, now); ok {
+		t.Errorf("Expected no price cycle")
+	}
+
+	// Nor is a steady price.
+	history = []shared.PriceHistoryEntry{{PriceCents: 450, Observed: daysAgo(now, 100)}}
+	if _, ok := findPriceCycle(product, history, now); ok {
+		t.Errorf("Expected no price cycle")
+	}
+}
+
+func TestDetectPriceCycles(t *testing.T) {
+	now := time.Now()
+	store := &MockStore{
+		products: []shared.ProductInfo{
+			{ID: "woolworths_sku_1", Name: "Navel Oranges", Store: "Woolworths", PriceCents: 450},
+			{ID: "woolworths_sku_2", Name: "Apples", Store: "Woolworths", PriceCents: 500},
+		},
+		history: map[string][]shared.PriceHistoryEntry{
+			"woolworths_sku_1": fortnightlyHistory(now),
+			"woolworths_sku_2": {{PriceCents: 500, Observed: daysAgo(now, 100)}},
+		},
+	}
+	writer := &MockFindingWriter{}
+	a := Analyser{}
+	if err := a.Init(":memory:", []Store{store}, writer); err != nil {
+		t.Fatal(err)
+	}
+
+	cycles, err := a.DetectPriceCycles(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(cycles); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(writer.priceCycles); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	cycle, err := a.GetPriceCycle("woolworths_sku_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 14, cycle.PeriodDays; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if _, err := a.GetPriceCycle("woolworths_sku_2"); !errors.Is(err, shared.ErrProductMissing) {
+		t.Errorf("Expected ErrProductMissing, got %v", err)
+	}
+	cycles, err = a.GetPriceCycles("Coles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(cycles); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Once the cycle stops it is forgotten.
+	store.history["woolworths_sku_1"] = []shared.PriceHistoryEntry{{PriceCents: 450, Observed: daysAgo(now, 100)}}
+	if _, err := a.DetectPriceCycles(now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GetPriceCycle("woolworths_sku_1"); !errors.Is(err, shared.ErrProductMissing) {
+		t.Errorf("Expected ErrProductMissing, got %v", err)
+	}
+}
@@ -0,0 +1,703 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/catalogue"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/watchlist"
+)
+
+const DEFAULT_SEARCH_LIMIT = 50
+const MAX_SEARCH_LIMIT = 500
+const DEFAULT_ALERT_LIMIT = 50
+const API_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// The largest request body accepted, E.G. when adding a watch.
+const MAX_REQUEST_BODY_BYTES = 1 << 16
+
+// ProductStore is a grocery store whose locally cached data can be served by the API.
+type ProductStore interface {
+	StoreName() string
+	SearchProducts(query string, limit int) ([]shared.ProductInfo, error)
+	GetProduct(id string) (shared.ProductInfo, error)
+	GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error)
+	GetDepartments() ([]shared.DepartmentInfo, error)
+}
+
+// BarcodeIndex finds the store products matching a barcode.
+type BarcodeIndex interface {
+	LookupBarcode(barcode string) ([]catalogue.BarcodeMatch, error)
+}
+
+// FalseSaleSource provides the promotions flagged as false sales.
+type FalseSaleSource interface {
+	GetFalseSales(store string) ([]shared.FalseSaleFinding, error)
+}
+
+// PriceCycleSource provides the price cycles found in product price histories.
+type PriceCycleSource interface {
+	GetPriceCycle(productID string) (shared.PriceCycle, error)
+	GetPriceCycles(store string) ([]shared.PriceCycle, error)
+}
+
+// Watchlist keeps users' watches on product prices and the alerts they have triggered.
+type Watchlist interface {
+	AddWatch(user, productID, rule string, targetPriceCents int) (watchlist.Watch, error)
+	RemoveWatch(user string, watchID int64) error
+	GetWatches(user string) ([]watchlist.Watch, error)
+	GetAlerts(user string, limit int) ([]watchlist.Alert, error)
+}
+
+// ReadinessCheck is something that has to be working for the service to be ready, E.G. a store's local DB.
+// Check returns why it isn't.
+type ReadinessCheck struct {
+	Name  string
+	Check func() error
+}
+
+// Server serves a JSON API over the local product databases. It's read-only, apart from the watchlist.
+type Server struct {
+	stores     []ProductStore
+	barcodes   BarcodeIndex
+	falseSales FalseSaleSource
+	cycles     PriceCycleSource
+	watchlist  Watchlist
+	checks     []ReadinessCheck
+	mux        *http.ServeMux
+	logger     *slog.Logger
+}
+
+type productResponse struct {
+	ID                 string             `json:"id"`
+	Name               string             `json:"name"`
+	Description        string             `json:"description"`
+	Barcode            string             `json:"barcode"`
+	Store              string             `json:"store"`
+	Department         string             `json:"department"`
+	PriceCents         int                `json:"price_cents"`
+	PreviousPriceCents int                `json:"previous_price_cents"`
+	WeightGrams        int                `json:"weight_grams"`
+	UnitPriceCents     float64            `json:"unit_price_cents"`
+	UnitType           string             `json:"unit_type"`
+	Promotion          *promotionResponse `json:"promotion,omitempty"`
+	Updated            time.Time          `json:"updated"`
+}
+
+type promotionResponse struct {
+	Type               string `json:"type"`
+	WasPriceCents      int    `json:"was_price_cents"`
+	MultibuyQuantity   int    `json:"multibuy_quantity"`
+	MultibuyPriceCents int    `json:"multibuy_price_cents"`
+	MemberOnly         bool   `json:"member_only"`
+}
+
+type priceHistoryResponse struct {
+	PriceCents    int       `json:"price_cents"`
+	WasPriceCents int       `json:"was_price_cents"`
+	OnSpecial     bool      `json:"on_special"`
+	PromotionType string    `json:"promotion_type"`
+	Observed      time.Time `json:"observed"`
+}
+
+type departmentResponse struct {
+	ID            string             `json:"id"`
+	Description   string             `json:"description"`
+	Store         string             `json:"store"`
+	ProductCount  int                `json:"product_count"`
+	Updated       time.Time          `json:"updated"`
+	LastRefreshed *time.Time         `json:"last_refreshed"`
+	LastRun       *scrapeRunResponse `json:"last_run"`
+}
+
+type scrapeRunResponse struct {
+	Started         time.Time  `json:"started"`
+	Finished        *time.Time `json:"finished"`
+	Pages           int        `json:"pages"`
+	FailedPages     int        `json:"failed_pages"`
+	ChangedProducts int        `json:"changed_products"`
+}
+
+type barcodeMatchResponse struct {
+	Product productResponse        `json:"product"`
+	History []priceHistoryResponse `json:"history"`
+	Source  string                 `json:"source"`
+}
+
+type barcodeResponse struct {
+	GTIN    string                 `json:"gtin"`
+	Matches []barcodeMatchResponse `json:"matches"`
+}
+
+type falseSaleResponse struct {
+	ProductID               string    `json:"product_id"`
+	Name                    string    `json:"name"`
+	Store                   string    `json:"store"`
+	PromotionType           string    `json:"promotion_type"`
+	Reason                  string    `json:"reason"`
+	PriceCents              int       `json:"price_cents"`
+	WasPriceCents           int       `json:"was_price_cents"`
+	MedianPriceCents        int       `json:"median_price_cents"`
+	WasPriceChargedFraction float64   `json:"was_price_charged_fraction"`
+	FirstDetected           time.Time `json:"first_detected"`
+	Detected                time.Time `json:"detected"`
+}
+
+type priceCycleResponse struct {
+	ProductID      string    `json:"product_id"`
+	Name           string    `json:"name"`
+	Store          string    `json:"store"`
+	PeriodDays     int       `json:"period_days"`
+	Strength       float64   `json:"strength"`
+	LowPriceCents  int       `json:"low_price_cents"`
+	HighPriceCents int       `json:"high_price_cents"`
+	NextLowStart   time.Time `json:"next_low_start"`
+	NextLowEnd     time.Time `json:"next_low_end"`
+	DaysUntilLow   int       `json:"days_until_low"`
+	Detected       time.Time `json:"detected"`
+}
+
+type watchRequest struct {
+	ProductID        string `json:"product_id"`
+	Rule             string `json:"rule"`
+	TargetPriceCents int    `json:"target_price_cents"`
+}
+
+type watchResponse struct {
+	ID               int64     `json:"id"`
+	ProductID        string    `json:"product_id"`
+	Rule             string    `json:"rule"`
+	TargetPriceCents int       `json:"target_price_cents"`
+	Created          time.Time `json:"created"`
+}
+
+type alertResponse struct {
+	ID                 int64     `json:"id"`
+	WatchID            int64     `json:"watch_id"`
+	ProductID          string    `json:"product_id"`
+	Name               string    `json:"name"`
+	Store              string    `json:"store"`
+	Rule               string    `json:"rule"`
+	TargetPriceCents   int       `json:"target_price_cents"`
+	PreviousPriceCents int       `json:"previous_price_cents"`
+	PriceCents         int       `json:"price_cents"`
+	Triggered          time.Time `json:"triggered"`
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewServer creates a Server backed by the given stores.
+func NewServer(stores []ProductStore) *Server {
+	s := &Server{
+		stores: stores,
+		mux:    http.NewServeMux(),
+		logger: slog.With("component", "api"),
+	}
+	s.mux.HandleFunc("GET /products", s.handleSearchProducts)
+	s.mux.HandleFunc("GET /products/{id}", s.handleGetProduct)
+	s.mux.HandleFunc("GET /products/{id}/history", s.handleGetProductHistory)
+	s.mux.HandleFunc("GET /departments", s.handleGetDepartments)
+	return s
+}
+
+// ServeBarcodes enables barcode lookups against the given index.
+func (s *Server) ServeBarcodes(index BarcodeIndex) {
+	s.barcodes = index
+	s.mux.HandleFunc("GET /barcodes/{gtin}", s.handleGetBarcode)
+}
+
+// ServeFalseSales enables the false sale report.
+func (s *Server) ServeFalseSales(source FalseSaleSource) {
+	s.falseSales = source
+	s.mux.HandleFunc("GET /false-sales", s.handleGetFalseSales)
+}
+
+// ServePriceCycles enables price cycle predictions.
+func (s *Server) ServePriceCycles(source PriceCycleSource) {
+	s.cycles = source
+	s.mux.HandleFunc("GET /products/{id}/cycle", s.handleGetProductCycle)
+	s.mux.HandleFunc("GET /price-cycles", s.handleGetPriceCycles)
+}
+
+// ServeWatchlist enables managing users' watchlists and reading their alerts. These are the only routes that
+// write, and the API doesn't know who its users are, so every request must carry the token as
+// "Authorization: Bearer <token>". Whoever holds it, E.G. a bot or web frontend that signs its own users in,
+// is trusted to only act for the user in the path. Without a token nothing is served.
+func (s *Server) ServeWatchlist(wl Watchlist, token string) {
+	s.watchlist = wl
+	s.mux.HandleFunc("GET /users/{user}/watchlist", s.requireToken(token, s.handleGetWatchlist))
+	s.mux.HandleFunc("POST /users/{user}/watchlist", s.requireToken(token, s.handleAddWatch))
+	s.mux.HandleFunc("DELETE /users/{user}/watchlist/{id}", s.requireToken(token, s.handleRemoveWatch))
+	s.mux.HandleFunc("GET /users/{user}/alerts", s.requireToken(token, s.handleGetAlerts))
+}
+
+// requireToken responds 401 Unauthorized to requests that don't carry the bearer token, or to every request
+// if the token is empty.
+func (s *Server) requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(want, got) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			s.writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ServeHealth enables /healthz, which reports the process is up, and /readyz, which runs the given checks
+// and reports 503 Service Unavailable if any of them fail. Only each check's name and status is reported.
+func (s *Server) ServeHealth(checks []ReadinessCheck) {
+	s.checks = checks
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+}
+
+// ServeMetrics enables /metrics, E.G. for Prometheus to scrape.
+func (s *Server) ServeMetrics(handler http.Handler) {
+	s.mux.Handle("GET /metrics", handler)
+}
+
+// Handler returns the http.Handler serving the API.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves the API on the given address until an error occurs or the context is cancelled,
+// in which case in-flight requests are given API_SHUTDOWN_TIMEOUT to finish.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	s.logger.Info("Starting HTTP API", "address", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), API_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down HTTP API: %w", err)
+	}
+	return nil
+}
+
+func newProductResponse(info shared.ProductInfo) productResponse {
+	response := productResponse{
+		ID:                 info.ID,
+		Name:               info.Name,
+		Description:        info.Description,
+		Barcode:            info.Barcode,
+		Store:              info.Store,
+		Department:         info.Department,
+		PriceCents:         info.PriceCents,
+		PreviousPriceCents: info.PreviousPriceCents,
+		WeightGrams:        info.WeightGrams,
+		UnitPriceCents:     info.UnitPriceCents,
+		UnitType:           info.UnitType,
+		Updated:            info.Timestamp,
+	}
+	if info.Promotion.Active() {
+		response.Promotion = &promotionResponse{
+			Type:               info.Promotion.Type,
+			WasPriceCents:      info.Promotion.WasPriceCents,
+			MultibuyQuantity:   info.Promotion.MultibuyQuantity,
+			MultibuyPriceCents: info.Promotion.MultibuyPriceCents,
+			MemberOnly:         info.Promotion.MemberOnly,
+		}
+	}
+	return response
+}
+
+func newPriceHistoryResponse(history []shared.PriceHistoryEntry) []priceHistoryResponse {
+	response := []priceHistoryResponse{}
+	for _, entry := range history {
+		response = append(response, priceHistoryResponse{
+			PriceCents:    entry.PriceCents,
+			WasPriceCents: entry.WasPriceCents,
+			OnSpecial:     entry.OnSpecial,
+			PromotionType: entry.PromotionType,
+			Observed:      entry.Observed,
+		})
+	}
+	return response
+}
+
+func newPriceCycleResponse(cycle shared.PriceCycle, now time.Time) priceCycleResponse {
+	return priceCycleResponse{
+		ProductID:      cycle.ProductID,
+		Name:           cycle.Name,
+		Store:          cycle.Store,
+		PeriodDays:     cycle.PeriodDays,
+		Strength:       cycle.Strength,
+		LowPriceCents:  cycle.LowPriceCents,
+		HighPriceCents: cycle.HighPriceCents,
+		NextLowStart:   cycle.NextLowStart,
+		NextLowEnd:     cycle.NextLowEnd,
+		DaysUntilLow:   cycle.DaysUntilLow(now),
+		Detected:       cycle.Detected,
+	}
+}
+
+// writeJSON writes the given value as a JSON response with the given status code.
+func (s *Server) writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		s.logger.Error("Failed to encode response", "error", err)
+	}
+}
+
+// writeError writes an error response, hiding the details of internal errors from the client.
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		s.logger.Error("Error serving request", "error", err)
+		message = "internal error"
+	}
+	s.writeJSON(w, status, errorResponse{Error: message})
+}
+
+// storesMatching returns the stores matching the given name, or all stores if the name is empty.
+func (s *Server) storesMatching(name string) []ProductStore {
+	if name == "" {
+		return s.stores
+	}
+	var stores []ProductStore
+	for _, store := range s.stores {
+		if strings.EqualFold(store.StoreName(), name) {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// findProduct asks each store for the product until one of them has it.
+func (s *Server) findProduct(id string) (shared.ProductInfo, ProductStore, error) {
+	for _, store := range s.stores {
+		product, err := store.GetProduct(id)
+		if errors.Is(err, shared.ErrProductMissing) {
+			continue
+		}
+		return product, store, err
+	}
+	return shared.ProductInfo{}, nil, shared.ErrProductMissing
+}
+
+func (s *Server) handleSearchProducts(w http.ResponseWriter, r *http.Request) {
+	limit := DEFAULT_SEARCH_LIMIT
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+			s.writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(MAX_SEARCH_LIMIT)))
+			return
+		}
+	}
+	response := []productResponse{}
+	for _, store := range s.storesMatching(r.URL.Query().Get("store")) {
+		products, err := store.SearchProducts(r.URL.Query().Get("q"), limit)
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, product := range products {
+			response = append(response, newProductResponse(product))
+		}
+	}
+	if len(response) > limit {
+		response = response[:limit]
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetProduct(w http.ResponseWriter, r *http.Request) {
+	product, _, err := s.findProduct(r.PathValue("id"))
+	if errors.Is(err, shared.ErrProductMissing) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newProductResponse(product))
+}
+
+func (s *Server) handleGetProductHistory(w http.ResponseWriter, r *http.Request) {
+	_, store, err := s.findProduct(r.PathValue("id"))
+	if errors.Is(err, shared.ErrProductMissing) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	history, err := store.GetProductPriceHistory(r.PathValue("id"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newPriceHistoryResponse(history))
+}
+
+func (s *Server) handleGetDepartments(w http.ResponseWriter, r *http.Request) {
+	response := []departmentResponse{}
+	for _, store := range s.storesMatching(r.URL.Query().Get("store")) {
+		departments, err := store.GetDepartments()
+		if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		for _, dept := range departments {
+			department := departmentResponse{
+				ID:            dept.ID,
+				Description:   dept.Description,
+				Store:         dept.Store,
+				ProductCount:  dept.ProductCount,
+				Updated:       dept.Updated,
+				LastRefreshed: timeOrNil(dept.LastRefreshed),
+			}
+			if run := dept.LastRun; run != nil {
+				department.LastRun = &scrapeRunResponse{
+					Started:         run.Started,
+					Finished:        timeOrNil(run.Finished),
+					Pages:           run.Pages,
+					FailedPages:     run.FailedPages,
+					ChangedProducts: run.ChangedProducts,
+				}
+			}
+			response = append(response, department)
+		}
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// timeOrNil returns nil for the zero time, so it's null in the JSON rather than year 1.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (s *Server) handleGetBarcode(w http.ResponseWriter, r *http.Request) {
+	gtin, err := catalogue.NormaliseGTIN(r.PathValue("gtin"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	matches, err := s.barcodes.LookupBarcode(gtin)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(matches) == 0 {
+		s.writeError(w, http.StatusNotFound, shared.ErrProductMissing)
+		return
+	}
+	response := barcodeResponse{GTIN: gtin, Matches: []barcodeMatchResponse{}}
+	for _, match := range matches {
+		response.Matches = append(response.Matches, barcodeMatchResponse{
+			Product: newProductResponse(match.Product),
+			History: newPriceHistoryResponse(match.History),
+			Source:  match.Source,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetFalseSales(w http.ResponseWriter, r *http.Request) {
+	findings, err := s.falseSales.GetFalseSales(r.URL.Query().Get("store"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := []falseSaleResponse{}
+	for _, f := range findings {
+		response = append(response, falseSaleResponse{
+			ProductID:               f.ProductID,
+			Name:                    f.Name,
+			Store:                   f.Store,
+			PromotionType:           f.PromotionType,
+			Reason:                  f.Reason,
+			PriceCents:              f.PriceCents,
+			WasPriceCents:           f.WasPriceCents,
+			MedianPriceCents:        f.MedianPriceCents,
+			WasPriceChargedFraction: f.WasPriceChargedFraction,
+			FirstDetected:           f.FirstDetected,
+			Detected:                f.Detected,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetProductCycle(w http.ResponseWriter, r *http.Request) {
+	cycle, err := s.cycles.GetPriceCycle(r.PathValue("id"))
+	if errors.Is(err, shared.ErrProductMissing) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, newPriceCycleResponse(cycle, time.Now()))
+}
+
+func (s *Server) handleGetPriceCycles(w http.ResponseWriter, r *http.Request) {
+	cycles, err := s.cycles.GetPriceCycles(r.URL.Query().Get("store"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	response := []priceCycleResponse{}
+	for _, cycle := range cycles {
+		response = append(response, newPriceCycleResponse(cycle, now))
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func newWatchResponse(watch watchlist.Watch) watchResponse {
+	return watchResponse{
+		ID:               watch.ID,
+		ProductID:        watch.ProductID,
+		Rule:             watch.Rule,
+		TargetPriceCents: watch.TargetPriceCents,
+		Created:          watch.Created,
+	}
+}
+
+func (s *Server) handleGetWatchlist(w http.ResponseWriter, r *http.Request) {
+	watches, err := s.watchlist.GetWatches(r.PathValue("user"))
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := []watchResponse{}
+	for _, watch := range watches {
+		response = append(response, newWatchResponse(watch))
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleAddWatch(w http.ResponseWriter, r *http.Request) {
+	var request watchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY_BYTES)).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+	// Canonical products aren't held by any one store, so only store products can be checked.
+	if !strings.HasPrefix(request.ProductID, catalogue.CANONICAL_ID_PREFIX) {
+		if _, _, err := s.findProduct(request.ProductID); errors.Is(err, shared.ErrProductMissing) {
+			s.writeError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			s.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	watch, err := s.watchlist.AddWatch(r.PathValue("user"), request.ProductID, request.Rule, request.TargetPriceCents)
+	if errors.Is(err, watchlist.ErrInvalidWatch) {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.writeJSON(w, http.StatusCreated, newWatchResponse(watch))
+}
+
+func (s *Server) handleRemoveWatch(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, errors.New("invalid watch ID"))
+		return
+	}
+	err = s.watchlist.RemoveWatch(r.PathValue("user"), id)
+	if errors.Is(err, watchlist.ErrWatchMissing) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetAlerts(w http.ResponseWriter, r *http.Request) {
+	limit := DEFAULT_ALERT_LIMIT
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || limit > MAX_SEARCH_LIMIT {
+			s.writeError(w, http.StatusBadRequest, errors.New("limit must be between 1 and "+strconv.Itoa(MAX_SEARCH_LIMIT)))
+			return
+		}
+	}
+	alerts, err := s.watchlist.GetAlerts(r.PathValue("user"), limit)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := []alertResponse{}
+	for _, alert := range alerts {
+		response = append(response, alertResponse{
+			ID:                 alert.ID,
+			WatchID:            alert.WatchID,
+			ProductID:          alert.ProductID,
+			Name:               alert.Name,
+			Store:              alert.Store,
+			Rule:               alert.Rule,
+			TargetPriceCents:   alert.TargetPriceCents,
+			PreviousPriceCents: alert.PreviousPriceCents,
+			PriceCents:         alert.PriceCents,
+			Triggered:          alert.Triggered,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	response := healthResponse{Status: "ok", Checks: make(map[string]string)}
+	status := http.StatusOK
+	for _, check := range s.checks {
+		if err := check.Check(); err != nil {
+			// The endpoint isn't authenticated, so why it failed is only logged.
+			s.logger.Warn("Readiness check failed", "check", check.Name, "error", err)
+			response.Checks[check.Name] = "failing"
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[check.Name] = "ok"
+	}
+	s.writeJSON(w, status, response)
+}
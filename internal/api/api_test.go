@@ -0,0 +1,583 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/catalogue"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/watchlist"
+)
+
+type MockStore struct {
+	name        string
+	prefix      string
+	products    []shared.ProductInfo
+	history     map[string][]shared.PriceHistoryEntry
+	departments []shared.DepartmentInfo
+}
+
+func (m *MockStore) StoreName() string {
+	return m.name
+}
+
+func (m *MockStore) SearchProducts(query string, limit int) ([]shared.ProductInfo, error) {
+	var products []shared.ProductInfo
+	for _, product := range m.products {
+		if strings.Contains(product.Name, query) && len(products) < limit {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+func (m *MockStore) GetProduct(id string) (shared.ProductInfo, error) {
+	for _, product := range m.products {
+		if product.ID == id {
+			return product, nil
+		}
+	}
+	return shared.ProductInfo{}, shared.ErrProductMissing
+}
+
+func (m *MockStore) GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error) {
+	return m.history[id], nil
+}
+
+func (m *MockStore) GetDepartments() ([]shared.DepartmentInfo, error) {
+	return m.departments, nil
+}
+
+func getTestServer() *Server {
+	woolworths := &MockStore{
+		name: "Woolworths",
+		products: []shared.ProductInfo{
+			{ID: "woolworths_sku_1", Name: "Bananas", Store: "Woolworths", PriceCents: 400},
+			{ID: "woolworths_sku_2", Name: "Apples", Store: "Woolworths", PriceCents: 500},
+		},
+		history: map[string][]shared.PriceHistoryEntry{
+			"woolworths_sku_1": {
+				{PriceCents: 450, Observed: time.Now().Add(-time.Hour)},
+				{PriceCents: 400, WasPriceCents: 450, OnSpecial: true, PromotionType: "SPECIAL", Observed: time.Now()},
+			},
+		},
+		departments: []shared.DepartmentInfo{{ID: "1-E5BEE36E", Description: "Fruit & Veg", Store: "Woolworths", ProductCount: 470,
+			LastRefreshed: time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC),
+			LastRun:       &shared.ScrapeRun{Started: time.Date(2024, 9, 2, 0, 0, 0, 0, time.UTC), Pages: 12, FailedPages: 3, ChangedProducts: 40}}},
+	}
+	coles := &MockStore{
+		name: "Coles",
+		products: []shared.ProductInfo{
+			{ID: "coles_id_1", Name: "Bananas Mini Pack", Store: "Coles", PriceCents: 450, Promotion: shared.Promotion{Type: "SPECIAL", WasPriceCents: 500}},
+		},
+		departments: []shared.DepartmentInfo{{ID: "fruit-vegetables", Description: "Fruit & Vegetables", Store: "Coles", ProductCount: 578}},
+	}
+	return NewServer([]ProductStore{woolworths, coles})
+}
+
+func get(t *testing.T, s *Server, url string, response interface{}) int {
+	return do(t, s, httptest.NewRequest("GET", url, nil), response)
+}
+
+// do makes a request and decodes the JSON response, returning the status code.
+func do(t *testing.T, s *Server, req *http.Request, response interface{}) int {
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if want, got := "application/json", rec.Header().Get("Content-Type"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), response); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rec.Body.String(), err)
+	}
+	return rec.Code
+}
+
+func TestSearchProducts(t *testing.T) {
+	s := getTestServer()
+
+	var products []productResponse
+	if want, got := http.StatusOK, get(t, s, "/products?q=Bananas", &products); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := "woolworths_sku_1", products[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if want, got := http.StatusOK, get(t, s, "/products?q=Bananas&store=coles", &products); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := 450, products[0].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	if want, got := http.StatusOK, get(t, s, "/products?limit=1", &products); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(products); want != got {
+		t.Errorf("Expected %d products, got %d", want, got)
+	}
+
+	var errResponse errorResponse
+	if want, got := http.StatusBadRequest, get(t, s, "/products?limit=banana", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	s := getTestServer()
+
+	var product productResponse
+	if want, got := http.StatusOK, get(t, s, "/products/coles_id_1", &product); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "Bananas Mini Pack", product.Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if product.Promotion == nil {
+		t.Fatal("Expected a promotion")
+	}
+	if want, got := 500, product.Promotion.WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	var errResponse errorResponse
+	if want, got := http.StatusNotFound, get(t, s, "/products/coles_id_2", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := shared.ErrProductMissing.Error(), errResponse.Error; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestGetProductHistory(t *testing.T) {
+	s := getTestServer()
+
+	var history []priceHistoryResponse
+	if want, got := http.StatusOK, get(t, s, "/products/woolworths_sku_1/history", &history); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(history); want != got {
+		t.Fatalf("Expected %d entries, got %d", want, got)
+	}
+	if want, got := 450, history[1].WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// A product with no recorded history should return an empty list, not null.
+	if want, got := http.StatusOK, get(t, s, "/products/woolworths_sku_2/history", &history); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if history == nil || len(history) != 0 {
+		t.Errorf("Expected an empty history, got %v", history)
+	}
+
+	var errResponse errorResponse
+	if want, got := http.StatusNotFound, get(t, s, "/products/woolworths_sku_3/history", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestGetDepartments(t *testing.T) {
+	s := getTestServer()
+
+	var departments []departmentResponse
+	if want, got := http.StatusOK, get(t, s, "/departments", &departments); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := http.StatusOK, get(t, s, "/departments?store=Woolworths", &departments); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := 470, departments[0].ProductCount; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if departments[0].LastRefreshed == nil || !departments[0].LastRefreshed.Equal(time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the department to have been refreshed on 2024-09-01, got %v", departments[0].LastRefreshed)
+	}
+	if departments[0].LastRun == nil {
+		t.Fatalf("Expected a last run")
+	}
+	if want, got := 3, departments[0].LastRun.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if departments[0].LastRun.Finished != nil {
+		t.Errorf("Expected the run to be in progress, got finished at %v", departments[0].LastRun.Finished)
+	}
+
+	if want, got := http.StatusOK, get(t, s, "/departments?store=Coles", &departments); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if departments[0].LastRefreshed != nil || departments[0].LastRun != nil {
+		t.Errorf("Expected a department that's never been scraped to have no freshness, got %v and %v", departments[0].LastRefreshed, departments[0].LastRun)
+	}
+}
+
+type MockBarcodeIndex struct {
+	matches map[string][]catalogue.BarcodeMatch
+}
+
+func (m *MockBarcodeIndex) LookupBarcode(barcode string) ([]catalogue.BarcodeMatch, error) {
+	return m.matches[barcode], nil
+}
+
+func TestGetBarcode(t *testing.T) {
+	s := getTestServer()
+	s.ServeBarcodes(&MockBarcodeIndex{matches: map[string][]catalogue.BarcodeMatch{
+		"09300633050559": {
+			{
+				Product: shared.ProductInfo{ID: "woolworths_sku_1", Name: "Bananas", Barcode: "9300633050559", PriceCents: 400},
+				History: []shared.PriceHistoryEntry{{PriceCents: 400, Observed: time.Now()}},
+				Source:  catalogue.BARCODE_SOURCE_STORE,
+			},
+			{
+				Product: shared.ProductInfo{ID: "coles_id_1", Name: "Bananas Mini Pack", PriceCents: 450},
+				Source:  catalogue.BARCODE_SOURCE_MANUAL,
+			},
+		},
+	}})
+
+	var response barcodeResponse
+	if want, got := http.StatusOK, get(t, s, "/barcodes/9300633050559", &response); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "09300633050559", response.GTIN; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 2, len(response.Matches); want != got {
+		t.Fatalf("Expected %d matches, got %d", want, got)
+	}
+	if want, got := 1, len(response.Matches[0].History); want != got {
+		t.Errorf("Expected %d entries, got %d", want, got)
+	}
+	if want, got := catalogue.BARCODE_SOURCE_MANUAL, response.Matches[1].Source; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	var errResponse errorResponse
+	if want, got := http.StatusNotFound, get(t, s, "/barcodes/12345670", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := http.StatusBadRequest, get(t, s, "/barcodes/banana", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+type MockFalseSaleSource struct {
+	findings []shared.FalseSaleFinding
+}
+
+func (m *MockFalseSaleSource) GetFalseSales(store string) ([]shared.FalseSaleFinding, error) {
+	var findings []shared.FalseSaleFinding
+	for _, f := range m.findings {
+		if store == "" || strings.EqualFold(f.Store, store) {
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+func TestGetFalseSales(t *testing.T) {
+	s := getTestServer()
+	s.ServeFalseSales(&MockFalseSaleSource{findings: []shared.FalseSaleFinding{
+		{ProductID: "woolworths_sku_1", Name: "Bananas", Store: "Woolworths", Reason: shared.FALSE_SALE_REASON_NOT_BELOW_MEDIAN, PriceCents: 400, MedianPriceCents: 400},
+		{ProductID: "coles_id_1", Name: "Bananas Mini Pack", Store: "Coles", Reason: shared.FALSE_SALE_REASON_WAS_PRICE_RARELY_CHARGED, WasPriceCents: 500},
+	}})
+
+	var findings []falseSaleResponse
+	if want, got := http.StatusOK, get(t, s, "/false-sales", &findings); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(findings); want != got {
+		t.Fatalf("Expected %d findings, got %d", want, got)
+	}
+	if want, got := http.StatusOK, get(t, s, "/false-sales?store=coles", &findings); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(findings); want != got {
+		t.Fatalf("Expected %d findings, got %d", want, got)
+	}
+	if want, got := shared.FALSE_SALE_REASON_WAS_PRICE_RARELY_CHARGED, findings[0].Reason; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+type MockPriceCycleSource struct {
+	cycles []shared.PriceCycle
+}
+
+func (m *MockPriceCycleSource) GetPriceCycle(productID string) (shared.PriceCycle, error) {
+	for _, c := range m.cycles {
+		if c.ProductID == productID {
+			return c, nil
+		}
+	}
+	return shared.PriceCycle{}, shared.ErrProductMissing
+}
+
+func (m *MockPriceCycleSource) GetPriceCycles(store string) ([]shared.PriceCycle, error) {
+	var cycles []shared.PriceCycle
+	for _, c := range m.cycles {
+		if store == "" || strings.EqualFold(c.Store, store) {
+			cycles = append(cycles, c)
+		}
+	}
+	return cycles, nil
+}
+
+func TestGetPriceCycles(t *testing.T) {
+	s := getTestServer()
+	now := time.Now()
+	s.ServePriceCycles(&MockPriceCycleSource{cycles: []shared.PriceCycle{
+		{ProductID: "woolworths_sku_1", Name: "Navel Oranges", Store: "Woolworths", PeriodDays: 14, NextLowStart: now.Add(5*24*time.Hour - time.Minute)},
+		{ProductID: "coles_id_1", Name: "Navel Oranges", Store: "Coles", PeriodDays: 7, NextLowStart: now.Add(-time.Hour)},
+	}})
+
+	var cycle priceCycleResponse
+	if want, got := http.StatusOK, get(t, s, "/products/woolworths_sku_1/cycle", &cycle); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 14, cycle.PeriodDays; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 5, cycle.DaysUntilLow; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	var errResponse errorResponse
+	if want, got := http.StatusNotFound, get(t, s, "/products/woolworths_sku_2/cycle", &errResponse); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	var cycles []priceCycleResponse
+	if want, got := http.StatusOK, get(t, s, "/price-cycles?store=coles", &cycles); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(cycles); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, cycles[0].DaysUntilLow; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+type MockWatchlist struct {
+	watches []watchlist.Watch
+	alerts  []watchlist.Alert
+}
+
+func (m *MockWatchlist) AddWatch(user, productID, rule string, targetPriceCents int) (watchlist.Watch, error) {
+	if rule != watchlist.WATCH_RULE_TARGET_PRICE && rule != watchlist.WATCH_RULE_ANY_DROP {
+		return watchlist.Watch{}, watchlist.ErrInvalidWatch
+	}
+	watch := watchlist.Watch{ID: int64(len(m.watches) + 1), User: user, ProductID: productID, Rule: rule, TargetPriceCents: targetPriceCents}
+	m.watches = append(m.watches, watch)
+	return watch, nil
+}
+
+func (m *MockWatchlist) RemoveWatch(user string, watchID int64) error {
+	for i, watch := range m.watches {
+		if watch.User == user && watch.ID == watchID {
+			m.watches = append(m.watches[:i], m.watches[i+1:]...)
+			return nil
+		}
+	}
+	return watchlist.ErrWatchMissing
+}
+
+func (m *MockWatchlist) GetWatches(user string) ([]watchlist.Watch, error) {
+	var watches []watchlist.Watch
+	for _, watch := range m.watches {
+		if watch.User == user {
+			watches = append(watches, watch)
+		}
+	}
+	return watches, nil
+}
+
+func (m *MockWatchlist) GetAlerts(user string, limit int) ([]watchlist.Alert, error) {
+	var alerts []watchlist.Alert
+	for _, alert := range m.alerts {
+		if alert.User == user && len(alerts) < limit {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+const TEST_WATCHLIST_TOKEN = "hunter2"
+
+// withToken adds the watchlist token to a request.
+func withToken(req *http.Request, token string) *http.Request {
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// send makes a request with a JSON body and the watchlist token, and returns the status code.
+func send(s *Server, method string, url string, body interface{}) int {
+	encoded, _ := json.Marshal(body)
+	req := withToken(httptest.NewRequest(method, url, bytes.NewReader(encoded)), TEST_WATCHLIST_TOKEN)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWatchlist(t *testing.T) {
+	s := getTestServer()
+	wl := &MockWatchlist{alerts: []watchlist.Alert{{ID: 1, WatchID: 1, User: "alice", ProductID: "woolworths_sku_1", PriceCents: 300}}}
+	s.ServeWatchlist(wl, TEST_WATCHLIST_TOKEN)
+
+	for _, test := range []struct {
+		request watchRequest
+		want    int
+	}{
+		{watchRequest{ProductID: "woolworths_sku_1", Rule: watchlist.WATCH_RULE_TARGET_PRICE, TargetPriceCents: 300}, http.StatusCreated},
+		{watchRequest{ProductID: "canonical_1", Rule: watchlist.WATCH_RULE_ANY_DROP}, http.StatusCreated},
+		{watchRequest{ProductID: "woolworths_sku_9", Rule: watchlist.WATCH_RULE_ANY_DROP}, http.StatusNotFound},
+		{watchRequest{ProductID: "woolworths_sku_1", Rule: "whenever"}, http.StatusBadRequest},
+	} {
+		if want, got := test.want, send(s, "POST", "/users/alice/watchlist", test.request); want != got {
+			t.Errorf("Expected %d, got %d for %+v", want, got, test.request)
+		}
+	}
+
+	var watches []watchResponse
+	if want, got := http.StatusOK, do(t, s, withToken(httptest.NewRequest("GET", "/users/alice/watchlist", nil), TEST_WATCHLIST_TOKEN), &watches); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(watches); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300, watches[0].TargetPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	if want, got := http.StatusNotFound, send(s, "DELETE", "/users/bob/watchlist/1", nil); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := http.StatusNoContent, send(s, "DELETE", "/users/alice/watchlist/1", nil); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := http.StatusBadRequest, send(s, "DELETE", "/users/alice/watchlist/first", nil); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	var alerts []alertResponse
+	if want, got := http.StatusOK, do(t, s, withToken(httptest.NewRequest("GET", "/users/alice/alerts", nil), TEST_WATCHLIST_TOKEN), &alerts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(alerts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300, alerts[0].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestWatchlistToken(t *testing.T) {
+	wl := &MockWatchlist{watches: []watchlist.Watch{{ID: 1, User: "alice", ProductID: "woolworths_sku_1", Rule: watchlist.WATCH_RULE_ANY_DROP}}}
+	for _, test := range []struct {
+		serverToken  string
+		requestToken string
+		want         int
+	}{
+		{TEST_WATCHLIST_TOKEN, TEST_WATCHLIST_TOKEN, http.StatusOK},
+		{TEST_WATCHLIST_TOKEN, "hunter3", http.StatusUnauthorized},
+		{TEST_WATCHLIST_TOKEN, "", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	} {
+		s := getTestServer()
+		s.ServeWatchlist(wl, test.serverToken)
+		for _, url := range []string{"/users/alice/watchlist", "/users/alice/alerts"} {
+			req := httptest.NewRequest("GET", url, nil)
+			if test.requestToken != "" {
+				withToken(req, test.requestToken)
+			}
+			var response interface{}
+			if want, got := test.want, do(t, s, req, &response); want != got {
+				t.Errorf("Expected %d, got %d for %s with %q", want, got, url, test.requestToken)
+			}
+		}
+		if test.want != http.StatusUnauthorized {
+			continue
+		}
+		// Writes are rejected before they reach the watchlist.
+		for _, req := range []*http.Request{
+			httptest.NewRequest("POST", "/users/alice/watchlist", strings.NewReader(`{"product_id": "woolworths_sku_1", "rule": "any_drop"}`)),
+			httptest.NewRequest("DELETE", "/users/alice/watchlist/1", nil),
+		} {
+			if test.requestToken != "" {
+				withToken(req, test.requestToken)
+			}
+			var response interface{}
+			if want, got := http.StatusUnauthorized, do(t, s, req, &response); want != got {
+				t.Errorf("Expected %d, got %d for %s with %q", want, got, req.Method, test.requestToken)
+			}
+		}
+		if want, got := 1, len(wl.watches); want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+
+	// Without ServeWatchlist the routes don't exist at all.
+	rec := httptest.NewRecorder()
+	getTestServer().Handler().ServeHTTP(rec, withToken(httptest.NewRequest("GET", "/users/alice/watchlist", nil), TEST_WATCHLIST_TOKEN))
+	if want, got := http.StatusNotFound, rec.Code; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	s := getTestServer()
+	var failure error
+	s.ServeHealth([]ReadinessCheck{
+		{Name: "Woolworths", Check: func() error { return nil }},
+		{Name: "outbox", Check: func() error { return failure }},
+	})
+
+	var health healthResponse
+	if want, got := http.StatusOK, get(t, s, "/healthz", &health); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := http.StatusOK, get(t, s, "/readyz", &health); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "ok", health.Checks["outbox"]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	failure = errors.New("failed to write to influxdb: connection refused")
+	health = healthResponse{}
+	if want, got := http.StatusServiceUnavailable, get(t, s, "/readyz", &health); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "unavailable", health.Status; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "ok", health.Checks["Woolworths"]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// Why it failed is logged rather than reported.
+	if want, got := "failing", health.Checks["outbox"]; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// Healthy and ready are separate.
+	if want, got := http.StatusOK, get(t, s, "/healthz", &health); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
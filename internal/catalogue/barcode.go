@@ -0,0 +1,173 @@
+package catalogue
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const GTIN_LENGTH = 14
+
+var ErrInvalidBarcode = errors.New("invalid barcode")
+
+// Where a barcode match came from.
+const BARCODE_SOURCE_STORE = "store"
+const BARCODE_SOURCE_MANUAL = "manual"
+
+// BarcodeMatch is a store product that carries, or has been manually mapped to, a barcode.
+type BarcodeMatch struct {
+	Product shared.ProductInfo
+	History []shared.PriceHistoryEntry
+	Source  string
+}
+
+// NormaliseGTIN strips everything but digits from a barcode and zero-pads it to a
+// GTIN-14, so that GTIN-8, UPC-A and EAN-13 forms of the same code compare equal.
+func NormaliseGTIN(barcode string) (string, error) {
+	var digits strings.Builder
+	for _, r := range barcode {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		} else if r != ' ' && r != '-' {
+			return "", fmt.Errorf("%w: unexpected character %q", ErrInvalidBarcode, r)
+		}
+	}
+	gtin := strings.TrimLeft(digits.String(), "0")
+	if gtin == "" || len(gtin) > GTIN_LENGTH {
+		return "", fmt.Errorf("%w: %q", ErrInvalidBarcode, barcode)
+	}
+	return strings.Repeat("0", GTIN_LENGTH-len(gtin)) + gtin, nil
+}
+
+// AddBarcodeMapping manually links a barcode to a shared product ID. This is used for stores
+// that don't publish barcodes.
+func (c *Catalogue) AddBarcodeMapping(barcode string, productID string) error {
+	gtin, err := NormaliseGTIN(barcode)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`
+		INSERT INTO barcode_mappings (barcode, productID, updated)
+		VALUES (?, ?, ?)
+		ON CONFLICT(barcode, productID) DO UPDATE SET updated = excluded.updated`,
+		gtin, productID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save barcode mapping: %w", err)
+	}
+	return nil
+}
+
+// RemoveBarcodeMapping deletes a manual barcode mapping.
+func (c *Catalogue) RemoveBarcodeMapping(barcode string, productID string) error {
+	gtin, err := NormaliseGTIN(barcode)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("DELETE FROM barcode_mappings WHERE barcode = ? AND productID = ?", gtin, productID)
+	if err != nil {
+		return fmt.Errorf("failed to delete barcode mapping: %w", err)
+	}
+	return nil
+}
+
+// ImportBarcodeMappings reads "barcode,product_id" rows from a CSV and adds each as a manual mapping.
+// A header row is permitted.
+func (c *Catalogue) ImportBarcodeMappings(r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	var count int
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read barcode mappings: %w", err)
+		}
+		if line == 1 && record[0] == "barcode" {
+			continue
+		}
+		if err := c.AddBarcodeMapping(record[0], record[1]); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// loadBarcodeMappings returns the product IDs manually mapped to the given GTIN.
+func (c *Catalogue) loadBarcodeMappings(gtin string) ([]string, error) {
+	var productIDs []string
+	rows, err := c.db.Query("SELECT productID FROM barcode_mappings WHERE barcode = ? ORDER BY productID", gtin)
+	if err != nil {
+		return productIDs, fmt.Errorf("failed to query barcode mappings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID string
+		if err := rows.Scan(&productID); err != nil {
+			return productIDs, fmt.Errorf("failed to scan barcode mapping: %w", err)
+		}
+		productIDs = append(productIDs, productID)
+	}
+	return productIDs, nil
+}
+
+// LookupBarcode returns every store product matching the barcode, with its current price and history.
+func (c *Catalogue) LookupBarcode(barcode string) ([]BarcodeMatch, error) {
+	var matches []BarcodeMatch
+	gtin, err := NormaliseGTIN(barcode)
+	if err != nil {
+		return matches, err
+	}
+
+	seen := map[string]bool{}
+	addMatch := func(store Store, product shared.ProductInfo, source string) error {
+		if seen[product.ID] {
+			return nil
+		}
+		seen[product.ID] = true
+		history, err := store.GetProductPriceHistory(product.ID)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, BarcodeMatch{Product: product, History: history, Source: source})
+		return nil
+	}
+
+	for _, store := range c.stores {
+		products, err := store.GetProductsByBarcode(gtin)
+		if err != nil {
+			return matches, err
+		}
+		for _, product := range products {
+			if err := addMatch(store, product, BARCODE_SOURCE_STORE); err != nil {
+				return matches, err
+			}
+		}
+	}
+
+	manualIDs, err := c.loadBarcodeMappings(gtin)
+	if err != nil {
+		return matches, err
+	}
+	for _, productID := range manualIDs {
+		product, store, err := c.findProduct(productID)
+		if errors.Is(err, shared.ErrProductMissing) {
+			c.logger.Warn("Barcode mapped to unknown product", "barcode", gtin, "productID", productID)
+			continue
+		} else if err != nil {
+			return matches, err
+		}
+		if err := addMatch(store, product, BARCODE_SOURCE_MANUAL); err != nil {
+			return matches, err
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,160 @@
+package catalogue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockStore struct {
+	products []shared.ProductInfo
+	history  map[string][]shared.PriceHistoryEntry
+}
+
+func (m *MockStore) GetProduct(id string) (shared.ProductInfo, error) {
+	for _, product := range m.products {
+		if product.ID == id {
+			return product, nil
+		}
+	}
+	return shared.ProductInfo{}, shared.ErrProductMissing
+}
+
+func (m *MockStore) GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error) {
+	return m.history[id], nil
+}
+
+func (m *MockStore) GetProductsByBarcode(gtin string) ([]shared.ProductInfo, error) {
+	var products []shared.ProductInfo
+	for _, product := range m.products {
+		if product.Barcode != "" && strings.TrimLeft(product.Barcode, "0") == strings.TrimLeft(gtin, "0") {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
+
+func (m *MockStore) GetMatchableProducts() ([]shared.ProductInfo, error) {
+	return m.products, nil
+}
+
+func getInitialisedCatalogue(t *testing.T) *Catalogue {
+	woolworths := &MockStore{
+		products: []shared.ProductInfo{
+			{ID: "woolworths_sku_1", Name: "Bananas", Barcode: "9300633050559", PriceCents: 400},
+			{ID: "woolworths_sku_2", Name: "Apples", Barcode: "", PriceCents: 500},
+		},
+		history: map[string][]shared.PriceHistoryEntry{
+			"woolworths_sku_1": {{PriceCents: 400}},
+		},
+	}
+	coles := &MockStore{
+		products: []shared.ProductInfo{
+			{ID: "coles_id_1", Name: "Bananas Mini Pack", PriceCents: 450},
+		},
+	}
+	c := &Catalogue{}
+	if err := c.Init(":memory:", []Store{woolworths, coles}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNormaliseGTIN(t *testing.T) {
+	for barcode, want := range map[string]string{
+		"9300633050559":   "09300633050559",
+		"09300633050559":  "09300633050559",
+		"930-063 3050559": "09300633050559",
+		"12345670":        "00000012345670",
+	} {
+		got, err := NormaliseGTIN(barcode)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", barcode, err)
+		}
+		if want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+	for _, barcode := range []string{"", "0", "banana", "123456789012345"} {
+		if _, err := NormaliseGTIN(barcode); !errors.Is(err, ErrInvalidBarcode) {
+			t.Errorf("Expected %v for %q, got %v", ErrInvalidBarcode, barcode, err)
+		}
+	}
+}
+
+func TestLookupBarcode(t *testing.T) {
+	c := getInitialisedCatalogue(t)
+
+	matches, err := c.LookupBarcode("9300633050559")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(matches); want != got {
+		t.Fatalf("Expected %d matches, got %d", want, got)
+	}
+	if want, got := 1, len(matches[0].History); want != got {
+		t.Errorf("Expected %d entries, got %d", want, got)
+	}
+
+	if err := c.AddBarcodeMapping("09300633050559", "coles_id_1"); err != nil {
+		t.Fatal(err)
+	}
+	// Mapping a product that already carries the barcode shouldn't duplicate it.
+	if err := c.AddBarcodeMapping("9300633050559", "woolworths_sku_1"); err != nil {
+		t.Fatal(err)
+	}
+	matches, err = c.LookupBarcode("9300633050559")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(matches); want != got {
+		t.Fatalf("Expected %d matches, got %d", want, got)
+	}
+	if want, got := BARCODE_SOURCE_STORE, matches[0].Source; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "coles_id_1", matches[1].Product.ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := BARCODE_SOURCE_MANUAL, matches[1].Source; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if err := c.RemoveBarcodeMapping("9300633050559", "coles_id_1"); err != nil {
+		t.Fatal(err)
+	}
+	matches, err = c.LookupBarcode("9300633050559")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(matches); want != got {
+		t.Errorf("Expected %d matches, got %d", want, got)
+	}
+}
+
+func TestImportBarcodeMappings(t *testing.T) {
+	c := getInitialisedCatalogue(t)
+
+	count, err := c.ImportBarcodeMappings(strings.NewReader("barcode,product_id\n9300633050559,coles_id_1\n12345670,coles_id_2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, count; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Mappings to products that no store knows about are skipped.
+	matches, err := c.LookupBarcode("12345670")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(matches); want != got {
+		t.Errorf("Expected %d matches, got %d", want, got)
+	}
+
+	if _, err := c.ImportBarcodeMappings(strings.NewReader("banana,coles_id_1\n")); !errors.Is(err, ErrInvalidBarcode) {
+		t.Errorf("Expected %v, got %v", ErrInvalidBarcode, err)
+	}
+}
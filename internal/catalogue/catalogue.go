@@ -0,0 +1,124 @@
+package catalogue
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const DB_SCHEMA_VERSION = 2
+
+// The schema version initBlankDB creates. DBs older than this can't be migrated, so they're backed up
+// and recreated.
+const DB_BASELINE_SCHEMA_VERSION = 2
+
+// DB_SCHEMA brings the DB up to DB_SCHEMA_VERSION. To change the schema add a migration to the end and bump
+// DB_SCHEMA_VERSION to match.
+var DB_SCHEMA = migrate.Schema{
+	Version:         DB_SCHEMA_VERSION,
+	BaselineVersion: DB_BASELINE_SCHEMA_VERSION,
+	Baseline:        initBlankDB,
+}
+
+// Store is a grocery store whose products can be linked together by the catalogue.
+type Store interface {
+	GetProduct(id string) (shared.ProductInfo, error)
+	GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error)
+	GetProductsByBarcode(gtin string) ([]shared.ProductInfo, error)
+	GetMatchableProducts() ([]shared.ProductInfo, error)
+}
+
+// Catalogue links together products from different stores, for example by barcode.
+type Catalogue struct {
+	db                *sql.DB
+	stores            []Store
+	canonicalIDs      map[string]string
+	canonicalIDsMutex sync.RWMutex
+	logger            *slog.Logger
+}
+
+// Init opens the catalogue database and remembers the stores it links together.
+func (c *Catalogue) Init(dbPath string, stores []Store) error {
+	c.logger = slog.With("component", "catalogue")
+	c.stores = stores
+	if err := c.initDB(dbPath); err != nil {
+		return err
+	}
+	canonicalIDs, err := c.loadCanonicalIDs()
+	if err != nil {
+		return err
+	}
+	c.canonicalIDs = canonicalIDs
+	return nil
+}
+
+// initBlankDB creates the schema at DB_BASELINE_SCHEMA_VERSION. Don't change it, add a migration to
+// DB_SCHEMA instead.
+func initBlankDB(tx *sql.Tx) error {
+	_, err := tx.Exec(`	CREATE TABLE IF NOT EXISTS barcode_mappings
+						(	barcode TEXT,
+							productID TEXT,
+							updated DATETIME,
+							UNIQUE(barcode, productID)
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS canonical_products
+						(	canonicalID INTEGER PRIMARY KEY AUTOINCREMENT,
+							name TEXT,
+							brand TEXT,
+							size TEXT,
+							updated DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS product_matches
+						(	productID TEXT PRIMARY KEY,
+							canonicalID INTEGER,
+							confidence REAL,
+							updated DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS product_matches_canonical ON product_matches (canonicalID)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func openDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// initDB opens the database, bringing it up to the current schema.
+func (c *Catalogue) initDB(dbPath string) error {
+	var err error
+	c.db, err = migrate.Open(dbPath, openDB, DB_SCHEMA, c.logger)
+	return err
+}
+
+// findProduct asks each store for the product until one of them has it.
+func (c *Catalogue) findProduct(id string) (shared.ProductInfo, Store, error) {
+	for _, store := range c.stores {
+		product, err := store.GetProduct(id)
+		if errors.Is(err, shared.ErrProductMissing) {
+			continue
+		}
+		return product, store, err
+	}
+	return shared.ProductInfo{}, nil, shared.ErrProductMissing
+}
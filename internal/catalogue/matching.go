@@ -0,0 +1,392 @@
+package catalogue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const CANONICAL_ID_PREFIX = "canonical_"
+
+// Candidate pairs scoring below this are not linked.
+const MATCH_CONFIDENCE_THRESHOLD = 0.8
+
+// The weighting of each part of a product when scoring a candidate pair.
+const MATCH_NAME_WEIGHT = 0.6
+const MATCH_BRAND_WEIGHT = 0.25
+const MATCH_SIZE_WEIGHT = 0.15
+
+// Sizes must be within this fraction of each other to be considered the same.
+const MATCH_SIZE_TOLERANCE = 0.05
+
+var sizeRegex = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(kg|g|ml|l|litre|litres|pk|pack|ea|each)\b`)
+var nonAlphanumericRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Words that don't help tell products apart.
+var matchStopWords = map[string]bool{
+	"a": true, "and": true, "approx": true, "each": true, "ea": true, "of": true,
+	"pack": true, "pk": true, "the": true, "with": true, "x": true,
+}
+
+// productSize is a quantity normalised to grams, millilitres or a count of items.
+type productSize struct {
+	Amount float64
+	Unit   string
+}
+
+// matchableProduct is a product broken down into the parts used for matching.
+type matchableProduct struct {
+	Info   shared.ProductInfo
+	Brand  string
+	Tokens map[string]bool
+	Size   productSize
+}
+
+// productMatch is a pair of products from different stores believed to be the same thing.
+type productMatch struct {
+	A, B       *matchableProduct
+	Confidence float64
+}
+
+// parseSize extracts a normalised size from text such as "500g", "1.25L" or "Each".
+func parseSize(text string) productSize {
+	text = strings.ToLower(text)
+	matches := sizeRegex.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		if strings.Contains(text, "each") {
+			return productSize{Amount: 1, Unit: "each"}
+		}
+		return productSize{}
+	}
+	// Use the last size in the text, since names tend to end with the pack size.
+	match := matches[len(matches)-1]
+	amount, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return productSize{}
+	}
+	switch match[2] {
+	case "kg":
+		return productSize{Amount: amount * 1000, Unit: "g"}
+	case "g":
+		return productSize{Amount: amount, Unit: "g"}
+	case "l", "litre", "litres":
+		return productSize{Amount: amount * 1000, Unit: "ml"}
+	case "ml":
+		return productSize{Amount: amount, Unit: "ml"}
+	default:
+		return productSize{Amount: amount, Unit: "each"}
+	}
+}
+
+// normaliseProduct breaks a product down into its brand, name tokens and size.
+func normaliseProduct(info shared.ProductInfo) *matchableProduct {
+	p := &matchableProduct{Info: info, Tokens: map[string]bool{}}
+	p.Brand = strings.TrimSpace(nonAlphanumericRegex.ReplaceAllString(strings.ToLower(info.Brand), " "))
+
+	p.Size = parseSize(info.Size)
+	if p.Size.Unit == "" {
+		p.Size = parseSize(info.Name)
+	}
+	if p.Size.Unit == "" && info.WeightGrams > 0 {
+		p.Size = productSize{Amount: float64(info.WeightGrams), Unit: "g"}
+	}
+
+	name := sizeRegex.ReplaceAllString(strings.ToLower(info.Name), " ")
+	name = nonAlphanumericRegex.ReplaceAllString(name, " ")
+	// Some stores include the brand in the name, others don't.
+	if p.Brand != "" {
+		name = strings.Replace(" "+name+" ", " "+p.Brand+" ", " ", 1)
+	}
+	for _, token := range strings.Fields(name) {
+		if !matchStopWords[token] {
+			p.Tokens[token] = true
+		}
+	}
+	return p
+}
+
+// blockKey groups products so that only those that could plausibly match are compared.
+func (p *matchableProduct) blockKey() string {
+	if p.Size.Unit == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%.0f", p.Size.Unit, p.Size.Amount)
+}
+
+// scoreMatch returns the confidence, from 0 to 1, that two products are the same thing.
+func scoreMatch(a, b *matchableProduct) float64 {
+	sizeScore := 0.5
+	if a.Size.Unit != "" && b.Size.Unit != "" {
+		if a.Size.Unit != b.Size.Unit || math.Abs(a.Size.Amount-b.Size.Amount) > MATCH_SIZE_TOLERANCE*math.Max(a.Size.Amount, b.Size.Amount) {
+			return 0
+		}
+		sizeScore = 1
+	}
+
+	brandScore := 0.5
+	if a.Brand != "" && b.Brand != "" {
+		brandScore = 0
+		if a.Brand == b.Brand {
+			brandScore = 1
+		}
+	}
+
+	// Dice coefficient of the name tokens.
+	if len(a.Tokens)+len(b.Tokens) == 0 {
+		return 0
+	}
+	var common int
+	for token := range a.Tokens {
+		if b.Tokens[token] {
+			common++
+		}
+	}
+	nameScore := 2 * float64(common) / float64(len(a.Tokens)+len(b.Tokens))
+
+	return MATCH_NAME_WEIGHT*nameScore + MATCH_BRAND_WEIGHT*brandScore + MATCH_SIZE_WEIGHT*sizeScore
+}
+
+// findMatches scores every pair of products from different stores that share a block and returns the
+// pairs above the confidence threshold, best first, with each product matched to at most one product
+// per other store.
+func findMatches(products []shared.ProductInfo) []productMatch {
+	blocks := map[string][]*matchableProduct{}
+	for _, info := range products {
+		p := normaliseProduct(info)
+		blocks[p.blockKey()] = append(blocks[p.blockKey()], p)
+	}
+
+	var candidates []productMatch
+	for _, block := range blocks {
+		for i, a := range block {
+			for _, b := range block[i+1:] {
+				if a.Info.Store == b.Info.Store {
+					continue
+				}
+				if confidence := scoreMatch(a, b); confidence >= MATCH_CONFIDENCE_THRESHOLD {
+					candidates = append(candidates, productMatch{A: a, B: b, Confidence: confidence})
+				}
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Confidence != candidates[j].Confidence {
+			return candidates[i].Confidence > candidates[j].Confidence
+		}
+		return candidates[i].A.Info.ID+candidates[i].B.Info.ID < candidates[j].A.Info.ID+candidates[j].B.Info.ID
+	})
+
+	// matchedStores tracks which stores each product has already been matched against.
+	matchedStores := map[string]map[string]bool{}
+	var matches []productMatch
+	for _, candidate := range candidates {
+		a, b := candidate.A.Info, candidate.B.Info
+		if matchedStores[a.ID][b.Store] || matchedStores[b.ID][a.Store] {
+			continue
+		}
+		for id, store := range map[string]string{a.ID: b.Store, b.ID: a.Store} {
+			if matchedStores[id] == nil {
+				matchedStores[id] = map[string]bool{}
+			}
+			matchedStores[id][store] = true
+		}
+		matches = append(matches, candidate)
+	}
+	return matches
+}
+
+// MatchProducts links equivalent products across stores to shared canonical products.
+func (c *Catalogue) MatchProducts() (int, error) {
+	var products []shared.ProductInfo
+	for _, store := range c.stores {
+		storeProducts, err := store.GetMatchableProducts()
+		if err != nil {
+			return 0, err
+		}
+		products = append(products, storeProducts...)
+	}
+
+	matches := findMatches(products)
+
+	canonicalIDs, err := c.loadCanonicalIDs()
+	if err != nil {
+		return 0, err
+	}
+	// Which stores are already represented in each canonical product.
+	canonicalStores := map[string]map[string]bool{}
+	for _, p := range products {
+		if id, ok := canonicalIDs[p.ID]; ok {
+			if canonicalStores[id] == nil {
+				canonicalStores[id] = map[string]bool{}
+			}
+			canonicalStores[id][p.Store] = true
+		}
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var linked int
+	now := time.Now()
+	link := func(p *matchableProduct, canonicalID string, confidence float64) error {
+		if err := saveProductMatch(tx, p.Info.ID, canonicalID, confidence, now); err != nil {
+			return err
+		}
+		canonicalIDs[p.Info.ID] = canonicalID
+		if canonicalStores[canonicalID] == nil {
+			canonicalStores[canonicalID] = map[string]bool{}
+		}
+		canonicalStores[canonicalID][p.Info.Store] = true
+		return nil
+	}
+	for _, match := range matches {
+		existing, joining := match.A, match.B
+		if canonicalIDs[existing.Info.ID] == "" {
+			existing, joining = joining, existing
+		}
+		canonicalID := canonicalIDs[existing.Info.ID]
+
+		if canonicalID != "" && canonicalIDs[joining.Info.ID] != "" {
+			// Both are already linked. Keep the existing links, refreshing the confidence if they agree.
+			if canonicalIDs[joining.Info.ID] == canonicalID {
+				if err := link(existing, canonicalID, match.Confidence); err != nil {
+					return linked, err
+				}
+				if err := link(joining, canonicalID, match.Confidence); err != nil {
+					return linked, err
+				}
+			}
+			continue
+		}
+		if canonicalID == "" {
+			canonicalID, err = createCanonicalProduct(tx, existing.Info, now)
+			if err != nil {
+				return linked, err
+			}
+			if err := link(existing, canonicalID, match.Confidence); err != nil {
+				return linked, err
+			}
+		} else if canonicalStores[canonicalID][joining.Info.Store] {
+			// The canonical product already has a product from this store.
+			continue
+		}
+		if err := link(joining, canonicalID, match.Confidence); err != nil {
+			return linked, err
+		}
+		linked++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return linked, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	c.canonicalIDsMutex.Lock()
+	c.canonicalIDs = canonicalIDs
+	c.canonicalIDsMutex.Unlock()
+	return linked, nil
+}
+
+// createCanonicalProduct creates a canonical product named after the given product.
+func createCanonicalProduct(tx *sql.Tx, info shared.ProductInfo, updated time.Time) (string, error) {
+	result, err := tx.Exec("INSERT INTO canonical_products (name, brand, size, updated) VALUES (?, ?, ?, ?)",
+		info.Name, info.Brand, info.Size, updated)
+	if err != nil {
+		return "", fmt.Errorf("failed to create canonical product: %w", err)
+	}
+	rowID, err := result.LastInsertId()
+	if err != nil {
+		return "", fmt.Errorf("failed to get canonical product ID: %w", err)
+	}
+	return CANONICAL_ID_PREFIX + strconv.FormatInt(rowID, 10), nil
+}
+
+// saveProductMatch links a product to a canonical product.
+func saveProductMatch(tx *sql.Tx, productID string, canonicalID string, confidence float64, updated time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO product_matches (productID, canonicalID, confidence, updated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(productID) DO UPDATE SET
+			canonicalID = excluded.canonicalID,
+			confidence = excluded.confidence,
+			updated = excluded.updated`,
+		productID, strings.TrimPrefix(canonicalID, CANONICAL_ID_PREFIX), confidence, updated)
+	if err != nil {
+		return fmt.Errorf("failed to save product match: %w", err)
+	}
+	return nil
+}
+
+// loadCanonicalIDs returns the canonical ID of every matched product.
+func (c *Catalogue) loadCanonicalIDs() (map[string]string, error) {
+	canonicalIDs := map[string]string{}
+	rows, err := c.db.Query("SELECT productID, canonicalID FROM product_matches")
+	if err != nil {
+		return canonicalIDs, fmt.Errorf("failed to query product matches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID string
+		var canonicalID int64
+		if err := rows.Scan(&productID, &canonicalID); err != nil {
+			return canonicalIDs, fmt.Errorf("failed to scan product match: %w", err)
+		}
+		canonicalIDs[productID] = CANONICAL_ID_PREFIX + strconv.FormatInt(canonicalID, 10)
+	}
+	return canonicalIDs, nil
+}
+
+// GetCanonicalID returns the canonical ID of the given product, or an empty string if it hasn't been matched.
+func (c *Catalogue) GetCanonicalID(productID string) string {
+	c.canonicalIDsMutex.RLock()
+	defer c.canonicalIDsMutex.RUnlock()
+	return c.canonicalIDs[productID]
+}
+
+// GetProductMatches returns the confidence of every product linked to the given canonical product.
+func (c *Catalogue) GetProductMatches(canonicalID string) (map[string]float64, error) {
+	matches := map[string]float64{}
+	rows, err := c.db.Query("SELECT productID, confidence FROM product_matches WHERE canonicalID = ?",
+		strings.TrimPrefix(canonicalID, CANONICAL_ID_PREFIX))
+	if err != nil {
+		return matches, fmt.Errorf("failed to query product matches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID string
+		var confidence float64
+		if err := rows.Scan(&productID, &confidence); err != nil {
+			return matches, fmt.Errorf("failed to scan product match: %w", err)
+		}
+		matches[productID] = confidence
+	}
+	return matches, nil
+}
+
+// RunMatcher periodically re-matches products across stores until cancelled.
+func (c *Catalogue) RunMatcher(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		linked, err := c.MatchProducts()
+		if err != nil {
+			c.logger.Error("Failed to match products", "error", err)
+		} else {
+			c.logger.Info("Matched products", "newlyLinked", linked, "duration", time.Since(start))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package catalogue
+
+import (
+	"testing"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+func TestParseSize(t *testing.T) {
+	for text, want := range map[string]productSize{
+		"500g":                  {Amount: 500, Unit: "g"},
+		"1.5kg":                 {Amount: 1500, Unit: "g"},
+		"2L":                    {Amount: 2000, Unit: "ml"},
+		"Full Cream Milk 600mL": {Amount: 600, Unit: "ml"},
+		"Each":                  {Amount: 1, Unit: "each"},
+		"6 pack":                {Amount: 6, Unit: "each"},
+		"Bunch":                 {},
+	} {
+		if got := parseSize(text); want != got {
+			t.Errorf("Expected %v for %q, got %v", want, text, got)
+		}
+	}
+}
+
+func TestScoreMatch(t *testing.T) {
+	woolworths := normaliseProduct(shared.ProductInfo{Name: "Pauls Full Cream Milk 2L", Brand: "Pauls", Size: "2L"})
+	coles := normaliseProduct(shared.ProductInfo{Name: "Full Cream Milk", Brand: "Pauls", Size: "2L"})
+	if got := scoreMatch(woolworths, coles); got < MATCH_CONFIDENCE_THRESHOLD {
+		t.Errorf("Expected a match, got %f", got)
+	}
+
+	// Different sizes never match.
+	colesSmall := normaliseProduct(shared.ProductInfo{Name: "Full Cream Milk", Brand: "Pauls", Size: "1L"})
+	if want, got := 0.0, scoreMatch(woolworths, colesSmall); want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+
+	// Different brands shouldn't match.
+	colesBrand := normaliseProduct(shared.ProductInfo{Name: "Full Cream Milk", Brand: "Coles", Size: "2L"})
+	if got := scoreMatch(woolworths, colesBrand); got >= MATCH_CONFIDENCE_THRESHOLD {
+		t.Errorf("Expected no match, got %f", got)
+	}
+}
+
+func TestMatchProducts(t *testing.T) {
+	woolworths := &MockStore{products: []shared.ProductInfo{
+		{ID: "woolworths_sku_1", Store: "Woolworths", Name: "Pauls Full Cream Milk 2L", Brand: "Pauls", Size: "2L"},
+		{ID: "woolworths_sku_2", Store: "Woolworths", Name: "Cavendish Bananas Each", Size: "Each"},
+		{ID: "woolworths_sku_3", Store: "Woolworths", Name: "Woolworths Full Cream Milk 2L", Brand: "Woolworths", Size: "2L"},
+	}}
+	coles := &MockStore{products: []shared.ProductInfo{
+		{ID: "coles_id_1", Store: "Coles", Name: "Full Cream Milk", Brand: "Pauls", Size: "2L"},
+		{ID: "coles_id_2", Store: "Coles", Name: "Bananas Cavendish", Size: "each"},
+		{ID: "coles_id_3", Store: "Coles", Name: "Full Cream Milk", Brand: "Coles", Size: "2L"},
+	}}
+	aldi := &MockStore{products: []shared.ProductInfo{
+		{ID: "aldi_sku_1", Store: "Aldi", Name: "Pauls Full Cream Milk", Brand: "PAULS", Size: "2L"},
+	}}
+	c := &Catalogue{}
+	if err := c.Init(":memory:", []Store{woolworths, coles}); err != nil {
+		t.Fatal(err)
+	}
+
+	linked, err := c.MatchProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, linked; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	milkID := c.GetCanonicalID("woolworths_sku_1")
+	if milkID == "" {
+		t.Fatal("Expected milk to be matched")
+	}
+	if want, got := milkID, c.GetCanonicalID("coles_id_1"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := c.GetCanonicalID("woolworths_sku_2"), c.GetCanonicalID("coles_id_2"); want != got || want == "" {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "", c.GetCanonicalID("coles_id_3"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// Adding a store links its products into the existing canonical products.
+	c.stores = append(c.stores, aldi)
+	linked, err = c.MatchProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, linked; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := milkID, c.GetCanonicalID("aldi_sku_1"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	matches, err := c.GetProductMatches(milkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, len(matches); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	for productID, confidence := range matches {
+		if confidence < MATCH_CONFIDENCE_THRESHOLD || confidence > 1 {
+			t.Errorf("Unexpected confidence %f for %s", confidence, productID)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package coles
 
 import (
-	"database/sql"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -9,27 +10,27 @@ import (
 	"time"
 
 	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 	"golang.org/x/time/rate"
 )
 
+const PRODUCTS_PER_PAGE = 48
 const DEFAULT_LISTING_PAGE_CHECK_INTERVAL = 1 * time.Minute
 
 // Coles satisfies the ProductInfoGetter interface.
 type Coles struct {
-	baseURL                   string
-	client                    *shared.RLHTTPClient
-	cookieJar                 *cookiejar.Jar // TODO This might not be threadsafe.
-	db                        *sql.DB
-	colesAPIVersion           string
-	productMaxAge             time.Duration
-	listingPageUpdateInterval time.Duration
-	filteredDepartmentIDsSet  map[string]bool
-	filterDepartments         bool
-	logger                    *slog.Logger
+	baseURL                  string
+	client                   *shared.RLHTTPClient
+	cookieJar                *cookiejar.Jar // TODO This might not be threadsafe.
+	colesAPIVersion          string
+	filteredDepartmentIDsSet map[string]bool
+	filterDepartments        bool
+	logger                   *slog.Logger
+	*store.Scraper
 }
 
 // Init initialises the Coles struct.
-func (c *Coles) Init(baseURL string, dbPath string, productMaxAge time.Duration) error {
+func (c *Coles) Init(baseURL string, db *store.DB, productMaxAge time.Duration) error {
 	var err error
 
 	c.logger = slog.With("store", "Coles")
@@ -50,13 +51,22 @@ func (c *Coles) Init(baseURL string, dbPath string, productMaxAge time.Duration)
 			Timeout: 30 * time.Second,
 		},
 		Ratelimiter: rate.NewLimiter(rate.Every(1000*time.Millisecond), 1),
+		Store:       "Coles",
 	}
-	c.productMaxAge = productMaxAge
-	err = c.initDB(dbPath)
-	if err != nil {
-		return err
+	c.Scraper = &store.Scraper{
+		Repository: db.Repository(store.StoreConfig{
+			Name:            "Coles",
+			IDPrefix:        COLES_ID_PREFIX,
+			DescribeProduct: describeProduct,
+		}),
+		Source:          c,
+		PageSize:        PRODUCTS_PER_PAGE,
+		Workers:         1,
+		MaxAge:          productMaxAge,
+		RefreshInterval: DEFAULT_LISTING_PAGE_CHECK_INTERVAL,
+		Include:         c.isDepartmentIncluded,
+		Logger:          c.logger,
 	}
-	c.listingPageUpdateInterval = DEFAULT_LISTING_PAGE_CHECK_INTERVAL
 	c.filteredDepartmentIDsSet = map[string]bool{
 		"fruit-vegetables":  true,
 		"dairy-eggs-fridge": true,
@@ -75,79 +85,23 @@ func (c *Coles) Init(baseURL string, dbPath string, productMaxAge time.Duration)
 	}
 	c.filterDepartments = true
 
-	if err := c.updateAPIVersion(); err != nil {
+	if err := c.updateAPIVersion(context.Background()); err != nil {
 		slog.Error("error updating API version", "error", err)
 	}
 
 	return nil
 }
 
-// Runs up all the workers and mediates data flowing between them.
-// Currently all sqlite writes happen via this function. This may move
-// off to a separate goroutine in the future.
-func (c *Coles) Run(cancel chan struct{}) {
-	departmentPageChannel := make(chan departmentPage)
-
-	go c.productListPageWorker(departmentPageChannel)
-	go c.newDepartmentInfoWorker()
-	go c.departmentPageUpdateQueueWorker(departmentPageChannel, c.productMaxAge)
-
-	for range cancel {
-		return
-	}
-}
-
-// GetSharedProductsUpdatedAfter provides a list of product IDs that have been updated since the given time
-func (c *Coles) GetSharedProductsUpdatedAfter(t time.Time, count int) ([]shared.ProductInfo, error) {
-	var productIDs []shared.ProductInfo
-	var deptDescription sql.NullString
-	rows, err := c.db.Query(`
-		SELECT
-			productID,
-			products.name,
-			products.description,
-			departments.description,
-			priceCents,
-			previousPriceCents,
-			weightGrams,
-			products.updated
-		FROM
-			products
-			LEFT JOIN departments ON products.departmentID = departments.departmentID
-		WHERE products.updated > ? AND name != '' LIMIT ?`, t, count)
-	if err != nil {
-		return productIDs, fmt.Errorf("failed to query productIDs: %w", err)
-	}
-	for rows.Next() {
-		var product shared.ProductInfo
-		err = rows.Scan(
-			&product.ID,
-			&product.Name,
-			&product.Description,
-			&deptDescription,
-			&product.PriceCents,
-			&product.PreviousPriceCents,
-			&product.WeightGrams,
-			&product.Timestamp)
-		if err != nil {
-			return productIDs, fmt.Errorf("failed to scan productID: %w", err)
-		}
-		if deptDescription.Valid {
-			product.Department = deptDescription.String
-		}
-		product.ID = COLES_ID_PREFIX + product.ID
-		product.Store = "Coles"
-		productIDs = append(productIDs, product)
+// describeProduct reads the brand and size out of a product's raw JSON.
+func describeProduct(rawJSON []byte) (string, string, error) {
+	var info productListPageProduct
+	if err := json.Unmarshal(rawJSON, &info); err != nil {
+		return "", "", err
 	}
-	return productIDs, nil
+	return info.Brand, info.Size, nil
 }
 
-// GetTotalProductCount returns the total number of products in the database.
-func (c *Coles) GetTotalProductCount() (int, error) {
-	var count int
-	err := c.db.QueryRow("SELECT COUNT(*) FROM products").Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to query product count: %w", err)
-	}
-	return count, nil
+// isDepartmentIncluded returns whether a department is in the filteredDepartmentIDsSet, if it's being filtered.
+func (c *Coles) isDepartmentIncluded(departmentID string) bool {
+	return !c.filterDepartments || c.filteredDepartmentIDsSet[departmentID]
 }
@@ -1,142 +1,20 @@
 package coles
 
 import (
-	"database/sql"
 	"fmt"
-	"os"
-	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/shopspring/decimal"
 	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 )
 
-const DB_SCHEMA_VERSION = 1
-
-// Initialises the DB with the schema. Note you must bump the DB_SCHEMA_VERSION
-// constant if you change the schema.
-func (c *Coles) initBlankDB() error {
-
-	// Drop all tables
-	for _, table := range []string{"schema", "departments", "products"} {
-		// Mildly confused by why this doesn't work? TODO investigate
-		// _, err := w.db.Exec("DROP TABLE IF EXISTS ?", table)
-		_, err := c.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
-		if err != nil {
-			return err
-		}
-	}
-
-	_, err := c.db.Exec("CREATE TABLE IF NOT EXISTS schema (version INTEGER PRIMARY KEY)")
-	if err != nil {
-		return err
-	}
-	_, err = c.db.Exec("INSERT INTO schema (version) VALUES (?)", DB_SCHEMA_VERSION)
-	if err != nil {
-		return err
-	}
-	_, err = c.db.Exec("CREATE TABLE IF NOT EXISTS departments (departmentID TEXT UNIQUE, description TEXT, productCount INTEGER, updated DATETIME)")
-	if err != nil {
-		return err
-	}
-	_, err =
-		c.db.Exec(`	CREATE TABLE IF NOT EXISTS products
-						(	productID TEXT UNIQUE,
-							name TEXT,
-							description TEXT,
-							barcode TEXT,
-							priceCents INTEGER,
-							previousPriceCents INTEGER,
-							weightGrams INTEGER,
-							productJSON TEXT,
-							departmentID TEXT DEFAULT "",
-							updated DATETIME
-						)`)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// backupDB moves the specified DB to the same directory with an ISO8601 timestamp and the schema
-// number prepended to the filename.
-func (c *Coles) backupDB(dbPath string, oldSchema int) error {
-	backupName := fmt.Sprintf("%s.%d.%s", dbPath, oldSchema, time.Now().Format("2006-01-02T15:04:05"))
-	err := os.Rename(dbPath, backupName)
-	if err != nil {
-		return fmt.Errorf("failed to backup existing DB: %w", err)
-	}
-	c.logger.Info("Backed up old DB", "old", dbPath, "new", backupName)
-	return nil
-}
-
-func openDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
-	if err != nil {
-		return nil, err
-	}
-	db.SetMaxOpenConns(1)
-	return db, nil
-}
-
-// initDB initialises the database.
-func (c *Coles) initDB(dbPath string) error {
-	var err error
-	c.db, err = openDB(dbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open DB: %w", err)
-	}
-	var version int
-	err = c.db.QueryRow("SELECT version FROM schema").Scan(&version)
-
-	if err != nil || version != DB_SCHEMA_VERSION {
-		c.logger.Warn("DB schema mismatch", "path", dbPath, "currentVersion", DB_SCHEMA_VERSION, "detectedVersion", version)
-
-		if version != 0 {
-			// If we detected an old schema, backup the DB and create a new one.
-			err = c.db.Close()
-			if err != nil {
-				return fmt.Errorf("failed to close existing DB before backing it up: %w", err)
-			}
-			err = c.backupDB(dbPath, version)
-			if err != nil {
-				return fmt.Errorf("failed to backup existing DB: %w", err)
-			}
-
-			// Open a new DB
-			c.db, err = openDB(dbPath)
-			if err != nil {
-				return fmt.Errorf("failed to open DB: %w", err)
-			}
-		}
-
-		// Create the schema
-		err := c.initBlankDB()
-		if err != nil {
-			return fmt.Errorf("failed to create blank DB: %w", err)
-		} else {
-			c.logger.Info("New blank DB created")
-		}
-	}
-	return nil
-}
-
-// saveProductInfo saves the product info to the database transactionfully.
+// saveProductInfoes saves the product info to the database transactionfully.
 func (c *Coles) saveProductInfoes(products []colesProductInfo) error {
-	tx, err := c.db.Begin()
-	defer tx.Rollback()
-	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+	storeProducts := make([]store.Product, len(products))
+	for i, product := range products {
+		storeProducts[i] = c.productFromInfo(product)
 	}
-	for _, product := range products {
-		if err := c.saveProductInfo(tx, product); err != nil {
-			return fmt.Errorf("failed to save product info: %w", err)
-		}
-	}
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-	return nil
+	return c.SaveProducts(storeProducts)
 }
 
 func calcWeightInGrams(productInfo colesProductInfo) (int, error) {
@@ -156,130 +34,119 @@ func calcWeightInGrams(productInfo colesProductInfo) (int, error) {
 	return int(float64(productInfo.Info.Pricing.Unit.Quantity) * scalar), nil
 }
 
-// saveProductInfo saves a single product to the database transactionfully.
-func (c *Coles) saveProductInfo(tx *sql.Tx, productInfo colesProductInfo) error {
-	var err error
-	var result sql.Result
+// calcUnitPrice normalises the comparable price to cents per kilogram, litre or item, falling back to the weight.
+func calcUnitPrice(productInfo colesProductInfo) (float64, string, error) {
+	unitPriceCents, unitType, err := shared.ParseUnitPrice(productInfo.Info.Pricing.Comparable)
+	if err == nil {
+		return unitPriceCents, unitType, nil
+	}
+	return shared.UnitPriceFromWeight(int(productInfo.Info.Pricing.Now.Mul(decimal.NewFromInt(100)).IntPart()), productInfo.WeightGrams)
+}
 
+// productFromInfo maps a product from the web to how it's kept in the DB.
+func (c *Coles) productFromInfo(productInfo colesProductInfo) store.Product {
+	var err error
 	productInfo.WeightGrams, err = calcWeightInGrams(productInfo)
 	if err != nil {
 		c.logger.Debug("Couldn't calculate weight in grams", "productID", productInfo.ID, "error", err)
 		productInfo.WeightGrams = 0
 	}
 
-	result, err = tx.Exec(`
-			INSERT INTO products (productID, name, description, barcode, priceCents, previousPriceCents, weightGrams, productJSON, departmentID, updated)
-			VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?)
-			ON CONFLICT(productID) DO UPDATE SET
-				productID = excluded.productID,
-				name = excluded.name,
-				description = excluded.description,
-				barcode = excluded.barcode,
-				priceCents = excluded.priceCents,
-				previousPriceCents = priceCents,
-				weightGrams = excluded.weightGrams,
-				productJSON = excluded.productJSON,
-				departmentID = excluded.departmentID,
-				updated = excluded.updated`,
-		productInfo.ID, productInfo.Info.Name, productInfo.Info.Description, 0,
-		productInfo.Info.Pricing.Now.Mul(decimal.NewFromInt(100)).IntPart(),
-		productInfo.WeightGrams, productInfo.RawJSON, productInfo.departmentID, productInfo.Updated)
-
+	unitPriceCents, unitType, err := calcUnitPrice(productInfo)
 	if err != nil {
-		return fmt.Errorf("failed to update product info: %w", err)
-	}
-	if rowsAffected, err := result.RowsAffected(); err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	} else if rowsAffected == 0 {
-		c.logger.Warn("Product info not updated.")
+		c.logger.Debug("Couldn't calculate unit price", "productID", productInfo.ID, "error", err)
+	}
+
+	return store.Product{
+		ID:             string(productInfo.ID),
+		Name:           productInfo.Info.Name,
+		Description:    productInfo.Info.Description,
+		PriceCents:     int(productInfo.Info.Pricing.Now.Mul(decimal.NewFromInt(100)).IntPart()),
+		WeightGrams:    productInfo.WeightGrams,
+		UnitPriceCents: unitPriceCents,
+		UnitType:       unitType,
+		Promotion:      promotionFromProductInfo(productInfo),
+		OnSpecial:      productInfo.Info.Pricing.PromotionType != "" || productInfo.Info.Pricing.OnlineSpecial,
+		RawJSON:        productInfo.RawJSON,
+		DepartmentID:   productInfo.departmentID,
+		Updated:        productInfo.Updated,
 	}
+}
+
+// saveProductInfo saves a single product to the database transactionfully. It returns whether the product
+// is new or its price or promotion changed.
+func (c *Coles) saveProductInfo(tx *store.Tx, productInfo colesProductInfo) (bool, error) {
+	return tx.SaveProduct(c.productFromInfo(productInfo))
+}
 
-	return nil
+// promotionFromProductInfo extracts the promotion the product is currently on, if any.
+func promotionFromProductInfo(productInfo colesProductInfo) shared.Promotion {
+	pricing := productInfo.Info.Pricing
+	promotion := shared.Promotion{
+		Type:          pricing.PromotionType,
+		WasPriceCents: int(pricing.Was.Mul(decimal.NewFromInt(100)).IntPart()),
+	}
+	if pricing.MultiBuyPromotion != nil && pricing.MultiBuyPromotion.MinQuantity > 0 {
+		promotion.MultibuyQuantity = pricing.MultiBuyPromotion.MinQuantity
+		promotion.MultibuyPriceCents = int(pricing.MultiBuyPromotion.Reward.Mul(decimal.NewFromInt(int64(100 * pricing.MultiBuyPromotion.MinQuantity))).IntPart())
+		if promotion.Type == "" {
+			promotion.Type = shared.PROMOTION_TYPE_MULTIBUY
+		}
+	}
+	if promotion.Type == "" && pricing.OnlineSpecial {
+		promotion.Type = shared.PROMOTION_TYPE_SPECIAL
+	}
+	return promotion
 }
 
 // loadProductInfo loads cached extended product info from the database
 func (c *Coles) loadProductInfo(productID productID) (colesProductInfo, error) {
 	var cProdInfo colesProductInfo
-	var deptDescription sql.NullString
-	row := c.db.QueryRow(`
-	SELECT
-		productID,
-		name,
-		products.description,
-		priceCents,
-		previousPriceCents,
-		weightGrams,
-		productJSON,
-		products.departmentID,
-		departments.description,
-		products.updated
-	FROM
-		products
-		LEFT JOIN departments ON products.departmentID = departments.departmentID
-	WHERE productID = ? LIMIT 1`, productID)
-	err := row.Scan(
-		&cProdInfo.ID,
-		&cProdInfo.Info.Name,
-		&cProdInfo.Info.Description,
-		&cProdInfo.Info.Pricing.Now,
-		&cProdInfo.PreviousPrice,
-		&cProdInfo.WeightGrams,
-		&cProdInfo.RawJSON,
-		&cProdInfo.departmentID,
-		&deptDescription, // This value comes from a join, so it might be NULL.
-		&cProdInfo.Updated)
+	product, err := c.LoadProduct(string(productID))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return cProdInfo, shared.ErrProductMissing
-		}
-		return cProdInfo, fmt.Errorf("failed to query existing product info: %w", err)
-	}
-	if deptDescription.Valid {
-		cProdInfo.departmentDescription = deptDescription.String
-	}
+		return cProdInfo, err
+	}
+	cProdInfo.ID = productID
+	cProdInfo.Info.Name = product.Name
+	cProdInfo.Info.Description = product.Description
+	cProdInfo.Info.Pricing.Now = decimal.NewFromInt(int64(product.PriceCents))
+	cProdInfo.PreviousPrice = decimal.NewFromInt(int64(product.PreviousPriceCents))
+	cProdInfo.WeightGrams = product.WeightGrams
+	cProdInfo.RawJSON = product.RawJSON
+	cProdInfo.departmentID = product.DepartmentID
+	cProdInfo.departmentDescription = product.DepartmentDescription
+	cProdInfo.Updated = product.Updated
 	return cProdInfo, nil
 }
 
-// Saves product info to the database
-func (c *Coles) saveDepartment(departmentInfo departmentInfo) error {
-	var err error
-	var result sql.Result
-
-	result, err = c.db.Exec(`
-		INSERT INTO departments (departmentID, description, productCount, updated)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(departmentID) DO UPDATE SET
-			departmentID = excluded.departmentID,
-			description = excluded.description,
-			productCount = excluded.productCount,
-			updated = excluded.updated`,
-		departmentInfo.SeoToken, departmentInfo.Name, departmentInfo.ProductCount, departmentInfo.Updated)
-
-	if err != nil {
-		return fmt.Errorf("failed to update department ID info: %w", err)
-	}
-	if rowsAffected, err := result.RowsAffected(); err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	} else if rowsAffected == 0 {
-		c.logger.Warn("Department not upserted")
+// departmentFromInfo maps a department from the web to how it's kept in the DB.
+func departmentFromInfo(departmentInfo departmentInfo) store.Department {
+	return store.Department{
+		ID:           departmentInfo.SeoToken,
+		Description:  departmentInfo.Name,
+		ProductCount: departmentInfo.ProductCount,
+		Updated:      departmentInfo.Updated,
 	}
+}
 
-	return nil
+// Saves department info to the database
+func (c *Coles) saveDepartment(departmentInfo departmentInfo) error {
+	return c.SaveDepartment(departmentFromInfo(departmentInfo))
 }
 
 func (c *Coles) loadDepartmentInfoList() ([]departmentInfo, error) {
 	var departmentInfos []departmentInfo
-	rows, err := c.db.Query("SELECT departmentID, description, productCount, updated FROM departments")
+	departments, err := c.LoadDepartments()
 	if err != nil {
-		return departmentInfos, fmt.Errorf("failed to query departmentIDs: %w", err)
-	}
-	for rows.Next() {
-		var deptInfo departmentInfo
-		err = rows.Scan(&deptInfo.SeoToken, &deptInfo.Name, &deptInfo.ProductCount, &deptInfo.Updated)
-		if err != nil {
-			return departmentInfos, fmt.Errorf("failed to scan departmentID: %w", err)
-		}
-		departmentInfos = append(departmentInfos, deptInfo)
+		return departmentInfos, err
+	}
+	for _, dept := range departments {
+		departmentInfos = append(departmentInfos, departmentInfo{
+			SeoToken:     dept.ID,
+			Name:         dept.Description,
+			ProductCount: dept.ProductCount,
+			Updated:      dept.Updated,
+		})
 	}
 	return departmentInfos, nil
 }
@@ -1,16 +1,18 @@
 package coles
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
 )
 
 func TestCalcWeightInGrams(t *testing.T) {
 	c := getInitialisedColes()
-	dp := departmentPage{"fruit-vegetables", 1}
-	products, _, err := c.getProductsAndTotalCountForCategoryPage(dp)
+	dp := departmentPage{ID: "fruit-vegetables", page: 1}
+	products, _, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
 	if err != nil {
 		t.Fatalf("Failed to get products: %v", err)
 	}
@@ -36,15 +38,86 @@ func TestCalcWeightInGrams(t *testing.T) {
 	}
 }
 
+func TestCalcUnitPrice(t *testing.T) {
+	c := getInitialisedColes()
+	dp := departmentPage{ID: "fruit-vegetables", page: 1}
+	products, _, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
+	if err != nil {
+		t.Fatalf("Failed to get products: %v", err)
+	}
+
+	var cases = []struct {
+		index    int
+		want     float64
+		unitType string
+	}{
+		{0, 600, shared.UNIT_TYPE_MASS},
+		{7, 450, shared.UNIT_TYPE_MASS},
+		{8, 250, shared.UNIT_TYPE_COUNT},
+	}
+
+	for _, tc := range cases {
+		unitPriceCents, unitType, err := calcUnitPrice(products[tc.index])
+		if err != nil {
+			t.Fatalf("Unexpectedly failed to calculate unit price for item %s: %v", products[tc.index].Info.Name, err)
+		}
+		if want, got := tc.want, unitPriceCents; want != got {
+			t.Errorf("Expected %f, got %f for test item %s", want, got, products[tc.index].Info.Name)
+		}
+		if want, got := tc.unitType, unitType; want != got {
+			t.Errorf("Expected %s, got %s for test item %s", want, got, products[tc.index].Info.Name)
+		}
+	}
+
+	// Without a comparable price, fall back to the weight.
+	product := colesProductInfo{WeightGrams: 500}
+	product.Info.Pricing.Now = decimal.NewFromFloat(2.5)
+	if unitPriceCents, _, err := calcUnitPrice(product); err != nil || unitPriceCents != 500 {
+		t.Errorf("Expected %f, got %f (%v)", 500.0, unitPriceCents, err)
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	c := getInitialisedColes()
+	dp := departmentPage{ID: "fruit-vegetables", page: 1}
+	products, _, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
+	if err != nil {
+		t.Fatalf("Failed to get products: %v", err)
+	}
+
+	// Strawberries are on special.
+	promotion := promotionFromProductInfo(products[2])
+	if want, got := shared.PROMOTION_TYPE_SPECIAL, promotion.Type; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 0, promotion.MultibuyQuantity; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Baby spinach is "Pick any 2 for $5".
+	promotion = promotionFromProductInfo(products[45])
+	if want, got := 2, promotion.MultibuyQuantity; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 500, promotion.MultibuyPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Products without any promotion.
+	if promotionFromProductInfo(products[0]).Active() {
+		t.Errorf("Expected no promotion for %s", products[0].Info.Name)
+	}
+}
+
 func TestSaveProductInfo(t *testing.T) {
 	c := getInitialisedColes()
-	dp := departmentPage{"fruit-vegetables", 1}
-	products, _, err := c.getProductsAndTotalCountForCategoryPage(dp)
+	dp := departmentPage{ID: "fruit-vegetables", page: 1}
+	products, _, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
 	if err != nil {
 		t.Fatalf("Failed to get products: %v", err)
 	}
 
-	tx, err := c.db.Begin()
+	tx, err := c.Begin()
 	if err != nil {
 		t.Fatalf("Failed to start transaction: %v", err)
 	}
@@ -108,9 +181,9 @@ func TestDepartmentInfo(t *testing.T) {
 	}
 }
 
-func TestGetSharedProductsUpdatedAfter(t *testing.T) {
+func TestGetSharedProductsChangedAfter(t *testing.T) {
 	c := Coles{}
-	c.Init(colesServer.URL, ":memory:", 5*time.Second)
+	c.Init(colesServer.URL, openTestDB(), 5*time.Second)
 	c.filterDepartments = false
 	var infoList []colesProductInfo
 	infoList = append(infoList, colesProductInfo{ID: "123455", Info: productListPageProduct{Name: "1", Pricing: productListPageProductPricing{Now: decimal.NewFromFloat(1.5)}}, Updated: time.Now().Add(-5 * time.Minute)})
@@ -125,10 +198,15 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 
 	c.saveProductInfoes(infoList)
 
-	productIDs, err := c.GetSharedProductsUpdatedAfter(time.Now().Add(-2*time.Minute), 10)
+	// The products before the cursor, the first save of the duplicate and the product with a blank name
+	// are all skipped.
+	productIDs, changeSeq, err := c.GetSharedProductsChangedAfter(3, 10)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if want, got := int64(7), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
 	if want, got := 2, len(productIDs); want != got {
 		t.Fatalf("Expected %d products, got %d", want, got)
 	}
@@ -144,10 +222,13 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 	if want, got := 510, productIDs[1].PriceCents; want != got {
 		t.Errorf("Expected %v, got %v", want, got)
 	}
-	productIDs, err = c.GetSharedProductsUpdatedAfter(time.Now().Add(-2*time.Minute), 1)
+	productIDs, changeSeq, err = c.GetSharedProductsChangedAfter(3, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if want, got := int64(4), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
 	if want, got := 1, len(productIDs); want != got {
 		t.Fatalf("Expected %d products, got %d", want, got)
 	}
@@ -166,3 +247,82 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 		}
 	}
 }
+
+func TestPriceHistory(t *testing.T) {
+	c := getInitialisedColes()
+	var infoList []colesProductInfo
+	for i, price := range []float64{1.5, 1.5, 2.0, 2.0, 1.5} {
+		infoList = append(infoList, colesProductInfo{ID: "123455", Info: productListPageProduct{Name: "1", Pricing: productListPageProductPricing{Now: decimal.NewFromFloat(price), Was: decimal.NewFromFloat(2.0)}}, Updated: time.Now().Add(time.Duration(i-5) * time.Minute)})
+	}
+	// Flag a special with an unchanged price, which should still be recorded.
+	infoList = append(infoList, colesProductInfo{ID: "123455", Info: productListPageProduct{Name: "1", Pricing: productListPageProductPricing{Now: decimal.NewFromFloat(1.5), Was: decimal.NewFromFloat(2.0), PromotionType: "SPECIAL"}}, Updated: time.Now()})
+	if err := c.saveProductInfoes(infoList); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := c.GetProductPriceHistory(COLES_ID_PREFIX + "123455")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, len(history); want != got {
+		t.Fatalf("Expected %d history entries, got %d", want, got)
+	}
+	for i, want := range []int{150, 200, 150, 150} {
+		if got := history[i].PriceCents; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+	if want, got := 200, history[0].WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := true, history[3].OnSpecial; want != got {
+		t.Errorf("Expected %t, got %t", want, got)
+	}
+	if want, got := "SPECIAL", history[3].PromotionType; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestSearchAndGetProduct(t *testing.T) {
+	c := getInitialisedColes()
+	c.saveDepartment(departmentInfo{SeoToken: "fruit-vegetables", Name: "Fruit & Vegetables", ProductCount: 2, Updated: time.Now()})
+	var infoList []colesProductInfo
+	infoList = append(infoList, colesProductInfo{ID: "123455", departmentID: "fruit-vegetables", Info: productListPageProduct{Name: "Bananas Mini Pack", Pricing: productListPageProductPricing{Now: decimal.NewFromFloat(1.5)}}, Updated: time.Now()})
+	infoList = append(infoList, colesProductInfo{ID: "123456", departmentID: "fruit-vegetables", Info: productListPageProduct{Name: "Pink Lady Apples", Pricing: productListPageProductPricing{Now: decimal.NewFromFloat(2.5)}}, Updated: time.Now()})
+	if err := c.saveProductInfoes(infoList); err != nil {
+		t.Fatal(err)
+	}
+
+	products, err := c.SearchProducts("Apples", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := COLES_ID_PREFIX+"123456", products[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	product, err := c.GetProduct(COLES_ID_PREFIX + "123455")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 150, product.PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if _, err := c.GetProduct("123455"); err != shared.ErrProductMissing {
+		t.Errorf("Expected %v, got %v", shared.ErrProductMissing, err)
+	}
+
+	departments, err := c.GetDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := "Fruit & Vegetables", departments[0].Description; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,114 @@
+package coles
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestFetchDepartments(t *testing.T) {
+	c := getInitialisedColes()
+	departments, err := c.FetchDepartments(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 16, len(departments); want != got {
+		t.Errorf("Expected %d departments, got %d", want, got)
+	}
+}
+
+func TestFetchProducts(t *testing.T) {
+	c := getInitialisedColes()
+	products, err := c.FetchProducts(context.Background(), "fruit-vegetables", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) == 0 {
+		t.Fatal("Expected products")
+	}
+	if want, got := "2511791", products[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Bananas Mini Pack", products[0].Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func ValidateProduct(t *testing.T, w *Coles, id productID, expectedName string) error {
+	prod, err := w.loadProductInfo(id)
+	if err != nil {
+		return fmt.Errorf("Failed to get product ID %s: %v", id, err)
+	}
+	if prod.Info.Name != expectedName {
+		t.Logf("Expected '%s', got '%s'", expectedName, prod.Info.Name)
+		return fmt.Errorf("Expected '%s', got '%s'", expectedName, prod.Info.Name)
+	}
+	if want, got := "Fruit & Vegetables", prod.departmentDescription; want != got {
+		t.Fatalf("Expected '%s', got '%s'", want, got)
+		return fmt.Errorf("Expected '%s', got '%s'", want, got)
+	}
+	if want, got := "fruit-vegetables", prod.departmentID; want != got {
+		t.Fatalf("Expected '%s', got '%s'", want, got)
+		return fmt.Errorf("Expected '%s', got '%s'", want, got)
+	}
+	return nil
+}
+func TestScheduler(t *testing.T) {
+	c := Coles{}
+	c.Init(colesServer.URL, openTestDB(), 100*time.Second)
+	c.RefreshInterval = 1 * time.Second
+	c.client.Ratelimiter = rate.NewLimiter(rate.Every(1*time.Millisecond), 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(stopped)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			err1 := ValidateProduct(t, &c, "2511791", "Bananas Mini Pack")
+			err2 := ValidateProduct(t, &c, "5111654", "Pink Lady Apples Medium")
+			if err1 == nil && err2 == nil {
+				close(done)
+				return
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}()
+
+	select {
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for scheduler to finish")
+	case <-done:
+
+	}
+
+	cancel()
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for scheduler to stop")
+	case <-stopped:
+	}
+}
+
+func TestScrapeDepartment(t *testing.T) {
+	c := getInitialisedColes()
+	c.saveDepartment(departmentInfo{SeoToken: "fruit-vegetables", Name: "Fruit & Vegetables", ProductCount: PRODUCTS_PER_PAGE * 2, Updated: time.Now().Add(-1 * time.Hour)})
+
+	summary, err := c.ScrapeDepartment(context.Background(), "FRUIT-VEGETABLES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Products == 0 {
+		t.Errorf("Expected products to be saved")
+	}
+	count, _ := c.GetTotalProductCount()
+	if want, got := summary.Products, count; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
@@ -2,6 +2,7 @@ package coles
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 	"github.com/tjhowse/aus_grocery_price_database/internal/utils"
 )
 
@@ -24,9 +27,9 @@ const SCRAPE_TRAP_STRING = "Pardon Our Interruption"
 var ErrHitScrapeTrap = errors.New("caught in a scrape trap")
 
 // updateAPIVersion grabs the coles home page and extracts the API version from it.
-func (c *Coles) updateAPIVersion() error {
+func (c *Coles) updateAPIVersion(ctx context.Context) error {
 	// Get the browse homepage
-	body, err := c.getBrowseHomepage()
+	body, err := c.getBrowseHomepage(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get homepage: %w", err)
 	}
@@ -46,14 +49,14 @@ func (c *Coles) updateAPIVersion() error {
 }
 
 // getBrowseHomepage returns the bytes of the Coles browse homepage.
-func (c *Coles) getBrowseHomepage() ([]byte, error) {
+func (c *Coles) getBrowseHomepage(ctx context.Context) ([]byte, error) {
 	var req *http.Request
 	var resp *http.Response
 	var err error
 	url := fmt.Sprintf(BROWSE_HOMEPAGE_URL_FORMAT, c.baseURL)
 	var body []byte
 
-	if req, err = http.NewRequest("GET", url, nil); err != nil {
+	if req, err = http.NewRequestWithContext(ctx, "GET", url, nil); err != nil {
 		return body, err
 	}
 
@@ -73,6 +76,7 @@ func (c *Coles) getBrowseHomepage() ([]byte, error) {
 		return body, err
 	}
 	if checkForScrapeTrap(body) {
+		metrics.ScrapeTrapHits.Inc("Coles")
 		return body, ErrHitScrapeTrap
 	}
 	return body, nil
@@ -92,14 +96,14 @@ func extractAPIVersion(body []byte) (string, error) {
 }
 
 // getBrowseJSON returns the bytes of the Coles browse JSON.
-func (c *Coles) getBrowseJSON() ([]byte, error) {
+func (c *Coles) getBrowseJSON(ctx context.Context) ([]byte, error) {
 	var req *http.Request
 	var resp *http.Response
 	var err error
 	url := fmt.Sprintf(BROWSE_JSON_URL_FORMAT, c.baseURL, c.colesAPIVersion)
 	var body []byte
 
-	if req, err = http.NewRequest("GET", url, nil); err != nil {
+	if req, err = http.NewRequestWithContext(ctx, "GET", url, nil); err != nil {
 		return body, err
 	}
 
@@ -122,14 +126,14 @@ func (c *Coles) getBrowseJSON() ([]byte, error) {
 }
 
 // getCategoryJSON returns the bytes of the Coles category JSON.
-func (c *Coles) getCategoryJSON(category string, page int) ([]byte, error) {
+func (c *Coles) getCategoryJSON(ctx context.Context, category string, page int) ([]byte, error) {
 	var req *http.Request
 	var resp *http.Response
 	var err error
 	url := fmt.Sprintf(CATEGORY_URL_FORMAT, c.baseURL, c.colesAPIVersion, category)
 	var body []byte
 
-	if req, err = http.NewRequest("GET", url, nil); err != nil {
+	if req, err = http.NewRequestWithContext(ctx, "GET", url, nil); err != nil {
 		return body, err
 	}
 	q := req.URL.Query()
@@ -161,8 +165,8 @@ func checkForScrapeTrap(body []byte) bool {
 }
 
 // getCategoryContents fetches a category page from the Coles API and unmarshals it.
-func (c *Coles) getCategoryContents(category string, page int) (categoryPage, error) {
-	body, err := c.getCategoryJSON(category, page)
+func (c *Coles) getCategoryContents(ctx context.Context, category string, page int) (categoryPage, error) {
+	body, err := c.getCategoryJSON(ctx, category, page)
 	if err != nil {
 		return categoryPage{}, err
 	}
@@ -177,8 +181,8 @@ func (c *Coles) getCategoryContents(category string, page int) (categoryPage, er
 
 // getProductsAndTotalCountForCategoryPage fetches the specified page of the specified category
 // and returns the products and the total count of products in the category.
-func (c *Coles) getProductsAndTotalCountForCategoryPage(dp departmentPage) ([]colesProductInfo, int, error) {
-	catPage, err := c.getCategoryContents(dp.ID, dp.page)
+func (c *Coles) getProductsAndTotalCountForCategoryPage(ctx context.Context, dp departmentPage) ([]colesProductInfo, int, error) {
+	catPage, err := c.getCategoryContents(ctx, dp.ID, dp.page)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -201,8 +205,8 @@ func (c *Coles) getProductsAndTotalCountForCategoryPage(dp departmentPage) ([]co
 	return products, catPage.PageProps.SearchResults.NoOfResults, nil
 }
 
-func (c *Coles) getDepartmentInfos() ([]departmentInfo, error) {
-	body, err := c.getBrowseJSON()
+func (c *Coles) getDepartmentInfos(ctx context.Context) ([]departmentInfo, error) {
+	body, err := c.getBrowseJSON(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -213,3 +217,33 @@ func (c *Coles) getDepartmentInfos() ([]departmentInfo, error) {
 	}
 	return browseJSON.PageProps.AllProductCategories.CatalogGroupView, nil
 }
+
+// FetchDepartments fetches every department, for the Scraper. The API version is checked first, as it changes
+// every so often.
+func (c *Coles) FetchDepartments(ctx context.Context) ([]store.Department, error) {
+	if err := c.updateAPIVersion(ctx); err != nil {
+		c.logger.Error("error updating API version", "error", err)
+	}
+	departmentInfos, err := c.getDepartmentInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departments := make([]store.Department, len(departmentInfos))
+	for i, departmentInfo := range departmentInfos {
+		departments[i] = departmentFromInfo(departmentInfo)
+	}
+	return departments, nil
+}
+
+// FetchProducts fetches a page of a department's products, for the Scraper.
+func (c *Coles) FetchProducts(ctx context.Context, department string, page int) ([]store.Product, error) {
+	productInfos, _, err := c.getProductsAndTotalCountForCategoryPage(ctx, departmentPage{ID: department, page: page})
+	if err != nil {
+		return nil, err
+	}
+	products := make([]store.Product, len(productInfos))
+	for i, productInfo := range productInfos {
+		products[i] = c.productFromInfo(productInfo)
+	}
+	return products, nil
+}
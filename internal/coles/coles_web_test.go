@@ -1,6 +1,7 @@
 package coles
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -10,14 +11,24 @@ import (
 	"testing"
 	"time"
 
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 	"github.com/tjhowse/aus_grocery_price_database/internal/utils"
 )
 
 var colesServer = ColesHTTPServer()
 
-func getInitialisedColes() Coles {
-	c := Coles{}
-	err := c.Init(colesServer.URL, ":memory:", 10*time.Minute)
+// openTestDB opens a fresh in-memory DB.
+func openTestDB() *store.DB {
+	db, err := store.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func getInitialisedColes() *Coles {
+	c := &Coles{}
+	err := c.Init(colesServer.URL, openTestDB(), 10*time.Minute)
 	if err != nil {
 		slog.Error("Failed to initialise Coles", "error", err)
 	}
@@ -87,7 +98,7 @@ func ColesHTTPServer() *httptest.Server {
 
 func TestGetHomepage(t *testing.T) {
 	c := getInitialisedColes()
-	body, err := c.getBrowseHomepage()
+	body, err := c.getBrowseHomepage(context.Background())
 	if err != nil {
 		t.Errorf("Failed to get homepage: %v", err)
 	}
@@ -138,7 +149,7 @@ func TestUpdateAPIVersion(t *testing.T) {
 	c := getInitialisedColes()
 	// Set a deliberately old version
 	c.colesAPIVersion = "20240809.03_v4.7.3"
-	err := c.updateAPIVersion()
+	err := c.updateAPIVersion(context.Background())
 	if err != nil {
 		t.Errorf("Failed to update API version: %v", err)
 	}
@@ -151,10 +162,10 @@ func TestGetCategoryJSON(t *testing.T) {
 	c := getInitialisedColes()
 	// c.baseURL = "https://coles.com.au"
 	// c.colesAPIVersion = "20240827.02_v4.7.7"
-	// if err := c.updateAPIVersion(); err != nil {
+	// if err := c.updateAPIVersion(context.Background()); err != nil {
 	// 	t.Fatalf("Failed to update API version: %v", err)
 	// }
-	body, err := c.getCategoryJSON("fruit-vegetables", 1)
+	body, err := c.getCategoryJSON(context.Background(), "fruit-vegetables", 1)
 	if err != nil {
 		t.Fatalf("Failed to get category JSON: %v", err)
 	}
@@ -168,8 +179,8 @@ func TestGetProductsAndTotalCountForCategoryPage(t *testing.T) {
 	c := getInitialisedColes()
 
 	{
-		dp := departmentPage{"fruit-vegetables", 1}
-		products, totalRecordCount, err := c.getProductsAndTotalCountForCategoryPage(dp)
+		dp := departmentPage{ID: "fruit-vegetables", page: 1}
+		products, totalRecordCount, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
 		if err != nil {
 			t.Fatalf("Failed to get products: %v", err)
 		}
@@ -188,8 +199,8 @@ func TestGetProductsAndTotalCountForCategoryPage(t *testing.T) {
 
 	}
 	{
-		dp := departmentPage{"fruit-vegetables", 2}
-		products, totalRecordCount, err := c.getProductsAndTotalCountForCategoryPage(dp)
+		dp := departmentPage{ID: "fruit-vegetables", page: 2}
+		products, totalRecordCount, err := c.getProductsAndTotalCountForCategoryPage(context.Background(), dp)
 		if err != nil {
 			t.Fatalf("Failed to get products: %v", err)
 		}
@@ -212,9 +223,9 @@ func TestGetDepartmentInfos(t *testing.T) {
 	slog.SetLogLoggerLevel(slog.LevelDebug)
 	// c := getInitialisedRealColes()
 	c := getInitialisedColes()
-	// c.updateAPIVersion()
+	// c.updateAPIVersion(context.Background())
 
-	departments, err := c.getDepartmentInfos()
+	departments, err := c.getDepartmentInfos(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to get department list: %v", err)
 	}
@@ -33,9 +33,20 @@ type productListPageProductPricing struct {
 		OfMeasureType     string          `json:"ofMeasureType"`
 		IsWeighted        bool            `json:"isWeighted"`
 	} `json:"unit"`
-	Comparable    string `json:"comparable"`
-	PromotionType string `json:"promotionType"`
-	OnlineSpecial bool   `json:"onlineSpecial"`
+	Comparable        string             `json:"comparable"`
+	PromotionType     string             `json:"promotionType"`
+	OnlineSpecial     bool               `json:"onlineSpecial"`
+	SpecialType       string             `json:"specialType"`
+	OfferDescription  string             `json:"offerDescription"`
+	MultiBuyPromotion *multiBuyPromotion `json:"multiBuyPromotion"`
+}
+
+// multiBuyPromotion is an offer such as "Pick any 2 for $5", where the reward is the price of each item.
+type multiBuyPromotion struct {
+	Type        string          `json:"type"`
+	ID          string          `json:"id"`
+	MinQuantity int             `json:"minQuantity"`
+	Reward      decimal.Decimal `json:"reward"`
 }
 
 type productListPageProduct struct {
@@ -0,0 +1,143 @@
+package fanout
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// The most writes that can be waiting for each sink before new ones are dropped.
+const FANOUT_QUEUE_SIZE = 10000
+
+// How long Close waits for each sink to catch up before giving up on it.
+const FANOUT_CLOSE_TIMEOUT = 30 * time.Second
+
+// Sink is a timeseries database the fan-out writes to. Products aren't written through the fan-out, they're
+// delivered to each sink by the outbox.
+type Sink interface {
+	WriteArbitrarySystemDatapoint(string, interface{})
+	WriteSystemDatapoint(shared.SystemStatusDatapoint)
+	WriteFalseSaleDatapoint(shared.FalseSaleFinding)
+	WritePriceCycleDatapoint(shared.PriceCycle)
+	Close()
+}
+
+type write func(Sink)
+
+// sinkWorker feeds one sink from its own queue, so a slow sink only holds up itself.
+type sinkWorker struct {
+	name     string
+	sink     Sink
+	queue    chan write
+	dropped  atomic.Int64
+	dropping atomic.Bool
+	done     chan struct{}
+}
+
+func (w *sinkWorker) run() {
+	defer close(w.done)
+	for apply := range w.queue {
+		apply(w.sink)
+	}
+}
+
+// Fanout writes everything to several timeseries databases at once, E.G. while migrating from one to
+// another. Each sink has its own queue, so a slow or failing sink can't hold up the others or the caller.
+type Fanout struct {
+	sinks     []*sinkWorker
+	queueSize int
+	closed    bool
+	mu        sync.RWMutex
+	logger    *slog.Logger
+}
+
+func (f *Fanout) Init() {
+	f.logger = slog.With("component", "fanout")
+	f.queueSize = FANOUT_QUEUE_SIZE
+}
+
+// AddSink starts writing to another sink. The name identifies it in the logs.
+func (f *Fanout) AddSink(name string, sink Sink) {
+	w := &sinkWorker{
+		name:  name,
+		sink:  sink,
+		queue: make(chan write, f.queueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	f.mu.Lock()
+	f.sinks = append(f.sinks, w)
+	f.mu.Unlock()
+	f.logger.Info("Timeseries sink enabled", "sink", name)
+}
+
+// enqueue queues a write for every sink. It never blocks; a sink whose queue is full misses out.
+func (f *Fanout) enqueue(apply write) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return
+	}
+	for _, w := range f.sinks {
+		select {
+		case w.queue <- apply:
+			if w.dropping.Swap(false) {
+				f.logger.Info("Sink caught up", "sink", w.name, "dropped", w.dropped.Load())
+			}
+		default:
+			w.dropped.Add(1)
+			if !w.dropping.Swap(true) {
+				f.logger.Warn("Sink queue full, dropping writes", "sink", w.name)
+			}
+		}
+	}
+}
+
+func (f *Fanout) WriteArbitrarySystemDatapoint(field string, value interface{}) {
+	f.enqueue(func(s Sink) { s.WriteArbitrarySystemDatapoint(field, value) })
+}
+
+func (f *Fanout) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
+	f.enqueue(func(s Sink) { s.WriteSystemDatapoint(data) })
+}
+
+func (f *Fanout) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {
+	f.enqueue(func(s Sink) { s.WriteFalseSaleDatapoint(finding) })
+}
+
+func (f *Fanout) WritePriceCycleDatapoint(cycle shared.PriceCycle) {
+	f.enqueue(func(s Sink) { s.WritePriceCycleDatapoint(cycle) })
+}
+
+// Close waits for each sink to finish its queued writes, then closes it. A sink that doesn't catch up
+// within FANOUT_CLOSE_TIMEOUT is abandoned.
+func (f *Fanout) Close() {
+	f.mu.Lock()
+	f.closed = true
+	for _, w := range f.sinks {
+		close(w.queue)
+	}
+	f.mu.Unlock()
+
+	deadline := time.NewTimer(FANOUT_CLOSE_TIMEOUT)
+	defer deadline.Stop()
+	timedOut := false
+	for _, w := range f.sinks {
+		if !timedOut {
+			select {
+			case <-w.done:
+			case <-deadline.C:
+				timedOut = true
+			}
+		}
+		select {
+		case <-w.done:
+			w.sink.Close()
+		default:
+			f.logger.Error("Sink didn't catch up before closing", "sink", w.name, "queued", len(w.queue))
+		}
+	}
+}
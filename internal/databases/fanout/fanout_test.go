@@ -0,0 +1,124 @@
+package fanout
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockSink struct {
+	system []shared.SystemStatusDatapoint
+	block  chan struct{} // If set, every write waits for this to be closed
+	closed bool
+	mu     sync.Mutex
+}
+
+func (m *MockSink) wait() {
+	if m.block != nil {
+		<-m.block
+	}
+}
+
+func (m *MockSink) WriteArbitrarySystemDatapoint(field string, value interface{}) {}
+
+func (m *MockSink) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
+	m.wait()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.system = append(m.system, data)
+}
+
+func (m *MockSink) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {}
+
+func (m *MockSink) WritePriceCycleDatapoint(cycle shared.PriceCycle) {}
+
+func (m *MockSink) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+}
+
+func (m *MockSink) SystemCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.system)
+}
+
+func TestFanout(t *testing.T) {
+	f := Fanout{}
+	f.Init()
+	a, b := &MockSink{}, &MockSink{}
+	f.AddSink("a", a)
+	f.AddSink("b", b)
+
+	for i := 0; i < 10; i++ {
+		f.WriteSystemDatapoint(shared.SystemStatusDatapoint{TotalProductCount: 100 + i})
+	}
+	f.Close()
+
+	for _, sink := range []*MockSink{a, b} {
+		if want, got := 10, sink.SystemCount(); want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+		if !sink.closed {
+			t.Errorf("Expected the sink to be closed")
+		}
+	}
+	// Writes should arrive in order.
+	if want, got := 109, a.system[9].TotalProductCount; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Writes after closing are ignored rather than panicking.
+	f.WriteSystemDatapoint(shared.SystemStatusDatapoint{})
+}
+
+func TestFanoutSlowSink(t *testing.T) {
+	f := Fanout{}
+	f.Init()
+	f.queueSize = 10
+	fast := &MockSink{}
+	slow := &MockSink{block: make(chan struct{})}
+	f.AddSink("fast", fast)
+	f.AddSink("slow", slow)
+
+	// The slow sink gets stuck on the first write and its queue fills up behind it, but writes never
+	// block and the fast sink keeps up.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 20; i++ {
+			f.WriteSystemDatapoint(shared.SystemStatusDatapoint{})
+			for len(f.sinks[0].queue) != 0 || (i == 0 && len(f.sinks[1].queue) != 0) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writes blocked on a slow sink")
+	}
+
+	if want, got := int64(0), f.sinks[0].dropped.Load(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// One write is stuck in the sink, the queue is full behind it and the rest were dropped.
+	if want, got := int64(9), f.sinks[1].dropped.Load(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 10, len(f.sinks[1].queue); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	close(slow.block)
+	f.Close()
+	if want, got := 20, fast.SystemCount(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 11, slow.SystemCount(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
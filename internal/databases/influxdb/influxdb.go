@@ -1,11 +1,18 @@
 package influxdb
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/tjhowse/aus_grocery_price_database/internal/outbox"
 	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
 )
 
@@ -13,6 +20,8 @@ type InfluxDB struct {
 	db              influxdb2.Client
 	groceryWriteAPI api.WriteAPI
 	systemWriteAPI  api.WriteAPI
+	productWriteAPI api.WriteAPIBlocking // For product writes that need acknowledging
+	errors          atomic.Int64
 }
 
 func (i *InfluxDB) Init(url, token, org, bucket string) {
@@ -20,27 +29,82 @@ func (i *InfluxDB) Init(url, token, org, bucket string) {
 	i.db = influxdb2.NewClient(url, token)
 	i.groceryWriteAPI = i.db.WriteAPI(org, bucket)
 	i.systemWriteAPI = i.db.WriteAPI(org, "system")
+	i.productWriteAPI = i.db.WriteAPIBlocking(org, bucket)
+	i.groceryWriteAPI.SetWriteFailedCallback(i.writeFailed)
+	i.systemWriteAPI.SetWriteFailedCallback(i.writeFailed)
 }
 
-func (i *InfluxDB) WriteProductDatapoint(info shared.ProductInfo) {
+// writeFailed counts failed batch writes, leaving the client to retry them as usual.
+func (i *InfluxDB) writeFailed(batch string, err http.Error, retryAttempts uint) bool {
+	i.errors.Add(1)
+	slog.Warn("Failed to write to InfluxDB", "error", err.Error(), "retryAttempts", retryAttempts)
+	return true
+}
+
+// ErrorCount returns how many batch writes have failed.
+func (i *InfluxDB) ErrorCount() int64 {
+	return i.errors.Load()
+}
+
+// productPoint converts a product to a point. The point's timestamp is the product's, so writing the
+// same product twice overwrites the first point rather than adding another.
+func productPoint(info shared.ProductInfo) *write.Point {
 	values := map[string]interface{}{"cents": info.PriceCents, "grams": info.WeightGrams}
 
 	// If the price has meaningfully changed, report the change
 	if info.PriceCents != 0 && info.PreviousPriceCents != 0 && info.PriceCents != info.PreviousPriceCents {
 		values["cents_change"] = info.PriceCents - info.PreviousPriceCents
 	}
-	p := influxdb2.NewPoint("product",
-		map[string]string{
-			"name":       info.Name,
-			"store":      info.Store,
-			"location":   info.Location,
-			"department": info.Department,
-			"id":         info.ID,
-		},
-		values,
-		info.Timestamp,
-	)
-	i.groceryWriteAPI.WritePoint(p)
+	tags := map[string]string{
+		"name":       info.Name,
+		"store":      info.Store,
+		"location":   info.Location,
+		"department": info.Department,
+		"id":         info.ID,
+	}
+	// Unit prices let products be compared across pack sizes.
+	if info.UnitType != "" {
+		values["unit_cents"] = info.UnitPriceCents
+		tags["unit_type"] = info.UnitType
+	}
+	// Promotions are tagged by type so specials can be filtered, with the details as fields.
+	if info.Promotion.Active() {
+		if info.Promotion.Type != "" {
+			tags["promotion_type"] = info.Promotion.Type
+		}
+		if info.Promotion.WasPriceCents != 0 {
+			values["was_cents"] = info.Promotion.WasPriceCents
+		}
+		if info.Promotion.MultibuyQuantity != 0 {
+			values["multibuy_quantity"] = info.Promotion.MultibuyQuantity
+			values["multibuy_cents"] = info.Promotion.MultibuyPriceCents
+		}
+		values["member_only"] = info.Promotion.MemberOnly
+	}
+	// Products matched across stores share a canonical ID, so they can be overlaid.
+	if info.CanonicalID != "" {
+		tags["canonical_id"] = info.CanonicalID
+	}
+	return influxdb2.NewPoint("product", tags, values, info.Timestamp)
+}
+
+// WriteProductDatapoints writes products and waits for InfluxDB to acknowledge them.
+func (i *InfluxDB) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	points := make([]*write.Point, 0, len(infos))
+	for _, info := range infos {
+		points = append(points, productPoint(info))
+	}
+	if err := i.productWriteAPI.WritePoint(context.Background(), points...); err != nil {
+		i.errors.Add(1)
+		err = fmt.Errorf("failed to write products: %w", err)
+		// InfluxDB rejects the whole batch if it can't parse any of it.
+		var httpErr *http.Error
+		if errors.As(err, &httpErr) && outbox.IsPermanentStatus(httpErr.StatusCode) {
+			return outbox.Permanent(err)
+		}
+		return err
+	}
+	return nil
 }
 
 func (i *InfluxDB) WriteArbitrarySystemDatapoint(field string, value interface{}) {
@@ -52,6 +116,47 @@ func (i *InfluxDB) WriteArbitrarySystemDatapoint(field string, value interface{}
 	i.systemWriteAPI.WritePoint(p)
 }
 
+// WriteFalseSaleDatapoint records a promotion that doesn't stand up against the product's price history.
+func (i *InfluxDB) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {
+	p := influxdb2.NewPoint("false_sale",
+		map[string]string{
+			"id":             finding.ProductID,
+			"name":           finding.Name,
+			"store":          finding.Store,
+			"promotion_type": finding.PromotionType,
+			"reason":         finding.Reason,
+		},
+		map[string]interface{}{
+			"cents":                finding.PriceCents,
+			"was_cents":            finding.WasPriceCents,
+			"median_cents":         finding.MedianPriceCents,
+			"was_charged_fraction": finding.WasPriceChargedFraction,
+		},
+		finding.Detected,
+	)
+	i.groceryWriteAPI.WritePoint(p)
+}
+
+// WritePriceCycleDatapoint records a repeating pattern in a product's price and when it's next expected to be low.
+func (i *InfluxDB) WritePriceCycleDatapoint(cycle shared.PriceCycle) {
+	p := influxdb2.NewPoint("price_cycle",
+		map[string]string{
+			"id":    cycle.ProductID,
+			"name":  cycle.Name,
+			"store": cycle.Store,
+		},
+		map[string]interface{}{
+			"period_days":    cycle.PeriodDays,
+			"strength":       cycle.Strength,
+			"low_cents":      cycle.LowPriceCents,
+			"high_cents":     cycle.HighPriceCents,
+			"days_until_low": cycle.DaysUntilLow(cycle.Detected),
+		},
+		cycle.Detected,
+	)
+	i.groceryWriteAPI.WritePoint(p)
+}
+
 func (i *InfluxDB) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
 	p := influxdb2.NewPoint("system",
 		map[string]string{},
@@ -64,14 +169,18 @@ func (i *InfluxDB) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
 		time.Now(),
 	)
 	i.systemWriteAPI.WritePoint(p)
-}
-
-// WriteWorker writes ProductInfo to InfluxDB
-// Note that the underlying library automatically batches writes
-// so we don't need to worry about that here.
-func (i *InfluxDB) WriteWorker(input <-chan shared.ProductInfo) {
-	for info := range input {
-		i.WriteProductDatapoint(info)
+	for _, sink := range data.Sinks {
+		p := influxdb2.NewPoint("system",
+			map[string]string{"sink": sink.Name},
+			map[string]interface{}{
+				shared.SYSTEM_SINK_QUEUED_FIELD:      sink.Queued,
+				shared.SYSTEM_SINK_DROPPED_FIELD:     sink.Dropped,
+				shared.SYSTEM_SINK_ERRORS_FIELD:      sink.Errors,
+				shared.SYSTEM_SINK_LAG_SECONDS_FIELD: sink.LagSeconds,
+			},
+			time.Now(),
+		)
+		i.systemWriteAPI.WritePoint(p)
 	}
 }
 
@@ -1,11 +1,15 @@
 package influxdb
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
 	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/tjhowse/aus_grocery_price_database/internal/outbox"
 	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
 )
 
@@ -31,6 +35,37 @@ func (m *MockInfluxdbWriteAPI) Errors() <-chan error {
 
 func (m *MockInfluxdbWriteAPI) SetWriteFailedCallback(cb api.WriteFailedCallback) {}
 
+type MockInfluxdbWriteAPIBlocking struct {
+	writtenPoints []*write.Point
+	err           error
+}
+
+func (m *MockInfluxdbWriteAPIBlocking) WritePoint(ctx context.Context, p ...*write.Point) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.writtenPoints = append(m.writtenPoints, p...)
+	return nil
+}
+
+func (m *MockInfluxdbWriteAPIBlocking) WriteRecord(ctx context.Context, line ...string) error {
+	return nil
+}
+
+func (m *MockInfluxdbWriteAPIBlocking) EnableBatching() {}
+
+func (m *MockInfluxdbWriteAPIBlocking) Flush(ctx context.Context) error {
+	return nil
+}
+
+// InitMockInfluxDBProducts returns an InfluxDB whose product writes go to a mock.
+func InitMockInfluxDBProducts() (*InfluxDB, *MockInfluxdbWriteAPIBlocking) {
+	i, _, _ := InitMockInfluxDB()
+	productMock := &MockInfluxdbWriteAPIBlocking{}
+	i.productWriteAPI = productMock
+	return i, productMock
+}
+
 func InitMockInfluxDB() (*InfluxDB, *MockInfluxdbWriteAPI, *MockInfluxdbWriteAPI) {
 	i := InfluxDB{}
 	groceryMock := &MockInfluxdbWriteAPI{}
@@ -47,19 +82,23 @@ func TestWriteProductDatapoint(t *testing.T) {
 		"store":      "Test Store",
 		"location":   "Test Location",
 		"department": "Test Department",
+		"id":         "test_id_1",
 	}
-	i, gMock, _ := InitMockInfluxDB()
-	i.WriteProductDatapoint(shared.ProductInfo{
+	i, pMock := InitMockInfluxDBProducts()
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
 		Name:               desiredTags["name"],
 		Store:              desiredTags["store"],
 		Location:           desiredTags["location"],
 		Department:         desiredTags["department"],
+		ID:                 desiredTags["id"],
 		PriceCents:         100,
 		PreviousPriceCents: 0,
 		WeightGrams:        1000,
 		Timestamp:          time.Now(),
-	})
-	i.WriteProductDatapoint(shared.ProductInfo{
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
 		Name:               desiredTags["name"],
 		Store:              desiredTags["store"],
 		Location:           desiredTags["location"],
@@ -68,8 +107,10 @@ func TestWriteProductDatapoint(t *testing.T) {
 		PreviousPriceCents: 100,
 		WeightGrams:        1000,
 		Timestamp:          time.Now(),
-	})
-	i.WriteProductDatapoint(shared.ProductInfo{
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
 		Name:               desiredTags["name"],
 		Store:              desiredTags["store"],
 		Location:           desiredTags["location"],
@@ -78,14 +119,16 @@ func TestWriteProductDatapoint(t *testing.T) {
 		PreviousPriceCents: 101,
 		WeightGrams:        1000,
 		Timestamp:          time.Now(),
-	})
+	}}); err != nil {
+		t.Fatal(err)
+	}
 
-	if want, got := 3, len(gMock.writtenPoints); want != got {
+	if want, got := 3, len(pMock.writtenPoints); want != got {
 		t.Errorf("want %d, got %d", want, got)
 	}
 
 	// Check the first written point.
-	p := gMock.writtenPoints[0]
+	p := pMock.writtenPoints[0]
 	if want, got := "product", p.Name(); want != got {
 		t.Errorf("want %s, got %s", want, got)
 	}
@@ -114,7 +157,12 @@ func TestWriteProductDatapoint(t *testing.T) {
 	}
 
 	// Now check the second written point.
-	p = gMock.writtenPoints[1]
+	p = pMock.writtenPoints[1]
+	for _, tag := range p.TagList() {
+		if tag.Key == "canonical_id" {
+			t.Errorf("unexpected tag %s", tag.Key)
+		}
+	}
 
 	for _, field := range p.FieldList() {
 		switch field.Key {
@@ -134,7 +182,7 @@ func TestWriteProductDatapoint(t *testing.T) {
 	}
 
 	// Now check the third written point.
-	p = gMock.writtenPoints[2]
+	p = pMock.writtenPoints[2]
 
 	for _, field := range p.FieldList() {
 		switch field.Key {
@@ -152,3 +200,252 @@ func TestWriteProductDatapoint(t *testing.T) {
 	}
 
 }
+
+func TestWriteProductDatapointCanonicalID(t *testing.T) {
+	i, pMock := InitMockInfluxDBProducts()
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
+		Name:        "Test Product",
+		Store:       "Test Store",
+		ID:          "test_id_1",
+		CanonicalID: "canonical_1",
+		PriceCents:  100,
+		Timestamp:   time.Now(),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, tag := range pMock.writtenPoints[0].TagList() {
+		if tag.Key == "canonical_id" {
+			found = true
+			if want, got := "canonical_1", tag.Value; want != got {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("canonical_id tag missing")
+	}
+}
+
+func TestWriteProductDatapointUnitPrice(t *testing.T) {
+	i, pMock := InitMockInfluxDBProducts()
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
+		Name:           "Test Product",
+		Store:          "Test Store",
+		ID:             "test_id_1",
+		PriceCents:     290,
+		UnitPriceCents: 1160,
+		UnitType:       shared.UNIT_TYPE_MASS,
+		Timestamp:      time.Now(),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pMock.writtenPoints[0]
+	var found bool
+	for _, field := range p.FieldList() {
+		if field.Key == "unit_cents" {
+			found = true
+			if want, got := 1160.0, field.Value.(float64); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("unit_cents field missing")
+	}
+	for _, tag := range p.TagList() {
+		if tag.Key == "unit_type" {
+			if want, got := shared.UNIT_TYPE_MASS, tag.Value; want != got {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		}
+	}
+}
+
+func TestWriteProductDatapointPromotion(t *testing.T) {
+	i, pMock := InitMockInfluxDBProducts()
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{
+		Name:       "Test Product",
+		Store:      "Test Store",
+		ID:         "test_id_1",
+		PriceCents: 260,
+		Promotion: shared.Promotion{
+			Type:               shared.PROMOTION_TYPE_HALF_PRICE,
+			WasPriceCents:      520,
+			MultibuyQuantity:   2,
+			MultibuyPriceCents: 400,
+		},
+		Timestamp: time.Now(),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pMock.writtenPoints[0]
+	var found bool
+	for _, tag := range p.TagList() {
+		if tag.Key == "promotion_type" {
+			found = true
+			if want, got := shared.PROMOTION_TYPE_HALF_PRICE, tag.Value; want != got {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("promotion_type tag missing")
+	}
+	wantFields := map[string]interface{}{
+		"was_cents":         int64(520),
+		"multibuy_quantity": int64(2),
+		"multibuy_cents":    int64(400),
+		"member_only":       false,
+	}
+	for _, field := range p.FieldList() {
+		want, ok := wantFields[field.Key]
+		if !ok {
+			continue
+		}
+		if got := field.Value; want != got {
+			t.Errorf("want %v, got %v for %s", want, got, field.Key)
+		}
+		delete(wantFields, field.Key)
+	}
+	for key := range wantFields {
+		t.Errorf("%s field missing", key)
+	}
+}
+
+func TestWriteFalseSaleDatapoint(t *testing.T) {
+	i, gMock, _ := InitMockInfluxDB()
+	i.WriteFalseSaleDatapoint(shared.FalseSaleFinding{
+		ProductID:        "test_id_1",
+		Name:             "Test Product",
+		Store:            "Test Store",
+		PromotionType:    shared.PROMOTION_TYPE_HALF_PRICE,
+		Reason:           shared.FALSE_SALE_REASON_NOT_BELOW_MEDIAN,
+		PriceCents:       400,
+		WasPriceCents:    800,
+		MedianPriceCents: 400,
+		Detected:         time.Now(),
+	})
+
+	if want, got := 1, len(gMock.writtenPoints); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+	p := gMock.writtenPoints[0]
+	if want, got := "false_sale", p.Name(); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	for _, tag := range p.TagList() {
+		if tag.Key == "reason" {
+			if want, got := shared.FALSE_SALE_REASON_NOT_BELOW_MEDIAN, tag.Value; want != got {
+				t.Errorf("want %s, got %s", want, got)
+			}
+		}
+	}
+	for _, field := range p.FieldList() {
+		if field.Key == "median_cents" {
+			if want, got := int64(400), field.Value.(int64); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		}
+	}
+}
+
+func TestWritePriceCycleDatapoint(t *testing.T) {
+	i, gMock, _ := InitMockInfluxDB()
+	now := time.Now()
+	i.WritePriceCycleDatapoint(shared.PriceCycle{
+		ProductID:      "test_id_1",
+		Name:           "Test Product",
+		Store:          "Test Store",
+		PeriodDays:     14,
+		Strength:       0.9,
+		LowPriceCents:  300,
+		HighPriceCents: 450,
+		NextLowStart:   now.Add(5 * 24 * time.Hour),
+		NextLowEnd:     now.Add(12 * 24 * time.Hour),
+		Detected:       now,
+	})
+
+	p := gMock.writtenPoints[0]
+	if want, got := "price_cycle", p.Name(); want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	for _, field := range p.FieldList() {
+		switch field.Key {
+		case "period_days":
+			if want, got := int64(14), field.Value.(int64); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		case "days_until_low":
+			if want, got := int64(5), field.Value.(int64); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		}
+	}
+}
+
+func TestWriteSystemDatapointSinks(t *testing.T) {
+	i, _, sMock := InitMockInfluxDB()
+	i.WriteSystemDatapoint(shared.SystemStatusDatapoint{
+		TotalProductCount: 100,
+		Sinks: []shared.SinkStatus{
+			{Name: "influxdb"},
+			{Name: "timescaledb", Queued: 20, Dropped: 3, LagSeconds: 1.5},
+		},
+	})
+
+	if want, got := 3, len(sMock.writtenPoints); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+	p := sMock.writtenPoints[2]
+	if want, got := "timescaledb", p.TagList()[0].Value; want != got {
+		t.Errorf("want %s, got %s", want, got)
+	}
+	for _, field := range p.FieldList() {
+		if field.Key == shared.SYSTEM_SINK_DROPPED_FIELD {
+			if want, got := int64(3), field.Value.(int64); want != got {
+				t.Errorf("want %v, got %v", want, got)
+			}
+		}
+	}
+}
+
+func TestWriteProductDatapoints(t *testing.T) {
+	i, _, _ := InitMockInfluxDB()
+	pMock := &MockInfluxdbWriteAPIBlocking{}
+	i.productWriteAPI = pMock
+	now := time.Now()
+	err := i.WriteProductDatapoints([]shared.ProductInfo{
+		{ID: "test_id_1", PriceCents: 100, Timestamp: now},
+		{ID: "test_id_2", PriceCents: 200, Timestamp: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(pMock.writtenPoints); want != got {
+		t.Fatalf("want %d, got %d", want, got)
+	}
+	if want, got := now, pMock.writtenPoints[1].Time(); !want.Equal(got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	pMock.err = errors.New("connection refused")
+	err = i.WriteProductDatapoints([]shared.ProductInfo{{ID: "test_id_1"}})
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+	if outbox.IsPermanent(err) {
+		t.Errorf("Expected a temporary error, got %v", err)
+	}
+	if want, got := int64(1), i.ErrorCount(); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+
+	pMock.err = &http.Error{StatusCode: 400, Code: "invalid", Message: "unable to parse"}
+	if err := i.WriteProductDatapoints([]shared.ProductInfo{{ID: "test_id_1"}}); !outbox.IsPermanent(err) {
+		t.Errorf("Expected a permanent error, got %v", err)
+	}
+}
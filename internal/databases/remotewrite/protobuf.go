@@ -0,0 +1,82 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// The remote write protocol is a snappy compressed protobuf WriteRequest. The message is simple enough
+// to encode by hand rather than pulling in the Prometheus module:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type label struct {
+	name  string
+	value string
+}
+
+// series is a single sample of a metric, identified by its labels.
+type series struct {
+	labels      []label // Including __name__
+	value       float64
+	timestampMs int64
+}
+
+// newSeries builds a series from a metric name and its labels, dropping empty labels and sorting
+// them by name as the protocol requires.
+func newSeries(name string, labels map[string]string, value float64, timestampMs int64) series {
+	s := series{labels: []label{{"__name__", name}}, value: value, timestampMs: timestampMs}
+	for k, v := range labels {
+		if v != "" {
+			s.labels = append(s.labels, label{k, v})
+		}
+	}
+	sort.Slice(s.labels, func(i, j int) bool { return s.labels[i].name < s.labels[j].name })
+	return s
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func encodeLabel(l label) []byte {
+	b := appendBytesField(nil, 1, []byte(l.name))
+	return appendBytesField(b, 2, []byte(l.value))
+}
+
+func encodeSample(value float64, timestampMs int64) []byte {
+	b := appendTag(nil, 1, wireFixed64)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(value))
+	b = appendTag(b, 2, wireVarint)
+	return binary.AppendUvarint(b, uint64(timestampMs))
+}
+
+// encodeWriteRequest encodes the series as an uncompressed WriteRequest.
+func encodeWriteRequest(batch []series) []byte {
+	var request []byte
+	for _, s := range batch {
+		var ts []byte
+		for _, l := range s.labels {
+			ts = appendBytesField(ts, 1, encodeLabel(l))
+		}
+		ts = appendBytesField(ts, 2, encodeSample(s.value, s.timestampMs))
+		request = appendBytesField(request, 1, ts)
+	}
+	return request
+}
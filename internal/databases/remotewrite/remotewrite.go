@@ -0,0 +1,280 @@
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/tjhowse/aus_grocery_price_database/internal/outbox"
+	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// The prefix on every metric name.
+const METRIC_PREFIX = shared.SYSTEM_SERVICE_NAME + "_"
+
+// The most series sent in one request.
+const REMOTE_WRITE_BATCH_SIZE = 1000
+
+// How often pending series are sent, even if there isn't a full batch.
+const REMOTE_WRITE_FLUSH_INTERVAL = 10 * time.Second
+
+const REMOTE_WRITE_TIMEOUT = 30 * time.Second
+
+// RemoteWrite sends datapoints to anything that accepts the Prometheus remote write protocol, E.G.
+// Prometheus itself with --web.enable-remote-write-receiver, or VictoriaMetrics.
+type RemoteWrite struct {
+	url         string
+	username    string
+	password    string
+	bearerToken string
+	client      *http.Client
+	pending     []series
+	mu          sync.Mutex
+	flushNow    chan struct{}
+	stop        chan struct{}
+	stopped     chan struct{}
+	errors      atomic.Int64
+	logger      *slog.Logger
+}
+
+// Init sets up the writer and starts sending datapoints in the background. The URL is the remote write
+// endpoint, E.G. http://localhost:9090/api/v1/write. Credentials are optional.
+func (r *RemoteWrite) Init(url, username, password, bearerToken string) {
+	r.logger = slog.With("component", "remotewrite")
+	r.logger.Info("Initialising remote write", "url", url)
+	r.url = url
+	r.username = username
+	r.password = password
+	r.bearerToken = bearerToken
+	r.client = &http.Client{Timeout: REMOTE_WRITE_TIMEOUT}
+	r.flushNow = make(chan struct{}, 1)
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	go r.flushLoop()
+}
+
+// timestampMs returns the time in milliseconds, using now if it isn't set.
+func timestampMs(t time.Time) int64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UnixMilli()
+}
+
+// add queues series to be sent, waking the flush loop once there's a full batch.
+func (r *RemoteWrite) add(s ...series) {
+	r.mu.Lock()
+	r.pending = append(r.pending, s...)
+	full := len(r.pending) >= REMOTE_WRITE_BATCH_SIZE
+	r.mu.Unlock()
+	if full {
+		select {
+		case r.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// productSeries converts a product to series. They're timestamped with the product's timestamp, so
+// sending the same product twice is harmless.
+func productSeries(info shared.ProductInfo) []series {
+	labels := map[string]string{
+		"id":           info.ID,
+		"name":         info.Name,
+		"store":        info.Store,
+		"location":     info.Location,
+		"department":   info.Department,
+		"canonical_id": info.CanonicalID,
+		"unit_type":    info.UnitType,
+	}
+	if info.Promotion.Active() {
+		labels["promotion_type"] = info.Promotion.Type
+	}
+	ts := timestampMs(info.Timestamp)
+	metrics := []series{
+		newSeries(METRIC_PREFIX+"product_cents", labels, float64(info.PriceCents), ts),
+		newSeries(METRIC_PREFIX+"product_grams", labels, float64(info.WeightGrams), ts),
+	}
+	if info.PriceCents != 0 && info.PreviousPriceCents != 0 && info.PriceCents != info.PreviousPriceCents {
+		metrics = append(metrics, newSeries(METRIC_PREFIX+"product_cents_change", labels, float64(info.PriceCents-info.PreviousPriceCents), ts))
+	}
+	if info.UnitType != "" {
+		metrics = append(metrics, newSeries(METRIC_PREFIX+"product_unit_cents", labels, info.UnitPriceCents, ts))
+	}
+	if info.Promotion.WasPriceCents != 0 {
+		metrics = append(metrics, newSeries(METRIC_PREFIX+"product_was_cents", labels, float64(info.Promotion.WasPriceCents), ts))
+	}
+	if info.Promotion.MultibuyQuantity != 0 {
+		metrics = append(metrics,
+			newSeries(METRIC_PREFIX+"product_multibuy_quantity", labels, float64(info.Promotion.MultibuyQuantity), ts),
+			newSeries(METRIC_PREFIX+"product_multibuy_cents", labels, float64(info.Promotion.MultibuyPriceCents), ts))
+	}
+	return metrics
+}
+
+// WriteProductDatapoints sends products straight away, returning once the endpoint has accepted them.
+func (r *RemoteWrite) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	var batch []series
+	for _, info := range infos {
+		batch = append(batch, productSeries(info)...)
+	}
+	for start := 0; start < len(batch); start += REMOTE_WRITE_BATCH_SIZE {
+		if err := r.send(batch[start:min(start+REMOTE_WRITE_BATCH_SIZE, len(batch))]); err != nil {
+			r.errors.Add(1)
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteArbitrarySystemDatapoint records a system value. Numbers become a gauge, anything else, E.G. the
+// version, becomes an info metric with the value as a label.
+func (r *RemoteWrite) WriteArbitrarySystemDatapoint(field string, value interface{}) {
+	labels := map[string]string{"service": shared.SYSTEM_SERVICE_NAME}
+	ts := timestampMs(time.Time{})
+	switch v := value.(type) {
+	case int:
+		r.add(newSeries(METRIC_PREFIX+field, labels, float64(v), ts))
+	case int64:
+		r.add(newSeries(METRIC_PREFIX+field, labels, float64(v), ts))
+	case float64:
+		r.add(newSeries(METRIC_PREFIX+field, labels, v, ts))
+	default:
+		labels[field] = fmt.Sprint(v)
+		r.add(newSeries(METRIC_PREFIX+field+"_info", labels, 1, ts))
+	}
+}
+
+// WriteFalseSaleDatapoint records a promotion that doesn't stand up against the product's price history.
+func (r *RemoteWrite) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {
+	labels := map[string]string{
+		"id":             finding.ProductID,
+		"name":           finding.Name,
+		"store":          finding.Store,
+		"promotion_type": finding.PromotionType,
+		"reason":         finding.Reason,
+	}
+	ts := timestampMs(finding.Detected)
+	r.add(
+		newSeries(METRIC_PREFIX+"false_sale_cents", labels, float64(finding.PriceCents), ts),
+		newSeries(METRIC_PREFIX+"false_sale_was_cents", labels, float64(finding.WasPriceCents), ts),
+		newSeries(METRIC_PREFIX+"false_sale_median_cents", labels, float64(finding.MedianPriceCents), ts),
+		newSeries(METRIC_PREFIX+"false_sale_was_charged_fraction", labels, finding.WasPriceChargedFraction, ts),
+	)
+}
+
+// WritePriceCycleDatapoint records a repeating pattern in a product's price and when it's next expected to be low.
+func (r *RemoteWrite) WritePriceCycleDatapoint(cycle shared.PriceCycle) {
+	labels := map[string]string{"id": cycle.ProductID, "name": cycle.Name, "store": cycle.Store}
+	ts := timestampMs(cycle.Detected)
+	r.add(
+		newSeries(METRIC_PREFIX+"price_cycle_period_days", labels, float64(cycle.PeriodDays), ts),
+		newSeries(METRIC_PREFIX+"price_cycle_strength", labels, cycle.Strength, ts),
+		newSeries(METRIC_PREFIX+"price_cycle_low_cents", labels, float64(cycle.LowPriceCents), ts),
+		newSeries(METRIC_PREFIX+"price_cycle_high_cents", labels, float64(cycle.HighPriceCents), ts),
+		newSeries(METRIC_PREFIX+"price_cycle_days_until_low", labels, float64(cycle.DaysUntilLow(cycle.Detected)), ts),
+	)
+}
+
+func (r *RemoteWrite) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
+	labels := map[string]string{"service": shared.SYSTEM_SERVICE_NAME}
+	ts := timestampMs(time.Time{})
+	r.add(
+		newSeries(METRIC_PREFIX+shared.SYSTEM_RAM_UTILISATION_PERCENT_FIELD, labels, data.RAMUtilisationPercent, ts),
+		newSeries(METRIC_PREFIX+shared.SYSTEM_PRODUCTS_PER_SECOND_FIELD, labels, data.ProductsPerSecond, ts),
+		newSeries(METRIC_PREFIX+shared.SYSTEM_HDD_BYTES_FREE_FIELD, labels, float64(data.HDDBytesFree), ts),
+		newSeries(METRIC_PREFIX+shared.SYSTEM_TOTAL_PRODUCT_COUNT_FIELD, labels, float64(data.TotalProductCount), ts),
+	)
+	for _, sink := range data.Sinks {
+		labels := map[string]string{"service": shared.SYSTEM_SERVICE_NAME, "sink": sink.Name}
+		r.add(
+			newSeries(METRIC_PREFIX+shared.SYSTEM_SINK_QUEUED_FIELD, labels, float64(sink.Queued), ts),
+			newSeries(METRIC_PREFIX+shared.SYSTEM_SINK_DROPPED_FIELD, labels, float64(sink.Dropped), ts),
+			newSeries(METRIC_PREFIX+shared.SYSTEM_SINK_ERRORS_FIELD, labels, float64(sink.Errors), ts),
+			newSeries(METRIC_PREFIX+shared.SYSTEM_SINK_LAG_SECONDS_FIELD, labels, sink.LagSeconds, ts),
+		)
+	}
+}
+
+// ErrorCount returns how many batches have failed to send.
+func (r *RemoteWrite) ErrorCount() int64 {
+	return r.errors.Load()
+}
+
+// flushLoop sends pending series whenever there's a full batch, and periodically otherwise.
+func (r *RemoteWrite) flushLoop() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(REMOTE_WRITE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			r.flush()
+			return
+		case <-ticker.C:
+			r.flush()
+		case <-r.flushNow:
+			r.flush()
+		}
+	}
+}
+
+// flush sends everything pending, a batch at a time. Batches that fail are logged and dropped.
+func (r *RemoteWrite) flush() {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	for len(pending) > 0 {
+		n := min(len(pending), REMOTE_WRITE_BATCH_SIZE)
+		if err := r.send(pending[:n]); err != nil {
+			r.errors.Add(1)
+			r.logger.Error("Failed to send datapoints", "count", n, "error", err)
+		}
+		pending = pending[n:]
+	}
+}
+
+// send posts a batch of series to the remote write endpoint.
+func (r *RemoteWrite) send(batch []series) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	req, err := http.NewRequest(http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", shared.SYSTEM_SERVICE_NAME)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.bearerToken)
+	} else if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(message))
+		if outbox.IsPermanentStatus(resp.StatusCode) {
+			return outbox.Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Close sends anything still pending and stops the flush loop.
+func (r *RemoteWrite) Close() {
+	close(r.stop)
+	<-r.stopped
+}
@@ -0,0 +1,283 @@
+package remotewrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/tjhowse/aus_grocery_price_database/internal/outbox"
+	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// readField reads one protobuf field, returning its number, wire type, value and the rest of the buffer.
+// Varints and fixed64s are returned in num, length delimited fields in data.
+func readField(b []byte) (field, wireType int, num uint64, data, rest []byte, err error) {
+	tag, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errors.New("bad tag")
+	}
+	b = b[n:]
+	field, wireType = int(tag>>3), int(tag&7)
+	switch wireType {
+	case wireVarint:
+		num, n = binary.Uvarint(b)
+		return field, wireType, num, nil, b[n:], nil
+	case wireFixed64:
+		return field, wireType, binary.LittleEndian.Uint64(b), nil, b[8:], nil
+	case wireBytes:
+		length, n := binary.Uvarint(b)
+		b = b[n:]
+		return field, wireType, 0, b[:length], b[length:], nil
+	}
+	return 0, 0, 0, nil, nil, errors.New("unknown wire type")
+}
+
+// decodeWriteRequest is the inverse of encodeWriteRequest, as a receiver would do it.
+func decodeWriteRequest(b []byte) ([]series, error) {
+	var result []series
+	for len(b) > 0 {
+		_, _, _, tsBytes, rest, err := readField(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+		var s series
+		for len(tsBytes) > 0 {
+			field, _, _, data, rest, err := readField(tsBytes)
+			if err != nil {
+				return nil, err
+			}
+			tsBytes = rest
+			for len(data) > 0 {
+				inner, _, num, value, rest, err := readField(data)
+				if err != nil {
+					return nil, err
+				}
+				data = rest
+				switch {
+				case field == 1 && inner == 1:
+					s.labels = append(s.labels, label{name: string(value)})
+				case field == 1 && inner == 2:
+					s.labels[len(s.labels)-1].value = string(value)
+				case field == 2 && inner == 1:
+					s.value = math.Float64frombits(num)
+				case field == 2 && inner == 2:
+					s.timestampMs = int64(num)
+				}
+			}
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func (s series) label(name string) string {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// MockReceiver stands in for Prometheus or VictoriaMetrics, decoding everything written to it.
+type MockReceiver struct {
+	series  []series
+	headers []http.Header
+	status  int
+	mu      sync.Mutex
+}
+
+func (m *MockReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.headers = append(m.headers, r.Header)
+	if m.status != 0 {
+		w.WriteHeader(m.status)
+		return
+	}
+	compressed, _ := io.ReadAll(r.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	decoded, err := decodeWriteRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.series = append(m.series, decoded...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *MockReceiver) Series(name string) []series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matching []series
+	for _, s := range m.series {
+		if s.label("__name__") == name {
+			matching = append(matching, s)
+		}
+	}
+	return matching
+}
+
+func InitMockRemoteWrite(t *testing.T, username, password, bearerToken string) (*RemoteWrite, *MockReceiver) {
+	receiver := &MockReceiver{}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	r := &RemoteWrite{}
+	r.Init(server.URL+"/api/v1/write", username, password, bearerToken)
+	return r, receiver
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	s := newSeries("agpd_product_cents", map[string]string{"store": "Coles", "id": "coles_id_1", "empty": ""}, 450, 1700000000000)
+	if want, got := 3, len(s.labels); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	for i := 1; i < len(s.labels); i++ {
+		if s.labels[i-1].name > s.labels[i].name {
+			t.Errorf("Labels aren't sorted: %v", s.labels)
+		}
+	}
+	decoded, err := decodeWriteRequest(encodeWriteRequest([]series{s, s}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(decoded); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "coles_id_1", decoded[1].label("id"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 450.0, decoded[1].value; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := int64(1700000000000), decoded[1].timestampMs; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestWriteProductDatapoint(t *testing.T) {
+	r, receiver := InitMockRemoteWrite(t, "", "", "token")
+	now := time.Now()
+	err := r.WriteProductDatapoints([]shared.ProductInfo{{
+		ID:                 "woolworths_sku_123",
+		Name:               "Milk 2L",
+		Store:              "Woolworths",
+		PriceCents:         300,
+		PreviousPriceCents: 400,
+		WeightGrams:        2000,
+		Promotion:          shared.Promotion{Type: shared.PROMOTION_TYPE_SPECIAL, WasPriceCents: 400},
+		Timestamp:          now,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.WriteArbitrarySystemDatapoint(shared.SYSTEM_VERSION_FIELD, "1.2.3")
+	r.Close()
+
+	cents := receiver.Series(METRIC_PREFIX + "product_cents")
+	if want, got := 1, len(cents); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300.0, cents[0].value; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := now.UnixMilli(), cents[0].timestampMs; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := shared.PROMOTION_TYPE_SPECIAL, cents[0].label("promotion_type"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := -100.0, receiver.Series(METRIC_PREFIX + "product_cents_change")[0].value; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := 400.0, receiver.Series(METRIC_PREFIX + "product_was_cents")[0].value; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := 0, len(receiver.Series(METRIC_PREFIX+"product_unit_cents")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "1.2.3", receiver.Series(METRIC_PREFIX + "version_info")[0].label("version"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	headers := receiver.headers[0]
+	if want, got := "snappy", headers.Get("Content-Encoding"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Bearer token", headers.Get("Authorization"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestBatching(t *testing.T) {
+	r, receiver := InitMockRemoteWrite(t, "user", "pass", "")
+	for i := 0; i < REMOTE_WRITE_BATCH_SIZE; i++ {
+		r.WritePriceCycleDatapoint(shared.PriceCycle{ProductID: "coles_id_1", PeriodDays: 14, Detected: time.Now()})
+	}
+	r.Close()
+
+	if want, got := REMOTE_WRITE_BATCH_SIZE, len(receiver.Series(METRIC_PREFIX+"price_cycle_period_days")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if len(receiver.headers) < 5 {
+		t.Errorf("Expected at least 5 requests, got %d", len(receiver.headers))
+	}
+	if _, _, ok := (&http.Request{Header: receiver.headers[0]}).BasicAuth(); !ok {
+		t.Errorf("Expected basic auth")
+	}
+}
+
+func TestSendError(t *testing.T) {
+	r, receiver := InitMockRemoteWrite(t, "", "", "")
+	receiver.status = http.StatusBadRequest
+	defer r.Close()
+	err := r.send([]series{newSeries("agpd_test", nil, 1, 1)})
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+	// Sending the same request again won't help.
+	if !outbox.IsPermanent(err) {
+		t.Errorf("Expected a permanent error, got %v", err)
+	}
+	receiver.status = http.StatusServiceUnavailable
+	if err := r.send([]series{newSeries("agpd_test", nil, 1, 1)}); outbox.IsPermanent(err) {
+		t.Errorf("Expected a temporary error, got %v", err)
+	}
+	r.add(newSeries("agpd_test", nil, 1, 1))
+	r.flush()
+	if want, got := int64(1), r.ErrorCount(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestWriteProductDatapoints(t *testing.T) {
+	r, receiver := InitMockRemoteWrite(t, "", "", "")
+	defer r.Close()
+	infos := []shared.ProductInfo{{ID: "coles_id_1", PriceCents: 100}, {ID: "coles_id_2", PriceCents: 200}}
+	if err := r.WriteProductDatapoints(infos); err != nil {
+		t.Fatal(err)
+	}
+	// Sent straight away, without waiting for the flush loop.
+	if want, got := 2, len(receiver.Series(METRIC_PREFIX+"product_cents")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	receiver.mu.Lock()
+	receiver.status = http.StatusServiceUnavailable
+	receiver.mu.Unlock()
+	if err := r.WriteProductDatapoints(infos); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
@@ -0,0 +1,172 @@
+package timescaledb
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockStatement struct {
+	query string
+	args  []interface{}
+}
+
+// MockExecer stands in for a Postgres connection, recording every statement it's given.
+type MockExecer struct {
+	statements []MockStatement
+	failOn     string // Fail any statement containing this
+	mu         sync.Mutex
+}
+
+func (m *MockExecer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failOn != "" && strings.Contains(query, m.failOn) {
+		return nil, errors.New("function create_hypertable does not exist")
+	}
+	m.statements = append(m.statements, MockStatement{query, args})
+	return nil, nil
+}
+
+func (m *MockExecer) Statements(prefix string) []MockStatement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var matching []MockStatement
+	for _, statement := range m.statements {
+		if strings.HasPrefix(statement.query, prefix) {
+			matching = append(matching, statement)
+		}
+	}
+	return matching
+}
+
+func InitMockTimescaleDB() (*TimescaleDB, *MockExecer) {
+	exec := &MockExecer{}
+	return &TimescaleDB{exec: exec, logger: slog.Default()}, exec
+}
+
+func TestInitSchema(t *testing.T) {
+	tsDB, exec := InitMockTimescaleDB()
+	if err := tsDB.initSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 4, len(exec.Statements("CREATE TABLE")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := len(hypertables), len(exec.Statements("SELECT create_hypertable")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Plain Postgres doesn't have hypertables, but should still work.
+	tsDB, exec = InitMockTimescaleDB()
+	exec.failOn = "create_hypertable"
+	if err := tsDB.initSchema(); err != nil {
+		t.Errorf("Expected no error without the TimescaleDB extension, got %v", err)
+	}
+	if want, got := 4, len(exec.Statements("CREATE TABLE")); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	if want, got := "($1, $2, $3), ($4, $5, $6)", placeholders(2, 3); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestWriteProductDatapoint(t *testing.T) {
+	tsDB, exec := InitMockTimescaleDB()
+	err := tsDB.WriteProductDatapoints([]shared.ProductInfo{{
+		ID:                 "woolworths_sku_123",
+		Name:               "Milk 2L",
+		Store:              "Woolworths",
+		PriceCents:         300,
+		PreviousPriceCents: 400,
+		WeightGrams:        2000,
+		UnitType:           "litre",
+		UnitPriceCents:     150,
+		Promotion:          shared.Promotion{Type: shared.PROMOTION_TYPE_SPECIAL, WasPriceCents: 400},
+		Timestamp:          time.Now(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserts := exec.Statements("INSERT INTO product_prices")
+	if want, got := 1, len(inserts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	args := inserts[0].args
+	if want, got := productColumns, len(args); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "woolworths_sku_123", args[1]; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if args[6] != nil {
+		t.Errorf("Expected no canonical ID, got %v", args[6])
+	}
+	if want, got := -100, args[11]; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := 400, args[13]; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := false, args[16]; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// Unchanged prices have no change, and no promotion means no member only flag.
+	if err := tsDB.WriteProductDatapoints([]shared.ProductInfo{{ID: "woolworths_sku_124", PriceCents: 300, PreviousPriceCents: 300}}); err != nil {
+		t.Fatal(err)
+	}
+	args = exec.Statements("INSERT INTO product_prices")[1].args
+	if args[11] != nil || args[16] != nil {
+		t.Errorf("Expected NULL change and member only, got %v and %v", args[11], args[16])
+	}
+}
+
+func TestWriteArbitrarySystemDatapoint(t *testing.T) {
+	tsDB, exec := InitMockTimescaleDB()
+	tsDB.WriteArbitrarySystemDatapoint(shared.SYSTEM_VERSION_FIELD, "1.2.3")
+	tsDB.WriteArbitrarySystemDatapoint("products_per_second", 12)
+	inserts := exec.Statements("INSERT INTO system_metrics")
+	if want, got := 2, len(inserts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if inserts[0].args[2] != nil || inserts[0].args[3] != "1.2.3" {
+		t.Errorf("Expected the version as text, got %v", inserts[0].args)
+	}
+	if inserts[1].args[2] != 12.0 || inserts[1].args[3] != nil {
+		t.Errorf("Expected the count as a number, got %v", inserts[1].args)
+	}
+}
+
+func TestWriteProductDatapoints(t *testing.T) {
+	tsDB, exec := InitMockTimescaleDB()
+	infos := make([]shared.ProductInfo, TIMESCALEDB_BATCH_SIZE+1)
+	if err := tsDB.WriteProductDatapoints(infos); err != nil {
+		t.Fatal(err)
+	}
+	inserts := exec.Statements("INSERT INTO product_prices")
+	if want, got := 2, len(inserts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	// Replayed products mustn't be duplicated.
+	if !strings.HasSuffix(inserts[0].query, "ON CONFLICT (id, time) DO NOTHING") {
+		t.Errorf("Expected product writes to be idempotent, got %s", inserts[0].query)
+	}
+
+	exec.failOn = "INSERT INTO product_prices"
+	if err := tsDB.WriteProductDatapoints(infos[:1]); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if want, got := int64(1), tsDB.ErrorCount(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,270 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The prefix on every metric name. It matches shared.SYSTEM_SERVICE_NAME, which can't be imported here as
+// the shared HTTP client records metrics.
+const METRIC_PREFIX = "agpd_"
+
+// Metrics recorded by the scrapers and exported by the API on /metrics.
+var (
+	HTTPRequests      = NewCounter("http_requests_total", "Requests made to a store's website, by response status.", "store", "status")
+	PagesScraped      = NewCounter("pages_scraped_total", "Product list pages scraped and saved to the local DB.", "store")
+	ProductsSaved     = NewCounter("products_saved_total", "Products saved to the local DB.", "store")
+	ZeroPriceSkips    = NewCounter("zero_price_skips_total", "Products skipped because they had a price of zero.", "store")
+	ScrapeTrapHits    = NewCounter("scrape_trap_hits_total", "Responses that were a scrape trap rather than the page asked for.", "store")
+	SinkWriteErrors   = NewCounterFunc("sink_write_errors_total", "Failed writes to a timeseries database.", "sink")
+	OutboxDeadLetters = NewCounter("outbox_dead_letters_total", "Products a timeseries database permanently rejected, set aside rather than retried.", "sink")
+
+	TotalProducts         = NewGauge("products", "Products in the local DBs.", "store")
+	ProductsPerSecond     = NewGauge("products_per_second", "Products queued for the timeseries databases per second.")
+	RAMUtilisationPercent = NewGauge("ram_utilisation_percent", "Percentage of system RAM in use.")
+	HDDBytesFree          = NewGauge("hdd_bytes_free", "Bytes free on the disk holding the local DBs.")
+	SinkQueued            = NewGauge("sink_queued", "Products waiting in the outbox to be delivered to a timeseries database.", "sink")
+	SinkLagSeconds        = NewGauge("sink_lag_seconds", "How long the oldest product waiting for a timeseries database has been queued.", "sink")
+)
+
+// metric is anything that can be written out in the Prometheus text format.
+type metric interface {
+	write(w io.Writer) error
+}
+
+// registry holds every metric, in the order they were created.
+var registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func register(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func WriteTo(w io.Writer) error {
+	registry.mu.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	registry.mu.Unlock()
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves every metric in the Prometheus text exposition format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+// vec holds a value for each combination of label values.
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	mu         sync.Mutex
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+func newVec(name, help, kind string, labelNames []string) *vec {
+	return &vec{
+		name:       METRIC_PREFIX + name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]float64),
+		labels:     make(map[string][]string),
+	}
+}
+
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := v.labels[key]; !ok {
+		v.labels[key] = append([]string(nil), labelValues...)
+	}
+	return key
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[v.key(labelValues)] += delta
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[v.key(labelValues)] = value
+}
+
+func (v *vec) get(labelValues []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[strings.Join(labelValues, "\xff")]
+}
+
+func (v *vec) write(w io.Writer) error {
+	v.mu.Lock()
+	samples := make([]sample, 0, len(v.values))
+	for key, value := range v.values {
+		samples = append(samples, sample{v.labels[key], value})
+	}
+	v.mu.Unlock()
+	return writeFamily(w, v.name, v.help, v.kind, v.labelNames, samples)
+}
+
+// Counter is a count that only goes up, E.G. pages scraped, optionally split up by labels.
+type Counter struct {
+	v *vec
+}
+
+// NewCounter creates and registers a counter. The name is given METRIC_PREFIX.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labelNames)}
+	register(c.v)
+	return c
+}
+
+// Inc adds one to the count for the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+// Add adds n to the count for the given label values.
+func (c *Counter) Add(n int, labelValues ...string) {
+	if n < 0 {
+		panic("counters can't go down")
+	}
+	c.v.add(float64(n), labelValues)
+}
+
+// Value returns the count for the given label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.v.get(labelValues)
+}
+
+// Gauge is a value that can go up and down, E.G. free disk space.
+type Gauge struct {
+	v *vec
+}
+
+// NewGauge creates and registers a gauge. The name is given METRIC_PREFIX.
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labelNames)}
+	register(g.v)
+	return g
+}
+
+// Set sets the value for the given label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.v.set(value, labelValues)
+}
+
+// Value returns the value for the given label values.
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.v.get(labelValues)
+}
+
+// CounterFunc is a counter kept by something else, E.G. a timeseries database counting its own failed
+// writes. It's read whenever the metrics are written. It has a single label.
+type CounterFunc struct {
+	name      string
+	help      string
+	labelName string
+	mu        sync.Mutex
+	funcs     map[string]func() int64
+}
+
+// NewCounterFunc creates and registers a counter func. The name is given METRIC_PREFIX.
+func NewCounterFunc(name, help, labelName string) *CounterFunc {
+	c := &CounterFunc{
+		name:      METRIC_PREFIX + name,
+		help:      help,
+		labelName: labelName,
+		funcs:     make(map[string]func() int64),
+	}
+	register(c)
+	return c
+}
+
+// Watch reads the count for the given label value from f.
+func (c *CounterFunc) Watch(labelValue string, f func() int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.funcs[labelValue] = f
+}
+
+func (c *CounterFunc) write(w io.Writer) error {
+	c.mu.Lock()
+	samples := make([]sample, 0, len(c.funcs))
+	for labelValue, f := range c.funcs {
+		samples = append(samples, sample{[]string{labelValue}, float64(f())})
+	}
+	c.mu.Unlock()
+	return writeFamily(w, c.name, c.help, "counter", []string{c.labelName}, samples)
+}
+
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// writeFamily writes a metric's HELP and TYPE lines followed by its samples, sorted by label values.
+func writeFamily(w io.Writer, name, help, kind string, labelNames []string, samples []sample) error {
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].labelValues, "\xff") < strings.Join(samples[j].labelValues, "\xff")
+	})
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, kind)
+	for _, s := range samples {
+		b.WriteString(name)
+		if len(labelNames) > 0 {
+			b.WriteByte('{')
+			for i, labelName := range labelNames {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(&b, "%s=\"%s\"", labelName, labelValueEscaper.Replace(s.labelValues[i]))
+			}
+			b.WriteByte('}')
+		}
+		fmt.Fprintf(&b, " %s\n", formatValue(s.value))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// The text format escapes backslashes and newlines in HELP text, and double quotes as well in label values.
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	counter := NewCounter("test_pages_total", "Pages.", "store")
+	counter.Inc("Woolworths")
+	counter.Add(2, "Woolworths")
+	counter.Inc(`Co"les`)
+	gauge := NewGauge("test_ram_percent", "RAM.")
+	gauge.Set(12.5)
+	errorCount := int64(3)
+	counterFunc := NewCounterFunc("test_errors_total", "Errors.", "sink")
+	counterFunc.Watch("influxdb", func() int64 { return errorCount })
+
+	if want, got := 3.0, counter.Value("Woolworths"); want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	var b bytes.Buffer
+	if err := WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# HELP agpd_test_pages_total Pages.\n# TYPE agpd_test_pages_total counter\n" +
+			"agpd_test_pages_total{store=\"Co\\\"les\"} 1\nagpd_test_pages_total{store=\"Woolworths\"} 3\n",
+		"# TYPE agpd_test_ram_percent gauge\nagpd_test_ram_percent 12.5\n",
+		"# TYPE agpd_test_errors_total counter\nagpd_test_errors_total{sink=\"influxdb\"} 3\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", want, b.String())
+		}
+	}
+
+	errorCount = 4
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "agpd_test_errors_total{sink=\"influxdb\"} 4\n") {
+		t.Errorf("Expected the counter func to be read again, got:\n%s", rec.Body.String())
+	}
+	if want, got := "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"); want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	counter := NewCounter("test_escaping_total", "Back\\slash and\nnewline, \"quotes\" kept.", "path")
+	counter.Inc(`C:\groceries`)
+	counter.Inc(`say "cheese"`)
+	counter.Inc("two\nlines")
+
+	var b bytes.Buffer
+	if err := WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# HELP agpd_test_escaping_total Back\\\\slash and\\nnewline, \"quotes\" kept.\n",
+		"# TYPE agpd_test_escaping_total counter\n",
+		`agpd_test_escaping_total{path="C:\\groceries"} 1` + "\n",
+		`agpd_test_escaping_total{path="say \"cheese\""} 1` + "\n",
+		`agpd_test_escaping_total{path="two\nlines"} 1` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", want, b.String())
+		}
+	}
+	// Every line is a comment or a sample, so nothing leaked onto a line of its own.
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+		if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") && !strings.HasPrefix(line, METRIC_PREFIX) {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	for _, test := range []struct {
+		value float64
+		want  string
+	}{
+		{3, "3"},
+		{12.5, "12.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	} {
+		if want, got := test.want, formatValue(test.value); want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
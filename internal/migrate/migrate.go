@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Migration brings a DB's schema up to Version from the version before it. It's applied in a
+// transaction along with the new version number, so it either happens completely or not at all.
+type Migration struct {
+	Version     int
+	Description string                 // E.G. "add a change sequence to products"
+	SQL         string                 // Statements to run, if any
+	Func        func(tx *sql.Tx) error // Run after SQL, if set, for changes that aren't easily done in SQL
+}
+
+// Schema describes how to create a DB and bring it up to date.
+type Schema struct {
+	Version         int                    // The current version, which the last migration must bring the DB to
+	BaselineVersion int                    // The version Baseline creates. Older DBs can't be migrated.
+	Baseline        func(tx *sql.Tx) error // Creates a blank DB
+	Migrations      []Migration            // From BaselineVersion+1 up to Version, in order
+}
+
+// Plan is what Open would do to a DB.
+type Plan struct {
+	From     int  // The DB's current version, or 0 if it's blank
+	To       int  // The version it would be brought up to
+	Recreate bool // The DB is older than the baseline, so it would be backed up and recreated blank
+	Pending  []Migration
+}
+
+// UpToDate returns whether the DB is already at the current version.
+func (p Plan) UpToDate() bool {
+	return p.From == p.To
+}
+
+func (s Schema) validate() error {
+	if s.Baseline == nil {
+		return errors.New("schema has no baseline")
+	}
+	version := s.BaselineVersion
+	for _, m := range s.Migrations {
+		if m.Version != version+1 {
+			return fmt.Errorf("migration to version %d follows version %d", m.Version, version)
+		}
+		version = m.Version
+	}
+	if version != s.Version {
+		return fmt.Errorf("migrations end at version %d, expected %d", version, s.Version)
+	}
+	return nil
+}
+
+// plan works out what needs doing to a DB at the given version.
+func (s Schema) plan(version int) (Plan, error) {
+	if err := s.validate(); err != nil {
+		return Plan{}, err
+	}
+	if version > s.Version {
+		return Plan{}, fmt.Errorf("DB schema version %d is newer than this build's %d", version, s.Version)
+	}
+	plan := Plan{From: version, To: s.Version}
+	if version > 0 && version < s.BaselineVersion {
+		plan.Recreate = true
+	}
+	for _, m := range s.Migrations {
+		if plan.Recreate || m.Version > version {
+			plan.Pending = append(plan.Pending, m)
+		}
+	}
+	return plan, nil
+}
+
+// readVersion returns the schema version of a DB, or 0 if it doesn't have one.
+func readVersion(db *sql.DB) int {
+	var version int
+	if err := db.QueryRow("SELECT version FROM schema").Scan(&version); err != nil {
+		return 0
+	}
+	return version
+}
+
+func setVersion(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM schema"); err != nil {
+		return err
+	}
+	// Not a placeholder, as they differ between SQLite and Postgres.
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO schema (version) VALUES (%d)", version))
+	return err
+}
+
+// backupName is where a DB at the given schema version is backed up to, in the same directory with the
+// schema number and an ISO8601 timestamp appended to the filename.
+func backupName(dbPath string, version int) string {
+	return fmt.Sprintf("%s.%d.%s", dbPath, version, time.Now().Format("2006-01-02T15:04:05"))
+}
+
+// Check returns what Open would do to the DB at dbPath, without changing anything.
+func Check(dbPath string, schema Schema) (Plan, error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return schema.plan(0)
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to open DB: %w", err)
+	}
+	defer db.Close()
+	return schema.plan(readVersion(db))
+}
+
+// CheckDB returns what Migrate would do to an open DB, without changing anything.
+func CheckDB(db *sql.DB, schema Schema) (Plan, error) {
+	return schema.plan(readVersion(db))
+}
+
+// Migrate brings an open DB that isn't a local file, E.G. Postgres, up to the current schema. It can't be
+// backed up first, so that's up to whoever runs it. One that's too old to migrate is left alone.
+func Migrate(db *sql.DB, schema Schema, logger *slog.Logger) error {
+	plan, err := CheckDB(db, schema)
+	if err != nil {
+		return err
+	}
+	if plan.Recreate {
+		return fmt.Errorf("DB schema version %d is too old to migrate, the oldest supported is %d", plan.From, schema.BaselineVersion)
+	}
+	if plan.UpToDate() {
+		return nil
+	}
+	logger.Warn("DB schema mismatch", "currentVersion", plan.To, "detectedVersion", plan.From)
+	return apply(db, plan, schema, logger)
+}
+
+// Open opens the DB at dbPath with open, and brings it up to the current schema. A DB that needs migrating is
+// copied to a backup first. One that's too old to migrate is moved to a backup and replaced with a blank DB.
+func Open(dbPath string, open func(dbPath string) (*sql.DB, error), schema Schema, logger *slog.Logger) (*sql.DB, error) {
+	db, err := open(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DB: %w", err)
+	}
+	plan, err := schema.plan(readVersion(db))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if plan.UpToDate() {
+		return db, nil
+	}
+	logger.Warn("DB schema mismatch", "path", dbPath, "currentVersion", plan.To, "detectedVersion", plan.From)
+
+	if plan.Recreate {
+		if err := db.Close(); err != nil {
+			return nil, fmt.Errorf("failed to close existing DB before backing it up: %w", err)
+		}
+		backup := backupName(dbPath, plan.From)
+		if err := os.Rename(dbPath, backup); err != nil {
+			return nil, fmt.Errorf("failed to backup existing DB: %w", err)
+		}
+		logger.Info("Backed up old DB", "old", dbPath, "new", backup)
+		if db, err = open(dbPath); err != nil {
+			return nil, fmt.Errorf("failed to open DB: %w", err)
+		}
+	} else if plan.From != 0 {
+		backup := backupName(dbPath, plan.From)
+		if _, err := db.Exec("VACUUM INTO ?", backup); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to backup existing DB: %w", err)
+		}
+		logger.Info("Backed up DB before migrating", "path", dbPath, "backup", backup)
+	}
+
+	if err := apply(db, plan, schema, logger.With("path", dbPath)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// apply creates a blank DB if need be, then applies the plan's pending migrations.
+func apply(db *sql.DB, plan Plan, schema Schema, logger *slog.Logger) error {
+	if plan.From == 0 || plan.Recreate {
+		if err := inTransaction(db, schema.BaselineVersion, schema.Baseline); err != nil {
+			return fmt.Errorf("failed to create blank DB: %w", err)
+		}
+		logger.Info("New blank DB created", "version", schema.BaselineVersion)
+	}
+	for _, m := range plan.Pending {
+		err := inTransaction(db, m.Version, func(tx *sql.Tx) error {
+			if m.SQL != "" {
+				if _, err := tx.Exec(m.SQL); err != nil {
+					return err
+				}
+			}
+			if m.Func != nil {
+				return m.Func(tx)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate DB to version %d: %w", m.Version, err)
+		}
+		logger.Info("Migrated DB", "version", m.Version, "description", m.Description)
+	}
+	return nil
+}
+
+// inTransaction runs f and sets the schema version in a single transaction.
+func inTransaction(db *sql.DB, version int, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := f(tx); err != nil {
+		return err
+	}
+	if err := setVersion(tx, version); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,239 @@
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+func openDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func testSchema() Schema {
+	return Schema{
+		Version:         4,
+		BaselineVersion: 2,
+		Baseline: func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE products (productID TEXT UNIQUE, priceCents INTEGER)")
+			return err
+		},
+		Migrations: []Migration{
+			{Version: 3, Description: "add previous prices", SQL: "ALTER TABLE products ADD COLUMN previousPriceCents INTEGER DEFAULT 0"},
+			{Version: 4, Description: "keep the previous price", Func: func(tx *sql.Tx) error {
+				_, err := tx.Exec("UPDATE products SET previousPriceCents = priceCents")
+				return err
+			}},
+		},
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db3")
+
+	plan, err := Check(dbPath, testSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, plan.From; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, len(plan.Pending); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// Create a DB at version 3 with a product in it.
+	schema := testSchema()
+	schema.Version = 3
+	schema.Migrations = schema.Migrations[:1]
+	db, err := Open(dbPath, openDB, schema, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO products (productID, priceCents) VALUES ('123', 450)"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	plan, err = Check(dbPath, testSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, plan.From; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, len(plan.Pending); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 4, plan.Pending[0].Version; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	db, err = Open(dbPath, openDB, testSchema(), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var previousPriceCents int
+	if err := db.QueryRow("SELECT previousPriceCents FROM products WHERE productID = '123'").Scan(&previousPriceCents); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 450, previousPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 4, readVersion(db); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// The DB was copied before it was migrated.
+	matches, err := filepath.Glob(dbPath + ".3.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(matches); want != got {
+		t.Fatalf("Expected %d backups, got %d", want, got)
+	}
+	backup, err := openDB(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	if want, got := 3, readVersion(backup); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestOpenFailedMigration(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db3")
+	schema := testSchema()
+	schema.Version = 3
+	schema.Migrations = schema.Migrations[:1]
+	db, err := Open(dbPath, openDB, schema, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	schema = testSchema()
+	schema.Migrations[1].Func = func(tx *sql.Tx) error {
+		if _, err := tx.Exec("UPDATE products SET previousPriceCents = 1"); err != nil {
+			return err
+		}
+		return errors.New("disk full")
+	}
+	if _, err := Open(dbPath, openDB, schema, slog.Default()); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// The failed migration was rolled back.
+	plan, err := Check(dbPath, testSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, plan.From; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestOpenTooOld(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db3")
+	db, err := Open(dbPath, openDB, testSchema(), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE schema SET version = 1")
+	db.Close()
+
+	plan, err := Check(dbPath, testSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Recreate {
+		t.Error("Expected a DB older than the baseline to be recreated")
+	}
+
+	db, err = Open(dbPath, openDB, testSchema(), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if want, got := 4, readVersion(db); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	matches, err := filepath.Glob(dbPath + ".1.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(matches); want != got {
+		t.Fatalf("Expected %d backups, got %d", want, got)
+	}
+}
+
+func TestOpenTooNew(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db3")
+	db, err := Open(dbPath, openDB, testSchema(), slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE schema SET version = 5")
+	db.Close()
+
+	if _, err := Open(dbPath, openDB, testSchema(), slog.Default()); err == nil {
+		t.Fatal("Expected an error opening a DB from a newer build")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := testSchema()
+	schema.Migrations[1].Version = 5
+	if err := schema.validate(); err == nil {
+		t.Error("Expected an error for a gap in the migrations")
+	}
+	schema = testSchema()
+	schema.Version = 5
+	if err := schema.validate(); err == nil {
+		t.Error("Expected an error for migrations that don't reach the current version")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := openDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := Migrate(db, testSchema(), slog.Default()); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := CheckDB(db, testSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.UpToDate() {
+		t.Errorf("Expected the DB to be up to date, it's at %d", plan.From)
+	}
+	if _, err := db.Exec("INSERT INTO products (productID, priceCents, previousPriceCents) VALUES ('123', 450, 400)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// There's no file to back up, so a DB that's too old is left alone rather than recreated.
+	db.Exec("UPDATE schema SET version = 1")
+	if err := Migrate(db, testSchema(), slog.Default()); err == nil {
+		t.Fatal("Expected an error")
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM products").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, count; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const EVENT_TYPE_PRICE_CHANGE = "price_change"
+const EVENT_TYPE_WATCHLIST_ALERT = "watchlist_alert"
+
+// How many events can be waiting for delivery before new ones are dropped.
+const NOTIFY_QUEUE_SIZE = 1000
+
+// Event is something worth telling a user about, E.G. a product's price changing.
+type Event struct {
+	Type               string    `json:"type"`
+	Title              string    `json:"title"`
+	Message            string    `json:"message"`
+	User               string    `json:"user,omitempty"` // Set when the event is for one user, E.G. a watchlist alert
+	ProductID          string    `json:"product_id"`
+	CanonicalID        string    `json:"canonical_id,omitempty"`
+	Name               string    `json:"name"`
+	Store              string    `json:"store"`
+	Department         string    `json:"department"`
+	PreviousPriceCents int       `json:"previous_price_cents"`
+	PriceCents         int       `json:"price_cents"`
+	ChangeCents        int       `json:"change_cents"`
+	ChangePercent      float64   `json:"change_percent"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+// Sink delivers events somewhere, E.G. a webhook or an inbox.
+type Sink interface {
+	Name() string
+	Send(event Event) error
+}
+
+// Filter decides which events are worth delivering. Empty lists match everything.
+type Filter struct {
+	Watchlist        []string // Product IDs or canonical IDs
+	Stores           []string
+	Departments      []string
+	MinChangePercent float64
+}
+
+// Match returns true if the event passes every part of the filter.
+func (f Filter) Match(event Event) bool {
+	if len(f.Watchlist) > 0 && !slices.Contains(f.Watchlist, event.ProductID) &&
+		(event.CanonicalID == "" || !slices.Contains(f.Watchlist, event.CanonicalID)) {
+		return false
+	}
+	if len(f.Stores) > 0 && !containsFold(f.Stores, event.Store) {
+		return false
+	}
+	if len(f.Departments) > 0 && !containsFold(f.Departments, event.Department) {
+		return false
+	}
+	return math.Abs(event.ChangePercent) >= f.MinChangePercent
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCents formats a price in cents as dollars, E.G. $4.50.
+func formatCents(cents int) string {
+	return fmt.Sprintf("$%.2f", float64(cents)/100)
+}
+
+// PriceChangeEvent builds an event from a product update, returning false if the price hasn't changed.
+func PriceChangeEvent(info shared.ProductInfo) (Event, bool) {
+	if info.PriceCents == 0 || info.PreviousPriceCents == 0 || info.PriceCents == info.PreviousPriceCents {
+		return Event{}, false
+	}
+	change := info.PriceCents - info.PreviousPriceCents
+	percent := float64(change) * 100 / float64(info.PreviousPriceCents)
+	direction := "cheaper"
+	if change > 0 {
+		direction = "dearer"
+	}
+	return Event{
+		Type:               EVENT_TYPE_PRICE_CHANGE,
+		Title:              fmt.Sprintf("%s is %.0f%% %s at %s", info.Name, math.Abs(percent), direction, info.Store),
+		Message:            fmt.Sprintf("%s was %s, now %s", info.Name, formatCents(info.PreviousPriceCents), formatCents(info.PriceCents)),
+		ProductID:          info.ID,
+		CanonicalID:        info.CanonicalID,
+		Name:               info.Name,
+		Store:              info.Store,
+		Department:         info.Department,
+		PreviousPriceCents: info.PreviousPriceCents,
+		PriceCents:         info.PriceCents,
+		ChangeCents:        change,
+		ChangePercent:      percent,
+		Timestamp:          info.Timestamp,
+	}, true
+}
+
+// Notifier turns product updates into events and delivers the interesting ones to its sinks in the background.
+type Notifier struct {
+	sinks  []Sink
+	filter Filter
+	queue  chan Event
+	logger *slog.Logger
+}
+
+// Init sets up the notifier. Call Run to start delivering events.
+func (n *Notifier) Init(sinks []Sink, filter Filter) {
+	n.logger = slog.With("component", "notify")
+	n.sinks = sinks
+	n.filter = filter
+	n.queue = make(chan Event, NOTIFY_QUEUE_SIZE)
+	for _, sink := range sinks {
+		n.logger.Info("Notification sink enabled", "sink", sink.Name())
+	}
+}
+
+// Notify queues an event for the product if its price has changed and the change passes the filter.
+// It never blocks; events are dropped if the queue is full.
+func (n *Notifier) Notify(info shared.ProductInfo) {
+	if len(n.sinks) == 0 {
+		return
+	}
+	event, changed := PriceChangeEvent(info)
+	if !changed || !n.filter.Match(event) {
+		return
+	}
+	n.Send(event)
+}
+
+// Send queues an event for delivery to every sink, bypassing the filter.
+func (n *Notifier) Send(event Event) {
+	if len(n.sinks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		n.logger.Warn("Notification queue full, dropping event", "product", event.ProductID)
+	}
+}
+
+// Run delivers queued events until cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.queue:
+			n.deliver(event)
+		}
+	}
+}
+
+// deliver sends an event to every sink, logging any failures.
+func (n *Notifier) deliver(event Event) {
+	for _, sink := range n.sinks {
+		if err := sink.Send(event); err != nil {
+			n.logger.Error("Failed to send notification", "sink", sink.Name(), "product", event.ProductID, "error", err)
+		}
+	}
+}
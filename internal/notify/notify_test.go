@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockSink struct {
+	events chan Event
+}
+
+func (m *MockSink) Name() string {
+	return "mock"
+}
+
+func (m *MockSink) Send(event Event) error {
+	m.events <- event
+	return nil
+}
+
+func TestPriceChangeEvent(t *testing.T) {
+	info := shared.ProductInfo{ID: "woolworths_sku_1", Name: "Milk", Store: "Woolworths", PreviousPriceCents: 400, PriceCents: 300}
+	event, changed := PriceChangeEvent(info)
+	if !changed {
+		t.Fatal("Expected a price change")
+	}
+	if want, got := -100, event.ChangeCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := -25.0, event.ChangePercent; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := "Milk is 25% cheaper at Woolworths", event.Title; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Milk was $4.00, now $3.00", event.Message; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	for _, info := range []shared.ProductInfo{
+		{PreviousPriceCents: 0, PriceCents: 300},
+		{PreviousPriceCents: 300, PriceCents: 300},
+		{PreviousPriceCents: 300, PriceCents: 0},
+	} {
+		if _, changed := PriceChangeEvent(info); changed {
+			t.Errorf("Expected no price change for %d -> %d", info.PreviousPriceCents, info.PriceCents)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	event := Event{ProductID: "woolworths_sku_1", CanonicalID: "canonical_1", Store: "Woolworths", Department: "Dairy", ChangePercent: -12}
+	for _, test := range []struct {
+		filter Filter
+		want   bool
+	}{
+		{Filter{}, true},
+		{Filter{MinChangePercent: 10}, true},
+		{Filter{MinChangePercent: 15}, false},
+		{Filter{Watchlist: []string{"canonical_1"}}, true},
+		{Filter{Watchlist: []string{"woolworths_sku_1"}}, true},
+		{Filter{Watchlist: []string{"coles_id_1"}}, false},
+		{Filter{Stores: []string{"woolworths"}}, true},
+		{Filter{Stores: []string{"Coles"}}, false},
+		{Filter{Departments: []string{"Dairy", "Bakery"}}, true},
+		{Filter{Departments: []string{"Bakery"}}, false},
+	} {
+		if want, got := test.want, test.filter.Match(event); want != got {
+			t.Errorf("Expected %t, got %t for %+v", want, got, test.filter)
+		}
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	sink := &MockSink{events: make(chan Event, 10)}
+	n := Notifier{}
+	n.Init([]Sink{sink}, Filter{MinChangePercent: 10})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	n.Notify(shared.ProductInfo{ID: "1", PreviousPriceCents: 1000, PriceCents: 950})
+	n.Notify(shared.ProductInfo{ID: "2", PreviousPriceCents: 1000, PriceCents: 1200})
+
+	select {
+	case event := <-sink.events:
+		if want, got := "2", event.ProductID; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	select {
+	case event := <-sink.events:
+		t.Errorf("Unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const NOTIFY_HTTP_TIMEOUT = 10 * time.Second
+
+// The header carrying the hex HMAC-SHA256 of a webhook body, in the same format as GitHub's.
+const WEBHOOK_SIGNATURE_HEADER = "X-Signature-256"
+
+// postAndCheck sends a request and returns an error unless the response is a 2xx.
+func postAndCheck(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookSink posts events as JSON to a URL. If a secret is set, the body is signed with HMAC-SHA256 so the
+// receiver can check it came from us.
+type WebhookSink struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+// NewWebhookSink creates a WebhookSink.
+func NewWebhookSink(url, secret string) *WebhookSink {
+	return &WebhookSink{URL: url, Secret: secret, client: &http.Client{Timeout: NOTIFY_HTTP_TIMEOUT}}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Sign returns the signature header value for the given body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *WebhookSink) Send(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	if s.Secret != "" {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, Sign(s.Secret, body))
+	}
+	return postAndCheck(s.client, req)
+}
+
+// NtfySink pushes events to an ntfy-style topic URL, E.G. https://ntfy.sh/my-groceries.
+type NtfySink struct {
+	URL    string
+	client *http.Client
+}
+
+// NewNtfySink creates a NtfySink.
+func NewNtfySink(url string) *NtfySink {
+	return &NtfySink{URL: url, client: &http.Client{Timeout: NOTIFY_HTTP_TIMEOUT}}
+}
+
+func (s *NtfySink) Name() string {
+	return "ntfy"
+}
+
+func (s *NtfySink) Send(event Event) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, strings.NewReader(event.Message))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Title", event.Title)
+	if event.ChangeCents < 0 {
+		req.Header.Set("Tags", "chart_with_downwards_trend")
+	} else if event.ChangeCents > 0 {
+		req.Header.Set("Tags", "chart_with_upwards_trend")
+	}
+	return postAndCheck(s.client, req)
+}
+
+// SMTPSink emails events.
+type SMTPSink struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTPSink creates an SMTPSink. Authentication is only used if a username is given.
+func NewSMTPSink(addr, username, password, from string, to []string) *SMTPSink {
+	return &SMTPSink{Addr: addr, Username: username, Password: password, From: from, To: to, sendMail: smtp.SendMail}
+}
+
+func (s *SMTPSink) Name() string {
+	return "smtp"
+}
+
+func (s *SMTPSink) Send(event Event) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to parse SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	// Strip newlines from the subject so an odd product name can't inject headers.
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(event.Title)
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		s.From, strings.Join(s.To, ", "), subject, event.Message)
+	if err := s.sendMail(s.Addr, auth, s.From, s.To, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+func TestWebhookSink(t *testing.T) {
+	var gotSignature string
+	var gotEvent Event
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(WEBHOOK_SIGNATURE_HEADER)
+		gotBody, _ = io.ReadAll(r.Body)
+		json.Unmarshal(gotBody, &gotEvent)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, "hunter2")
+	if err := sink.Send(Event{Type: EVENT_TYPE_PRICE_CHANGE, ProductID: "woolworths_sku_1", PriceCents: 300}); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "woolworths_sku_1", gotEvent.ProductID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := Sign("hunter2", gotBody), gotSignature; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if !strings.HasPrefix(gotSignature, "sha256=") {
+		t.Errorf("Expected a sha256 signature, got %s", gotSignature)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := NewWebhookSink(failing.URL, "").Send(Event{}); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestNtfySink(t *testing.T) {
+	var gotTitle, gotTags, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTitle = r.Header.Get("Title")
+		gotTags = r.Header.Get("Tags")
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer server.Close()
+
+	sink := NewNtfySink(server.URL)
+	if err := sink.Send(Event{Title: "Milk is 25% cheaper at Woolworths", Message: "Milk was $4.00, now $3.00", ChangeCents: -100}); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Milk is 25% cheaper at Woolworths", gotTitle; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "chart_with_downwards_trend", gotTags; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Milk was $4.00, now $3.00", gotBody; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+	var gotAddr string
+	var gotAuth smtp.Auth
+	var gotTo []string
+	var gotMsg string
+	sink := NewSMTPSink("mail.example.com:587", "user", "pass", "agpd@example.com", []string{"me@example.com"})
+	sink.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		gotAddr, gotAuth, gotTo, gotMsg = addr, a, to, string(msg)
+		return nil
+	}
+	if err := sink.Send(Event{Title: "Milk\r\nBcc: evil@example.com", Message: "Milk was $4.00, now $3.00"}); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "mail.example.com:587", gotAddr; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if gotAuth == nil {
+		t.Errorf("Expected authentication")
+	}
+	if want, got := 1, len(gotTo); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if strings.Contains(gotMsg, "\r\nBcc:") {
+		t.Errorf("Subject allowed header injection: %s", gotMsg)
+	}
+	if !strings.Contains(gotMsg, "Milk was $4.00, now $3.00") {
+		t.Errorf("Expected the message in the body, got %s", gotMsg)
+	}
+}
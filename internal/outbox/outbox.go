@@ -0,0 +1,536 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+const DB_SCHEMA_VERSION = 3
+
+// The schema version initBlankDB creates. DBs older than this can't be migrated, so they're backed up
+// and recreated.
+const DB_BASELINE_SCHEMA_VERSION = 1
+
+// DB_SCHEMA brings the DB up to DB_SCHEMA_VERSION. To change the schema add a migration to the end and bump
+// DB_SCHEMA_VERSION to match.
+var DB_SCHEMA = migrate.Schema{
+	Version:         DB_SCHEMA_VERSION,
+	BaselineVersion: DB_BASELINE_SCHEMA_VERSION,
+	Baseline:        initBlankDB,
+	Migrations: []migrate.Migration{
+		// The change sequence each store's products have been queued up to.
+		{Version: 2, Description: "record how far each store has been read", SQL: `
+			CREATE TABLE sources (source TEXT PRIMARY KEY, changeSeq INTEGER);`},
+		// Products a sink permanently rejected, kept so they can be looked into.
+		{Version: 3, Description: "set aside products sinks reject", SQL: `
+			CREATE TABLE dead_letters (seq INTEGER, sink TEXT, product TEXT, error TEXT, created DATETIME);
+			CREATE INDEX dead_letters_sink ON dead_letters (sink);`},
+	},
+}
+
+// The most products delivered to a sink in one write.
+const OUTBOX_BATCH_SIZE = 500
+
+// How often each sink checks for new products when it's caught up.
+const OUTBOX_POLL_INTERVAL = 1 * time.Second
+
+// How long to wait before retrying a sink after its first failure. This doubles with each
+// consecutive failure, up to OUTBOX_MAX_BACKOFF.
+const OUTBOX_MIN_BACKOFF = 1 * time.Second
+const OUTBOX_MAX_BACKOFF = 5 * time.Minute
+
+// Sink is a timeseries database that acknowledges product writes by returning without error.
+// Products may be written more than once, so sinks must treat a product's ID and timestamp as
+// identifying its datapoint. Errors are retried until they succeed, unless they're Permanent.
+type Sink interface {
+	WriteProductDatapoints(infos []shared.ProductInfo) error
+}
+
+// permanent is implemented by errors that retrying won't fix, E.G. the sink rejecting the products themselves.
+type permanent interface {
+	Permanent() bool
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string   { return e.err.Error() }
+func (e permanentError) Unwrap() error   { return e.err }
+func (e permanentError) Permanent() bool { return true }
+
+// Permanent marks a sink's error as one retrying won't fix. The products it rejected are moved to the dead
+// letters rather than holding up the sink forever.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent returns true if the error, or any error it wraps, is permanent.
+func IsPermanent(err error) bool {
+	var p permanent
+	return errors.As(err, &p) && p.Permanent()
+}
+
+// IsPermanentStatus returns true if an HTTP status means the request itself was bad, so sending it again
+// won't help. Auth failures and the like aren't permanent, as they're fixed by fixing the config.
+func IsPermanentStatus(status int) bool {
+	return status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge ||
+		status == http.StatusUnprocessableEntity
+}
+
+// cursor tracks how far through the outbox a sink has acknowledged.
+type cursor struct {
+	name     string
+	sink     Sink
+	ackedSeq int64
+	lastErr  error // Why the last delivery failed, or nil if it worked
+	failures int64 // Deliveries that have failed
+}
+
+// setResult records how a delivery to the sink went.
+func (o *Outbox) setResult(c *cursor, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	c.lastErr = err
+	if err != nil {
+		c.failures++
+	}
+}
+
+// Outbox durably queues product updates on their way to the timeseries databases. Each sink has its
+// own cursor, so a sink that's down only holds up itself, and everything it hasn't acknowledged is
+// delivered once it's back, even across restarts.
+type Outbox struct {
+	db      *sql.DB
+	cursors []*cursor
+	mu      sync.Mutex
+	logger  *slog.Logger
+}
+
+// Init opens the outbox database. Add sinks with AddSink, then call Run to start delivering.
+func (o *Outbox) Init(dbPath string) error {
+	o.logger = slog.With("component", "outbox")
+	return o.initDB(dbPath)
+}
+
+// initBlankDB creates the schema at DB_BASELINE_SCHEMA_VERSION. Don't change it, add a migration to
+// DB_SCHEMA instead.
+func initBlankDB(tx *sql.Tx) error {
+	_, err := tx.Exec(`	CREATE TABLE IF NOT EXISTS outbox
+						(	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+							product TEXT,
+							created DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	// The last outbox entry each sink has acknowledged.
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS acks
+						(	sink TEXT PRIMARY KEY,
+							ackedSeq INTEGER
+						)`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func openDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// initDB opens the database, bringing it up to the current schema.
+func (o *Outbox) initDB(dbPath string) error {
+	var err error
+	o.db, err = migrate.Open(dbPath, openDB, DB_SCHEMA, o.logger)
+	return err
+}
+
+// AddSink registers a sink, picking up from where it last acknowledged if it's been used before.
+func (o *Outbox) AddSink(name string, sink Sink) error {
+	_, err := o.db.Exec("INSERT OR IGNORE INTO acks (sink, ackedSeq) VALUES (?, 0)", name)
+	if err != nil {
+		return fmt.Errorf("failed to register sink: %w", err)
+	}
+	c := &cursor{name: name, sink: sink}
+	if err := o.db.QueryRow("SELECT ackedSeq FROM acks WHERE sink = ?", name).Scan(&c.ackedSeq); err != nil {
+		return fmt.Errorf("failed to query sink's last acknowledgement: %w", err)
+	}
+	o.mu.Lock()
+	o.cursors = append(o.cursors, c)
+	o.mu.Unlock()
+	if pending, err := o.Pending(name); err == nil && pending > 0 {
+		o.logger.Info("Replaying unacknowledged products", "sink", name, "count", pending)
+	}
+	return nil
+}
+
+// Add durably queues products for every sink. Once it returns without error the products will be
+// delivered, eventually.
+func (o *Outbox) Add(products []shared.ProductInfo) error {
+	if len(products) == 0 {
+		return nil
+	}
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := queueProducts(tx, products); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AddFrom is Add for products read from a store by change sequence. The change sequence they were read up
+// to is recorded along with them, so reading can pick up exactly where it left off, even across restarts.
+func (o *Outbox) AddFrom(source string, products []shared.ProductInfo, changeSeq int64) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := queueProducts(tx, products); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO sources (source, changeSeq) VALUES (?, ?)
+		ON CONFLICT(source) DO UPDATE SET changeSeq = excluded.changeSeq`, source, changeSeq)
+	if err != nil {
+		return fmt.Errorf("failed to record change sequence: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ChangeSeq returns the change sequence the source's products have been queued up to, or 0 if none have.
+func (o *Outbox) ChangeSeq(source string) (int64, error) {
+	var changeSeq int64
+	err := o.db.QueryRow("SELECT changeSeq FROM sources WHERE source = ?", source).Scan(&changeSeq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query change sequence: %w", err)
+	}
+	return changeSeq, nil
+}
+
+func queueProducts(tx *sql.Tx, products []shared.ProductInfo) error {
+	now := time.Now()
+	for _, product := range products {
+		encoded, err := json.Marshal(product)
+		if err != nil {
+			return fmt.Errorf("failed to encode product: %w", err)
+		}
+		if _, err := tx.Exec("INSERT INTO outbox (product, created) VALUES (?, ?)", string(encoded), now); err != nil {
+			return fmt.Errorf("failed to queue product: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeadLetters returns how many products a sink has permanently rejected.
+func (o *Outbox) DeadLetters(name string) (int, error) {
+	var count int
+	err := o.db.QueryRow("SELECT COUNT(*) FROM dead_letters WHERE sink = ?", name).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	return count, nil
+}
+
+// Pending returns how many products a sink has yet to acknowledge.
+func (o *Outbox) Pending(name string) (int, error) {
+	var count int
+	err := o.db.QueryRow("SELECT COUNT(*) FROM outbox WHERE seq > (SELECT ackedSeq FROM acks WHERE sink = ?)", name).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pending products: %w", err)
+	}
+	return count, nil
+}
+
+// entry is a product queued in the outbox.
+type entry struct {
+	seq     int64
+	encoded string
+	product shared.ProductInfo
+}
+
+// rejection is an entry a sink permanently rejected, and why.
+type rejection struct {
+	entry
+	err error
+}
+
+// deliver sends the next batch of unacknowledged products to a sink, returning how many outbox entries
+// were acknowledged. Products the sink permanently rejects are moved to the dead letters and acknowledged.
+func (o *Outbox) deliver(c *cursor) (int, error) {
+	rows, err := o.db.Query("SELECT seq, product FROM outbox WHERE seq > ? ORDER BY seq LIMIT ?", c.ackedSeq, OUTBOX_BATCH_SIZE)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	var batch []entry
+	var lastSeq int64
+	var entries int
+	for rows.Next() {
+		entries++
+		var e entry
+		if err := rows.Scan(&e.seq, &e.encoded); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+		lastSeq = e.seq
+		if err := json.Unmarshal([]byte(e.encoded), &e.product); err != nil {
+			// A corrupt entry will never succeed, so skip it rather than blocking the sink forever.
+			o.logger.Error("Skipping undecodable outbox entry", "seq", e.seq, "error", err)
+			continue
+		}
+		batch = append(batch, e)
+	}
+	rows.Close()
+	if entries == 0 {
+		return 0, nil
+	}
+
+	var rejected []rejection
+	if len(batch) > 0 {
+		if rejected, err = o.write(c, batch); err != nil {
+			return 0, fmt.Errorf("failed to write to %s: %w", c.name, err)
+		}
+	}
+	if err := o.acknowledge(c.name, lastSeq, rejected); err != nil {
+		return 0, err
+	}
+	if len(rejected) > 0 {
+		metrics.OutboxDeadLetters.Add(len(rejected), c.name)
+		o.logger.Error("Sink permanently rejected products, moved them to the dead letters",
+			"sink", c.name, "count", len(rejected), "error", rejected[0].err)
+	}
+	o.mu.Lock()
+	c.ackedSeq = lastSeq
+	o.mu.Unlock()
+	return entries, o.prune()
+}
+
+// write sends entries to a sink. If it rejects them permanently they're split in half and sent again, to find
+// the ones it won't take, which are returned. Everything else has been written if it returns without error.
+func (o *Outbox) write(c *cursor, entries []entry) ([]rejection, error) {
+	products := make([]shared.ProductInfo, len(entries))
+	for i, e := range entries {
+		products[i] = e.product
+	}
+	err := c.sink.WriteProductDatapoints(products)
+	if err == nil || !IsPermanent(err) {
+		return nil, err
+	}
+	if len(entries) == 1 {
+		return []rejection{{entries[0], err}}, nil
+	}
+	half := len(entries) / 2
+	rejected, err := o.write(c, entries[:half])
+	if err != nil {
+		return nil, err
+	}
+	more, err := o.write(c, entries[half:])
+	if err != nil {
+		return nil, err
+	}
+	return append(rejected, more...), nil
+}
+
+// acknowledge records that a sink has taken everything up to seq, along with what it rejected.
+func (o *Outbox) acknowledge(name string, seq int64, rejected []rejection) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	for _, r := range rejected {
+		_, err := tx.Exec("INSERT INTO dead_letters (seq, sink, product, error, created) VALUES (?, ?, ?, ?, ?)",
+			r.seq, name, r.encoded, r.err.Error(), now)
+		if err != nil {
+			return fmt.Errorf("failed to record dead letter: %w", err)
+		}
+	}
+	if _, err := tx.Exec("UPDATE acks SET ackedSeq = ? WHERE sink = ?", seq, name); err != nil {
+		return fmt.Errorf("failed to record acknowledgement: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// prune deletes the entries every sink has acknowledged.
+func (o *Outbox) prune() error {
+	o.mu.Lock()
+	if len(o.cursors) == 0 {
+		o.mu.Unlock()
+		return nil
+	}
+	minSeq := o.cursors[0].ackedSeq
+	for _, c := range o.cursors[1:] {
+		minSeq = min(minSeq, c.ackedSeq)
+	}
+	o.mu.Unlock()
+	if _, err := o.db.Exec("DELETE FROM outbox WHERE seq <= ?", minSeq); err != nil {
+		return fmt.Errorf("failed to prune outbox: %w", err)
+	}
+	return nil
+}
+
+// CheckSinks returns an error for each sink whose last delivery failed, E.G. because it's unreachable.
+func (o *Outbox) CheckSinks() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var errs []error
+	for _, c := range o.cursors {
+		if c.lastErr != nil {
+			errs = append(errs, c.lastErr)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SinkStatuses reports how each sink is keeping up: what it has yet to acknowledge and how long that's been
+// waiting, what it's rejected, and why its last delivery failed, if it did.
+func (o *Outbox) SinkStatuses() []shared.SinkStatus {
+	o.mu.Lock()
+	statuses := make([]shared.SinkStatus, 0, len(o.cursors))
+	ackedSeqs := make([]int64, 0, len(o.cursors))
+	for _, c := range o.cursors {
+		status := shared.SinkStatus{Name: c.name, Errors: c.failures}
+		if c.lastErr != nil {
+			status.LastError = c.lastErr.Error()
+		}
+		statuses = append(statuses, status)
+		ackedSeqs = append(ackedSeqs, c.ackedSeq)
+	}
+	o.mu.Unlock()
+
+	now := time.Now()
+	for i := range statuses {
+		status := &statuses[i]
+		var err error
+		if status.Queued, err = o.Pending(status.Name); err != nil {
+			o.logger.Error("Failed to get sink status", "sink", status.Name, "error", err)
+		}
+		var oldest time.Time
+		err = o.db.QueryRow("SELECT created FROM outbox WHERE seq > ? ORDER BY seq LIMIT 1", ackedSeqs[i]).Scan(&oldest)
+		if err == nil {
+			status.LagSeconds = now.Sub(oldest).Seconds()
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			o.logger.Error("Failed to get sink status", "sink", status.Name, "error", err)
+		}
+		deadLetters, err := o.DeadLetters(status.Name)
+		if err != nil {
+			o.logger.Error("Failed to get sink status", "sink", status.Name, "error", err)
+		}
+		status.Dropped = int64(deadLetters)
+	}
+	return statuses
+}
+
+// Flush delivers everything queued to every sink, E.G. before exiting after a one-off scrape. Anything a
+// sink fails to acknowledge stays queued for next time.
+func (o *Outbox) Flush() error {
+	o.mu.Lock()
+	cursors := o.cursors
+	o.mu.Unlock()
+	var errs []error
+	for _, c := range cursors {
+		for {
+			delivered, err := o.deliver(c)
+			o.setResult(c, err)
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+			if delivered < OUTBOX_BATCH_SIZE {
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// nextBackoff returns how long to wait after a failure, given the previous wait.
+func nextBackoff(previous time.Duration) time.Duration {
+	if previous < OUTBOX_MIN_BACKOFF {
+		return OUTBOX_MIN_BACKOFF
+	}
+	return min(previous*2, OUTBOX_MAX_BACKOFF)
+}
+
+// Run delivers products to every sink until cancelled, retrying failures with exponential backoff. Products
+// a sink permanently rejects aren't retried.
+func (o *Outbox) Run(ctx context.Context) {
+	o.mu.Lock()
+	cursors := o.cursors
+	o.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, c := range cursors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o.deliverWorker(ctx, c)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverWorker keeps one sink up to date with the outbox.
+func (o *Outbox) deliverWorker(ctx context.Context, c *cursor) {
+	var backoff time.Duration
+	for {
+		delivered, err := o.deliver(c)
+		o.setResult(c, err)
+		wait := OUTBOX_POLL_INTERVAL
+		if err != nil {
+			backoff = nextBackoff(backoff)
+			wait = backoff
+			o.logger.Warn("Failed to deliver products, will retry", "sink", c.name, "retryIn", backoff, "error", err)
+		} else {
+			if backoff != 0 {
+				o.logger.Info("Delivering products again", "sink", c.name)
+			}
+			backoff = 0
+			if delivered == OUTBOX_BATCH_SIZE {
+				// There's probably more waiting.
+				wait = 0
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockSink struct {
+	written  []shared.ProductInfo
+	failures int    // How many more writes should fail
+	rejects  string // A product ID the sink permanently rejects, along with the rest of its batch
+	writes   int
+	mu       sync.Mutex
+}
+
+func (m *MockSink) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writes++
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+	for _, info := range infos {
+		if info.ID == m.rejects {
+			return Permanent(fmt.Errorf("invalid product %s", info.ID))
+		}
+	}
+	m.written = append(m.written, infos...)
+	return nil
+}
+
+func (m *MockSink) Written() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.written)
+}
+
+func products(count int) []shared.ProductInfo {
+	var infos []shared.ProductInfo
+	for i := 0; i < count; i++ {
+		infos = append(infos, shared.ProductInfo{
+			ID:         "woolworths_sku_" + strconv.Itoa(i),
+			PriceCents: 100 + i,
+			Timestamp:  time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+		})
+	}
+	return infos
+}
+
+func countEntries(t *testing.T, o *Outbox) int {
+	var count int
+	if err := o.db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestDeliver(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	healthy, down := &MockSink{}, &MockSink{failures: 1}
+	o.AddSink("healthy", healthy)
+	o.AddSink("down", down)
+	if err := o.Add(products(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := o.deliver(o.cursors[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.deliver(o.cursors[1]); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if want, got := 10, healthy.Written(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, down.Written(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// The entries are kept until every sink has them.
+	if want, got := 10, countEntries(t, &o); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	pending, _ := o.Pending("down")
+	if want, got := 10, pending; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	delivered, err := o.deliver(o.cursors[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 10, delivered; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, countEntries(t, &o); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// Products arrive intact and in order.
+	if want, got := products(10)[9].Timestamp, down.written[9].Timestamp; !want.Equal(got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := 109, down.written[9].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	sink := &MockSink{rejects: "woolworths_sku_5"}
+	o.AddSink("picky", sink)
+	o.Add(products(10))
+
+	delivered, err := o.deliver(o.cursors[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 10, delivered; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// Only the rejected product is set aside, the rest of its batch still gets through.
+	if want, got := 9, sink.Written(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	for _, info := range sink.written {
+		if info.ID == sink.rejects {
+			t.Errorf("Expected %s not to be written", sink.rejects)
+		}
+	}
+	deadLetters, _ := o.DeadLetters("picky")
+	if want, got := 1, deadLetters; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1.0, metrics.OutboxDeadLetters.Value("picky"); want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	var product, reason string
+	if err := o.db.QueryRow("SELECT product, error FROM dead_letters").Scan(&product, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(product, sink.rejects) {
+		t.Errorf("Expected the dead letter to hold %s, got %s", sink.rejects, product)
+	}
+	if want, got := "invalid product woolworths_sku_5", reason; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	pending, _ := o.Pending("picky")
+	if want, got := 0, pending; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if err := o.CheckSinks(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	// Nothing's retried.
+	writes := sink.writes
+	if _, err := o.deliver(o.cursors[0]); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := writes, sink.writes; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), false},
+		{Permanent(errors.New("bad request")), true},
+		{fmt.Errorf("failed to write: %w", Permanent(errors.New("bad request"))), true},
+		{Permanent(nil), false},
+	} {
+		if want, got := test.want, IsPermanent(test.err); want != got {
+			t.Errorf("Expected %v, got %v for %v", want, got, test.err)
+		}
+	}
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "outbox.db3")
+	o := Outbox{}
+	if err := o.Init(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	o.AddSink("influxdb", &MockSink{})
+	o.Add(products(OUTBOX_BATCH_SIZE + 5))
+	if _, err := o.deliver(o.cursors[0]); err != nil {
+		t.Fatal(err)
+	}
+	o.db.Close()
+
+	// Only what wasn't acknowledged before the restart is delivered after it.
+	restarted := Outbox{}
+	if err := restarted.Init(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	sink := &MockSink{}
+	restarted.AddSink("influxdb", sink)
+	delivered, err := restarted.deliver(restarted.cursors[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 5, delivered; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "woolworths_sku_"+strconv.Itoa(OUTBOX_BATCH_SIZE), sink.written[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestAddFrom(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "outbox.db3")
+	o := Outbox{}
+	if err := o.Init(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if changeSeq, err := o.ChangeSeq("Woolworths"); err != nil {
+		t.Fatal(err)
+	} else if want, got := int64(0), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if err := o.AddFrom("Woolworths", products(3), 42); err != nil {
+		t.Fatal(err)
+	}
+	// Reading nothing new still moves the cursor past unnamed products.
+	if err := o.AddFrom("Woolworths", nil, 45); err != nil {
+		t.Fatal(err)
+	}
+	o.db.Close()
+
+	restarted := Outbox{}
+	if err := restarted.Init(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	if changeSeq, err := restarted.ChangeSeq("Woolworths"); err != nil {
+		t.Fatal(err)
+	} else if want, got := int64(45), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	restarted.AddSink("influxdb", &MockSink{})
+	if pending, err := restarted.Pending("influxdb"); err != nil {
+		t.Fatal(err)
+	} else if want, got := 3, pending; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestCheckSinks(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	sink := &MockSink{failures: 1}
+	o.AddSink("influxdb", sink)
+	o.Add(products(1))
+
+	if err := o.CheckSinks(); err != nil {
+		t.Errorf("Expected no error before delivering, got %v", err)
+	}
+	if err := o.Flush(); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := o.CheckSinks(); err == nil {
+		t.Errorf("Expected an error after a failed delivery")
+	}
+	if err := o.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.CheckSinks(); err != nil {
+		t.Errorf("Expected no error after a successful delivery, got %v", err)
+	}
+}
+
+func TestSinkStatuses(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	o.AddSink("influxdb", &MockSink{})
+	o.AddSink("timescaledb", &MockSink{failures: 1, rejects: "woolworths_sku_1"})
+	o.Add(products(3))
+	o.Flush()
+
+	statuses := o.SinkStatuses()
+	if want, got := 2, len(statuses); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, statuses[0].Queued; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "", statuses[0].LastError; want != got {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	// The failing sink has everything still waiting.
+	failing := statuses[1]
+	if want, got := 3, failing.Queued; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if failing.LagSeconds <= 0 {
+		t.Errorf("Expected the failing sink to be lagging, got %f", failing.LagSeconds)
+	}
+	if want, got := int64(1), failing.Errors; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if !strings.Contains(failing.LastError, "connection refused") {
+		t.Errorf("Expected the last error, got %q", failing.LastError)
+	}
+
+	// Once it's back it catches up, apart from the product it rejects.
+	o.Flush()
+	failing = o.SinkStatuses()[1]
+	if want, got := 0, failing.Queued; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0.0, failing.LagSeconds; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := int64(1), failing.Dropped; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := "", failing.LastError; want != got {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	sink := &MockSink{}
+	o.AddSink("influxdb", sink)
+	o.Add(products(OUTBOX_BATCH_SIZE * 2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		o.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.Written() < OUTBOX_BATCH_SIZE*2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if want, got := OUTBOX_BATCH_SIZE*2, sink.Written(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	if want, got := OUTBOX_MIN_BACKOFF, nextBackoff(0); want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := 4*time.Second, nextBackoff(2*time.Second); want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if want, got := OUTBOX_MAX_BACKOFF, nextBackoff(OUTBOX_MAX_BACKOFF); want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	o := Outbox{}
+	if err := o.Init(filepath.Join(t.TempDir(), "outbox.db3")); err != nil {
+		t.Fatal(err)
+	}
+	healthy, down := &MockSink{}, &MockSink{failures: 1}
+	o.AddSink("healthy", healthy)
+	o.AddSink("down", down)
+	o.Add(products(OUTBOX_BATCH_SIZE + 5))
+
+	if err := o.Flush(); err == nil {
+		t.Errorf("Expected an error")
+	}
+	if want, got := OUTBOX_BATCH_SIZE+5, healthy.Written(); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	pending, _ := o.Pending("down")
+	if want, got := OUTBOX_BATCH_SIZE+5, pending; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	if err := o.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, countEntries(t, &o); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
@@ -0,0 +1,163 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"golang.org/x/time/rate"
+)
+
+// How often progress is logged while replaying.
+const REPLAY_PROGRESS_INTERVAL = 10 * time.Second
+
+// How many datapoints are written at a time.
+const REPLAY_BATCH_SIZE = 500
+
+// Store is a grocery store's local database, the source of the replayed prices.
+type Store interface {
+	GetSharedProducts() ([]shared.ProductInfo, error)
+	GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error)
+}
+
+// Writer receives the replayed datapoints, E.G. a timeseries database. It returns once they've been
+// written, or why they couldn't be.
+type Writer interface {
+	WriteProductDatapoints([]shared.ProductInfo) error
+}
+
+// Options narrows down what's replayed. Zero values match everything.
+type Options struct {
+	From            time.Time
+	To              time.Time
+	Stores          []string
+	Departments     []string
+	DryRun          bool    // Count the datapoints without writing them
+	PointsPerSecond float64 // The most datapoints to write per second, or 0 for no limit
+}
+
+// Result summarises a replay.
+type Result struct {
+	Products int // Products with at least one datapoint in range
+	Points   int
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange reports whether t falls within the options' time range.
+func (o Options) inRange(t time.Time) bool {
+	return (o.From.IsZero() || !t.Before(o.From)) && (o.To.IsZero() || t.Before(o.To))
+}
+
+// matches reports whether the product passes the store and department filters.
+func (o Options) matches(product shared.ProductInfo) bool {
+	return (len(o.Stores) == 0 || containsFold(o.Stores, product.Store)) &&
+		(len(o.Departments) == 0 || containsFold(o.Departments, product.Department))
+}
+
+// datapoints rebuilds the datapoints a product would have written from its price history, followed by its
+// current state. Only datapoints in the options' time range are returned. Promotions other than the type and
+// was price aren't kept in the history, so historical datapoints don't have them.
+func datapoints(product shared.ProductInfo, history []shared.PriceHistoryEntry, opts Options) []shared.ProductInfo {
+	var points []shared.ProductInfo
+	previousPriceCents := 0
+	for _, entry := range history {
+		point := product
+		point.PriceCents = entry.PriceCents
+		point.PreviousPriceCents = previousPriceCents
+		point.Promotion = shared.Promotion{Type: entry.PromotionType, WasPriceCents: entry.WasPriceCents}
+		point.Timestamp = entry.Observed
+		// The pack size doesn't change, so the unit price scales with the price.
+		if product.PriceCents != 0 {
+			point.UnitPriceCents = product.UnitPriceCents * float64(entry.PriceCents) / float64(product.PriceCents)
+		}
+		previousPriceCents = entry.PriceCents
+		if opts.inRange(point.Timestamp) {
+			points = append(points, point)
+		}
+	}
+	// The current state is the latest observation, unless the last history entry is newer.
+	if (len(history) == 0 || product.Timestamp.After(history[len(history)-1].Observed)) && opts.inRange(product.Timestamp) {
+		points = append(points, product)
+	}
+	return points
+}
+
+// Replay walks every product in the stores, writing a datapoint for each recorded price in batches. The
+// writer may be nil for a dry run. It stops at the first failed write, or early if the context is cancelled.
+func Replay(ctx context.Context, stores []Store, writer Writer, opts Options) (Result, error) {
+	var result Result
+	logger := slog.With("component", "replay")
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.PointsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.PointsPerSecond), 1)
+	}
+	lastProgress := time.Now()
+	var batch []shared.ProductInfo
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := writer.WriteProductDatapoints(batch); err != nil {
+			return fmt.Errorf("failed to write datapoints: %w", err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for _, store := range stores {
+		products, err := store.GetSharedProducts()
+		if err != nil {
+			return result, fmt.Errorf("failed to get products: %w", err)
+		}
+		for _, product := range products {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			if !opts.matches(product) {
+				continue
+			}
+			history, err := store.GetProductPriceHistory(product.ID)
+			if err != nil {
+				return result, fmt.Errorf("failed to get price history for %s: %w", product.ID, err)
+			}
+			points := datapoints(product, history, opts)
+			if len(points) == 0 {
+				continue
+			}
+			result.Products++
+			for _, point := range points {
+				result.Points++
+				if opts.DryRun || writer == nil {
+					continue
+				}
+				if err := limiter.Wait(ctx); err != nil {
+					return result, fmt.Errorf("failed to wait for rate limiter: %w", err)
+				}
+				batch = append(batch, point)
+				if len(batch) == REPLAY_BATCH_SIZE {
+					if err := flush(); err != nil {
+						return result, err
+					}
+				}
+			}
+			if time.Since(lastProgress) > REPLAY_PROGRESS_INTERVAL {
+				logger.Info("Replaying", "products", result.Products, "points", result.Points)
+				lastProgress = time.Now()
+			}
+		}
+	}
+	if opts.DryRun || writer == nil {
+		return result, nil
+	}
+	return result, flush()
+}
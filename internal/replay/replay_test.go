@@ -0,0 +1,147 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockStore struct {
+	products []shared.ProductInfo
+	history  map[string][]shared.PriceHistoryEntry
+}
+
+func (m *MockStore) GetSharedProducts() ([]shared.ProductInfo, error) {
+	return m.products, nil
+}
+
+func (m *MockStore) GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error) {
+	return m.history[id], nil
+}
+
+type MockWriter struct {
+	written []shared.ProductInfo
+	batches int
+	err     error
+}
+
+func (m *MockWriter) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.written = append(m.written, infos...)
+	m.batches++
+	return nil
+}
+
+func day(d int) time.Time {
+	return time.Date(2026, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func getTestStores() []Store {
+	return []Store{
+		&MockStore{
+			products: []shared.ProductInfo{
+				{ID: "woolworths_sku_1", Name: "Milk 2L", Store: "Woolworths", Department: "Dairy", PriceCents: 300, UnitPriceCents: 150, Timestamp: day(10)},
+				{ID: "woolworths_sku_2", Name: "Bread", Store: "Woolworths", Department: "Bakery", PriceCents: 400, Timestamp: day(10)},
+			},
+			history: map[string][]shared.PriceHistoryEntry{
+				"woolworths_sku_1": {
+					{PriceCents: 400, Observed: day(1)},
+					{PriceCents: 300, WasPriceCents: 400, PromotionType: shared.PROMOTION_TYPE_SPECIAL, Observed: day(5)},
+				},
+			},
+		},
+		&MockStore{
+			products: []shared.ProductInfo{
+				{ID: "coles_id_1", Name: "Milk 2L", Store: "Coles", Department: "dairy", PriceCents: 310, Timestamp: day(10)},
+			},
+		},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	writer := &MockWriter{}
+	result, err := Replay(context.Background(), getTestStores(), writer, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 3, result.Products; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// Two history entries and the current state for the first product, then the current state for the others.
+	if want, got := 5, result.Points; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 5, len(writer.written); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, writer.batches; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	first, second := writer.written[0], writer.written[1]
+	if want, got := 400, first.PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 200.0, first.UnitPriceCents; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := "Milk 2L", first.Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 400, second.PreviousPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := shared.PROMOTION_TYPE_SPECIAL, second.Promotion.Type; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := day(5), second.Timestamp; !want.Equal(got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestReplayFilters(t *testing.T) {
+	writer := &MockWriter{}
+	result, err := Replay(context.Background(), getTestStores(), writer, Options{From: day(2), To: day(10), Departments: []string{"Dairy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the special on day 5 is in range. Coles' dairy department matches regardless of case.
+	if want, got := 1, result.Points; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300, writer.written[0].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	result, _ = Replay(context.Background(), getTestStores(), writer, Options{Stores: []string{"coles"}})
+	if want, got := 1, result.Points; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestReplayDryRun(t *testing.T) {
+	writer := &MockWriter{}
+	result, err := Replay(context.Background(), getTestStores(), writer, Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 5, result.Points; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, len(writer.written); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestReplayWriteError(t *testing.T) {
+	writer := &MockWriter{err: errors.New("connection refused")}
+	_, err := Replay(context.Background(), getTestStores(), writer, Options{})
+	if !errors.Is(err, writer.err) {
+		t.Errorf("Expected %v, got %v", writer.err, err)
+	}
+}
@@ -1,9 +1,10 @@
 package shared
 
 import (
-	"context"
 	"net/http"
+	"strconv"
 
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
 	"golang.org/x/time/rate"
 )
 
@@ -14,19 +15,22 @@ import (
 type RLHTTPClient struct {
 	Client      *http.Client
 	Ratelimiter *rate.Limiter
+	Store       string // Labels the requests in the metrics
 }
 
-// Do dispatches the HTTP request to the network
+// Do dispatches the HTTP request to the network. Waiting for the rate limiter is cancelled along with the
+// request's context.
 func (c *RLHTTPClient) Do(req *http.Request) (*http.Response, error) {
-	// Comment out the below 5 lines to turn off ratelimiting
-	ctx := context.Background()
-	err := c.Ratelimiter.Wait(ctx) // This is a blocking call. Honors the rate limit
+	// Comment out the below 4 lines to turn off ratelimiting
+	err := c.Ratelimiter.Wait(req.Context()) // This is a blocking call. Honors the rate limit
 	if err != nil {
 		return nil, err
 	}
 	resp, err := c.Client.Do(req)
 	if err != nil {
+		metrics.HTTPRequests.Inc(c.Store, "error")
 		return nil, err
 	}
+	metrics.HTTPRequests.Inc(c.Store, strconv.Itoa(resp.StatusCode))
 	return resp, nil
 }
@@ -2,6 +2,7 @@ package shared
 
 import (
 	"errors"
+	"math"
 	"time"
 )
 
@@ -12,15 +13,125 @@ type ProductInfo struct {
 	ID                 string
 	Name               string
 	Description        string
+	Barcode            string
+	Brand              string
+	Size               string
+	CanonicalID        string
 	Store              string
 	Department         string
 	Location           string
 	PriceCents         int
 	PreviousPriceCents int
 	WeightGrams        int
+	UnitPriceCents     float64 // Cents per kilogram, litre or item, depending on UnitType
+	UnitType           string
+	Promotion          Promotion
 	Timestamp          time.Time
 }
 
+// Normalised promotion types.
+const PROMOTION_TYPE_SPECIAL = "SPECIAL"
+const PROMOTION_TYPE_HALF_PRICE = "HALF_PRICE"
+const PROMOTION_TYPE_MULTIBUY = "MULTIBUY"
+
+// Promotion describes any special pricing a product is currently offered at.
+type Promotion struct {
+	Type               string
+	WasPriceCents      int
+	MultibuyQuantity   int
+	MultibuyPriceCents int // The total price of MultibuyQuantity items
+	MemberOnly         bool
+}
+
+// Active reports whether the product is currently on any kind of promotion.
+func (p Promotion) Active() bool {
+	return p.Type != "" || p.WasPriceCents != 0 || p.MultibuyQuantity != 0
+}
+
+// Why a promotion was flagged as a false sale.
+const FALSE_SALE_REASON_WAS_PRICE_RARELY_CHARGED = "was_price_rarely_charged"
+const FALSE_SALE_REASON_NOT_BELOW_MEDIAN = "not_below_median"
+
+// FalseSaleFinding is a promotion whose advertised discount doesn't stand up against the product's price history.
+type FalseSaleFinding struct {
+	ProductID               string
+	Name                    string
+	Store                   string
+	PromotionType           string
+	Reason                  string
+	PriceCents              int
+	WasPriceCents           int
+	MedianPriceCents        int
+	WasPriceChargedFraction float64 // The fraction of the trailing window the was price was actually charged
+	FirstDetected           time.Time
+	Detected                time.Time
+}
+
+// PriceCycle is a repeating pattern in a product's price, E.G. a special that comes around every fortnight.
+type PriceCycle struct {
+	ProductID      string
+	Name           string
+	Store          string
+	PeriodDays     int
+	Strength       float64 // The autocorrelation of the price series at the period, from 0 to 1
+	LowPriceCents  int     // The typical price during the low part of the cycle
+	HighPriceCents int     // The typical price during the high part of the cycle
+	NextLowStart   time.Time
+	NextLowEnd     time.Time
+	Detected       time.Time
+}
+
+// DaysUntilLow returns how many whole days from the given time until the next expected low price.
+func (c PriceCycle) DaysUntilLow(now time.Time) int {
+	if !c.NextLowStart.After(now) {
+		return 0
+	}
+	return int(math.Ceil(c.NextLowStart.Sub(now).Hours() / 24))
+}
+
+// DepartmentInfo is a struct that contains information about a store's department.
+type DepartmentInfo struct {
+	ID            string
+	Description   string
+	Store         string
+	ProductCount  int
+	Updated       time.Time
+	LastRefreshed time.Time  // When a scrape run last finished with no failed pages, or zero if never
+	LastRun       *ScrapeRun // The latest scrape run, or nil if there hasn't been one
+}
+
+// ScrapeRun is a sweep over every page of a department. It tells a stable price apart from a broken scraper.
+type ScrapeRun struct {
+	Started         time.Time
+	Finished        time.Time // Zero until every page has been fetched
+	Pages           int
+	FailedPages     int
+	ChangedProducts int // Products that were new or whose price or promotion changed
+}
+
+// PriceHistoryEntry is a single recorded price observation for a product.
+type PriceHistoryEntry struct {
+	PriceCents    int
+	WasPriceCents int
+	OnSpecial     bool
+	PromotionType string
+	Observed      time.Time
+}
+
+// ScrapeSummary describes a single pass over a store's departments.
+type ScrapeSummary struct {
+	Store       string
+	Departments int // Departments that were due for an update
+	Pages       int
+	FailedPages int
+	Products    int // Products saved
+}
+
+// PriceObserver is told about products as soon as a store commits their latest prices.
+type PriceObserver interface {
+	ObservePrices(products []ProductInfo)
+}
+
 const SYSTEM_VERSION_FIELD = "version"
 const SYSTEM_SERVICE_NAME = "agpd"
 const SYSTEM_RAM_UTILISATION_PERCENT_FIELD = "ram_utilisation_percentage"
@@ -28,9 +139,25 @@ const SYSTEM_PRODUCTS_PER_SECOND_FIELD = "products_per_second"
 const SYSTEM_HDD_BYTES_FREE_FIELD = "hdd_bytes_free"
 const SYSTEM_TOTAL_PRODUCT_COUNT_FIELD = "total_product_count"
 
+const SYSTEM_SINK_QUEUED_FIELD = "sink_queued"
+const SYSTEM_SINK_DROPPED_FIELD = "sink_dropped"
+const SYSTEM_SINK_ERRORS_FIELD = "sink_errors"
+const SYSTEM_SINK_LAG_SECONDS_FIELD = "sink_lag_seconds"
+
 type SystemStatusDatapoint struct {
 	RAMUtilisationPercent float64
 	ProductsPerSecond     float64
 	HDDBytesFree          int
 	TotalProductCount     int
+	Sinks                 []SinkStatus
+}
+
+// SinkStatus is how well the outbox is keeping a timeseries database up to date with products.
+type SinkStatus struct {
+	Name       string
+	Queued     int     // Products waiting to be delivered to the sink
+	Dropped    int64   // Products the sink permanently rejected
+	Errors     int64   // Deliveries that have failed
+	LagSeconds float64 // How long the oldest product waiting has been queued
+	LastError  string  // Why the last delivery failed, or empty if it worked
 }
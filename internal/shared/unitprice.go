@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Unit prices are normalised to cents per kilogram, litre or item, depending on the unit type.
+const UNIT_TYPE_MASS = "mass"
+const UNIT_TYPE_VOLUME = "volume"
+const UNIT_TYPE_COUNT = "count"
+
+var measureRegex = regexp.MustCompile(`^(\d*(?:\.\d+)?)\s*([a-z]+)$`)
+var unitPriceRegex = regexp.MustCompile(`\$\s*(\d+(?:\.\d+)?)\s*(?:per|/)\s*(.+)$`)
+
+// NormaliseUnitPrice converts a price for a measure, E.G. 90 cents per "100g", to cents per kilogram,
+// litre or item. It returns the normalised price and the unit type.
+func NormaliseUnitPrice(priceCents float64, measure string) (float64, string, error) {
+	matches := measureRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(measure)))
+	if matches == nil {
+		return 0, "", fmt.Errorf("cannot parse measure `%s`", measure)
+	}
+	quantity := 1.0
+	if matches[1] != "" {
+		var err error
+		quantity, err = strconv.ParseFloat(matches[1], 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("cannot parse quantity `%s`: %w", matches[1], err)
+		}
+	}
+	if quantity <= 0 {
+		return 0, "", fmt.Errorf("invalid quantity in measure `%s`", measure)
+	}
+
+	var scalar float64
+	var unitType string
+	switch matches[2] {
+	case "g", "gm", "gram", "grams":
+		scalar, unitType = 0.001, UNIT_TYPE_MASS
+	case "kg", "kilo", "kilogram", "kilograms":
+		scalar, unitType = 1, UNIT_TYPE_MASS
+	case "ml", "millilitre", "millilitres":
+		scalar, unitType = 0.001, UNIT_TYPE_VOLUME
+	case "l", "lt", "litre", "litres":
+		scalar, unitType = 1, UNIT_TYPE_VOLUME
+	case "ea", "each", "pk", "pack", "ct", "count":
+		scalar, unitType = 1, UNIT_TYPE_COUNT
+	default:
+		return 0, "", fmt.Errorf("cannot normalise unit `%s`", matches[2])
+	}
+	return priceCents / (quantity * scalar), unitType, nil
+}
+
+// ParseUnitPrice reads a display unit price such as "$4.50 per 1kg" or "$0.35 / 1EA" and normalises it
+// with NormaliseUnitPrice.
+func ParseUnitPrice(display string) (float64, string, error) {
+	matches := unitPriceRegex.FindStringSubmatch(display)
+	if matches == nil {
+		return 0, "", fmt.Errorf("cannot parse unit price `%s`", display)
+	}
+	price, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("cannot parse price `%s`: %w", matches[1], err)
+	}
+	return NormaliseUnitPrice(price*100, matches[2])
+}
+
+// UnitPriceFromWeight calculates the price per kilogram from the price and weight of a product.
+func UnitPriceFromWeight(priceCents int, weightGrams int) (float64, string, error) {
+	if weightGrams <= 0 {
+		return 0, "", fmt.Errorf("cannot calculate unit price without a weight")
+	}
+	return float64(priceCents) * 1000 / float64(weightGrams), UNIT_TYPE_MASS, nil
+}
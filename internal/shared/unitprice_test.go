@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormaliseUnitPrice(t *testing.T) {
+	for _, test := range []struct {
+		priceCents float64
+		measure    string
+		wantCents  float64
+		wantType   string
+	}{
+		{450, "1KG", 450, UNIT_TYPE_MASS},
+		{90, "100g", 900, UNIT_TYPE_MASS},
+		{155, "1L", 155, UNIT_TYPE_VOLUME},
+		{30, "100mL", 300, UNIT_TYPE_VOLUME},
+		{35, "1EA", 35, UNIT_TYPE_COUNT},
+		{349, "1 kg", 349, UNIT_TYPE_MASS},
+		{100, "kg", 100, UNIT_TYPE_MASS},
+	} {
+		gotCents, gotType, err := NormaliseUnitPrice(test.priceCents, test.measure)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.measure, err)
+			continue
+		}
+		if want, got := test.wantCents, gotCents; math.Abs(want-got) > 0.0001 {
+			t.Errorf("Expected %f, got %f", want, got)
+		}
+		if want, got := test.wantType, gotType; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+
+	for _, measure := range []string{"", "1 sheet", "0kg"} {
+		if _, _, err := NormaliseUnitPrice(100, measure); err == nil {
+			t.Errorf("Expected an error for %q", measure)
+		}
+	}
+}
+
+func TestParseUnitPrice(t *testing.T) {
+	for display, want := range map[string]float64{
+		"$4.50 per 1kg":  450,
+		"$0.35 / 1EA":    35,
+		"$3.49 per 1 kg": 349,
+		"$0.90 per 100g": 900,
+	} {
+		got, _, err := ParseUnitPrice(display)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", display, err)
+		}
+		if math.Abs(want-got) > 0.0001 {
+			t.Errorf("Expected %f, got %f", want, got)
+		}
+	}
+	if _, _, err := ParseUnitPrice(""); err == nil {
+		t.Errorf("Expected an error")
+	}
+
+	got, unitType, err := UnitPriceFromWeight(450, 750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 600.0; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := UNIT_TYPE_MASS, unitType; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
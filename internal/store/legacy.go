@@ -0,0 +1,156 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+)
+
+// ImportLegacyDB copies the departments, products and price history out of a DB from before the stores
+// shared one, at legacyPath, into the given store. It works whether this DB is SQLite or Postgres. The
+// legacy DB is migrated up to its store's last legacy schema first, after backing it up, and renamed with
+// an ".imported" suffix afterwards so it's only imported once. Scrape runs aren't imported, so the departments won't count as
+// refreshed until they're next scraped. It does nothing if there's no legacy DB.
+func (db *DB) ImportLegacyDB(storeName string, legacyPath string) error {
+	if _, err := os.Stat(legacyPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var existing int
+	if err := db.queryRow("SELECT COUNT(*) FROM products WHERE store = ?", storeName).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to count existing products: %w", err)
+	}
+	if existing > 0 {
+		return fmt.Errorf("%s already has products, not importing %s over them, move it aside to skip it", storeName, legacyPath)
+	}
+	schema, ok := LEGACY_SCHEMAS[storeName]
+	if !ok {
+		return fmt.Errorf("%s has no legacy DB schema", storeName)
+	}
+	legacy, err := migrate.Open(legacyPath, openLegacyDB, schema, db.logger.With("store", storeName))
+	if err != nil {
+		return fmt.Errorf("failed to migrate legacy DB: %w", err)
+	}
+	if err := legacy.Close(); err != nil {
+		return fmt.Errorf("failed to close legacy DB: %w", err)
+	}
+	products, err := db.importLegacyDB(storeName, legacyPath)
+	if err != nil {
+		return err
+	}
+	imported := legacyPath + ".imported"
+	if err := os.Rename(legacyPath, imported); err != nil {
+		return fmt.Errorf("failed to rename imported legacy DB: %w", err)
+	}
+	db.logger.Info("Imported legacy DB", "store", storeName, "products", products, "old", legacyPath, "new", imported)
+	return nil
+}
+
+// importLegacyDB does the copying for ImportLegacyDB, returning how many products were imported. The rows are
+// read out and inserted one by one, rather than attaching the legacy DB, so they can be imported into Postgres.
+func (db *DB) importLegacyDB(storeName string, legacyPath string) (int64, error) {
+	legacy, err := sql.Open("sqlite3", "file:"+legacyPath+"?mode=ro")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open legacy DB: %w", err)
+	}
+	defer legacy.Close()
+
+	// Legacy DBs are migrated before they're imported, but columns and tables that were only added part way
+	// through their life are read as they would have been before then if they're missing anyway.
+	columns := map[string]string{
+		"unitPriceCents":     "0",
+		"unitType":           "''",
+		"wasPriceCents":      "0",
+		"promotionType":      "''",
+		"multibuyQuantity":   "0",
+		"multibuyPriceCents": "0",
+		"memberOnly":         "0",
+		"changeSeq":          "0",
+	}
+	for column := range columns {
+		var exists bool
+		if err := legacy.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info('products') WHERE name = ?", column).Scan(&exists); err != nil {
+			return 0, fmt.Errorf("failed to inspect legacy DB: %w", err)
+		}
+		if exists {
+			columns[column] = column
+		}
+	}
+	var hasPriceHistory bool
+	if err := legacy.QueryRow("SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'price_history'").Scan(&hasPriceHistory); err != nil {
+		return 0, fmt.Errorf("failed to inspect legacy DB: %w", err)
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	err = copyRows(tx, legacy, db.rebind(`
+		INSERT INTO departments (store, departmentID, description, productCount, updated) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (store, departmentID) DO NOTHING`),
+		"SELECT ?, departmentID, description, productCount, updated FROM departments", storeName,
+		new(string), new(string), new(string), new(int), new(time.Time))
+	if err != nil {
+		return 0, fmt.Errorf("failed to import departments: %w", err)
+	}
+	err = copyRows(tx, legacy, db.rebind(`
+		INSERT INTO products (store, productID, name, description, barcode, priceCents, previousPriceCents, weightGrams, unitPriceCents, unitType,
+			wasPriceCents, promotionType, multibuyQuantity, multibuyPriceCents, memberOnly, productJSON, departmentID, updated, changeSeq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (store, productID) DO NOTHING`),
+		fmt.Sprintf(`SELECT ?, productID, name, description, barcode, priceCents, previousPriceCents, weightGrams, %s, %s,
+			%s, %s, %s, %s, %s, productJSON, departmentID, updated, %s
+		FROM products`, columns["unitPriceCents"], columns["unitType"], columns["wasPriceCents"], columns["promotionType"],
+			columns["multibuyQuantity"], columns["multibuyPriceCents"], columns["memberOnly"], columns["changeSeq"]), storeName,
+		new(string), new(string), new(string), new(string), new(string), new(int), new(int), new(int), new(float64), new(string),
+		new(int), new(string), new(int), new(int), new(bool), new([]byte), new(string), new(time.Time), new(int64))
+	if err != nil {
+		return 0, fmt.Errorf("failed to import products: %w", err)
+	}
+	if hasPriceHistory {
+		err = copyRows(tx, legacy, db.rebind(`
+			INSERT INTO price_history (store, productID, priceCents, wasPriceCents, onSpecial, promotionType, observed) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			"SELECT ?, productID, priceCents, wasPriceCents, onSpecial, promotionType, observed FROM price_history ORDER BY rowid", storeName,
+			new(string), new(string), new(int), new(int), new(bool), new(string), new(time.Time))
+		if err != nil {
+			return 0, fmt.Errorf("failed to import price history: %w", err)
+		}
+	}
+	var products int64
+	if err := tx.QueryRow(db.rebind("SELECT COUNT(*) FROM products WHERE store = ?"), storeName).Scan(&products); err != nil {
+		return 0, fmt.Errorf("failed to count imported products: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return products, nil
+}
+
+// copyRows inserts every row selected from the legacy DB, scanning each into the pointers in dest before
+// inserting it.
+func copyRows(tx *sql.Tx, legacy *sql.DB, insert string, selectQuery string, storeName string, dest ...interface{}) error {
+	statement, err := tx.Prepare(insert)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	rows, err := legacy.Query(selectQuery, storeName)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		// The pointers are followed when they're inserted.
+		if _, err := statement.Exec(dest...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"database/sql"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+)
+
+// LEGACY_SCHEMAS describe how each store's DB from before they shared one got to its final schema. Legacy
+// DBs are migrated in place up to it before they're imported, so one from any of those versions can be.
+var LEGACY_SCHEMAS = map[string]migrate.Schema{
+	"Woolworths": {
+		Version:         12,
+		BaselineVersion: 7,
+		Baseline:        initLegacyBaseline,
+		Migrations: legacyMigrations(7,
+			legacyPriceHistory, legacyUnitPrices, legacyPromotions, legacyScrapeRuns, legacyChangeSeq),
+	},
+	"Coles": {
+		Version:         6,
+		BaselineVersion: 1,
+		Baseline:        initLegacyBaseline,
+		Migrations: legacyMigrations(1,
+			legacyPriceHistory, legacyUnitPrices, legacyPromotions, legacyScrapeRuns, legacyChangeSeq),
+	},
+	// Aldi's DB started out with a price history.
+	"Aldi": {
+		Version:         5,
+		BaselineVersion: 1,
+		Baseline:        initLegacyAldiBaseline,
+		Migrations:      legacyMigrations(1, legacyUnitPrices, legacyPromotions, legacyScrapeRuns, legacyChangeSeq),
+	},
+}
+
+// legacyMigrations numbers the migrations in order, starting from the one after baseline.
+func legacyMigrations(baseline int, migrations ...migrate.Migration) []migrate.Migration {
+	for i := range migrations {
+		migrations[i].Version = baseline + 1 + i
+	}
+	return migrations
+}
+
+// Products start their price history at their current price.
+var legacyPriceHistory = migrate.Migration{Description: "record price history", SQL: `
+	CREATE TABLE price_history (productID TEXT, priceCents INTEGER, wasPriceCents INTEGER, onSpecial INTEGER, promotionType TEXT, observed DATETIME);
+	CREATE INDEX price_history_product ON price_history (productID, observed);
+	INSERT INTO price_history (productID, priceCents, wasPriceCents, onSpecial, promotionType, observed)
+		SELECT productID, priceCents, 0, 0, '', updated FROM products;`}
+
+var legacyUnitPrices = migrate.Migration{Description: "normalise unit prices", SQL: `
+	ALTER TABLE products ADD COLUMN unitPriceCents REAL DEFAULT 0;
+	ALTER TABLE products ADD COLUMN unitType TEXT DEFAULT "";`}
+
+var legacyPromotions = migrate.Migration{Description: "capture promotions", SQL: `
+	ALTER TABLE products ADD COLUMN wasPriceCents INTEGER DEFAULT 0;
+	ALTER TABLE products ADD COLUMN promotionType TEXT DEFAULT "";
+	ALTER TABLE products ADD COLUMN multibuyQuantity INTEGER DEFAULT 0;
+	ALTER TABLE products ADD COLUMN multibuyPriceCents INTEGER DEFAULT 0;
+	ALTER TABLE products ADD COLUMN memberOnly INTEGER DEFAULT 0;`}
+
+var legacyScrapeRuns = migrate.Migration{Description: "record scrape runs and page fetches", SQL: `
+	CREATE TABLE scrape_runs (runID INTEGER PRIMARY KEY AUTOINCREMENT, departmentID TEXT, started DATETIME, finished DATETIME, pages INTEGER);
+	CREATE TABLE page_fetches (runID INTEGER, page INTEGER, fetched DATETIME, products INTEGER, changedProducts INTEGER, error TEXT DEFAULT "");
+	CREATE INDEX page_fetches_run ON page_fetches (runID);`}
+
+// Products saved before this keep 0, as they've already been read.
+var legacyChangeSeq = migrate.Migration{Description: "give products a change sequence", SQL: `
+	ALTER TABLE products ADD COLUMN changeSeq INTEGER DEFAULT 0;
+	CREATE INDEX products_change_seq ON products (changeSeq);`}
+
+// initLegacyBaseline creates the schema Woolworths and Coles had before any of the migrations.
+func initLegacyBaseline(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS departments (departmentID TEXT UNIQUE, description TEXT, productCount INTEGER, updated DATETIME)")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS products
+			(	productID TEXT UNIQUE,
+				name TEXT,
+				description TEXT,
+				barcode TEXT,
+				priceCents INTEGER,
+				previousPriceCents INTEGER,
+				weightGrams INTEGER,
+				productJSON TEXT,
+				departmentID TEXT DEFAULT "",
+				updated DATETIME
+			)`)
+	return err
+}
+
+// initLegacyAldiBaseline creates the schema Aldi had before any of the migrations.
+func initLegacyAldiBaseline(tx *sql.Tx) error {
+	if err := initLegacyBaseline(tx); err != nil {
+		return err
+	}
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS price_history (productID TEXT, priceCents INTEGER, wasPriceCents INTEGER, onSpecial INTEGER, promotionType TEXT, observed DATETIME)")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS price_history_product ON price_history (productID, observed)")
+	return err
+}
+
+func openLegacyDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
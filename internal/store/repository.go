@@ -0,0 +1,684 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// How long scrape runs and their page fetches are kept.
+const SCRAPE_RUN_RETENTION = 30 * 24 * time.Hour
+
+// Product is a store's product as it's kept in the DB. Its ID is the store's own, without the ID prefix.
+type Product struct {
+	ID                    string
+	Name                  string
+	Description           string
+	Barcode               string
+	PriceCents            int
+	PreviousPriceCents    int // Only set when loaded
+	WeightGrams           int
+	UnitPriceCents        float64
+	UnitType              string
+	Promotion             shared.Promotion
+	OnSpecial             bool // Recorded in the price history. Not every store's specials have a promotion type.
+	RawJSON               []byte
+	DepartmentID          string
+	DepartmentDescription string // Only set when loaded
+	Updated               time.Time
+}
+
+// priceHistoryEntry extracts the pricing details we keep a history of.
+func (p Product) priceHistoryEntry() shared.PriceHistoryEntry {
+	return shared.PriceHistoryEntry{
+		PriceCents:    p.PriceCents,
+		WasPriceCents: p.Promotion.WasPriceCents,
+		OnSpecial:     p.OnSpecial,
+		PromotionType: p.Promotion.Type,
+		Observed:      p.Updated,
+	}
+}
+
+// Department is a store's department as it's kept in the DB.
+type Department struct {
+	ID           string
+	Description  string
+	ProductCount int
+	Updated      time.Time
+	Slug         string // Also matched by ScrapeDepartment when it's fetched from the web, E.G. "fruits-vegetables". Not kept.
+}
+
+// StoreConfig describes a grocery store to its Repository.
+type StoreConfig struct {
+	Name     string // As used in shared.ProductInfo.Store, E.G. "Woolworths"
+	IDPrefix string // Prepended to the store's product IDs outside of the store, E.G. "woolworths_sku_"
+	// DescribeProduct reads the brand and size out of a product's raw JSON, for matching against other stores.
+	DescribeProduct func(rawJSON []byte) (brand string, size string, err error)
+}
+
+// Repository is one grocery store's view of the DB. Stores embed it, by way of their Scraper, to provide
+// the read side of ProductInfoGetter, api.ProductStore and friends.
+type Repository struct {
+	db          *DB
+	config      StoreConfig
+	lastScraped *atomic.Int64 // When products were last committed, in Unix nanoseconds
+}
+
+// Repository returns the view of the DB for the given store.
+func (db *DB) Repository(config StoreConfig) *Repository {
+	r := &Repository{db: db, config: config, lastScraped: &atomic.Int64{}}
+	// Readiness is judged on when a page was last saved, so start from the newest product on disk.
+	var newest time.Time
+	if err := db.queryRow("SELECT updated FROM products WHERE store = ? ORDER BY updated DESC LIMIT 1", config.Name).Scan(&newest); err == nil {
+		r.lastScraped.Store(newest.UnixNano())
+	}
+	return r
+}
+
+// StoreName returns the name of the store, as used in shared.ProductInfo.Store
+func (r *Repository) StoreName() string {
+	return r.config.Name
+}
+
+// saveProductQuery saves a product, giving it the store's next change sequence.
+const saveProductQuery = `
+	INSERT INTO products (store, productID, name, description, barcode, priceCents, previousPriceCents, weightGrams, unitPriceCents, unitType,
+		wasPriceCents, promotionType, multibuyQuantity, multibuyPriceCents, memberOnly, productJSON, departmentID, updated, changeSeq)
+	VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(changeSeq), 0) + 1 FROM products WHERE store = ?))
+	ON CONFLICT(store, productID) DO UPDATE SET
+		name = excluded.name,
+		description = excluded.description,
+		barcode = excluded.barcode,
+		priceCents = excluded.priceCents,
+		previousPriceCents = products.priceCents,
+		weightGrams = excluded.weightGrams,
+		unitPriceCents = excluded.unitPriceCents,
+		unitType = excluded.unitType,
+		wasPriceCents = excluded.wasPriceCents,
+		promotionType = excluded.promotionType,
+		multibuyQuantity = excluded.multibuyQuantity,
+		multibuyPriceCents = excluded.multibuyPriceCents,
+		memberOnly = excluded.memberOnly,
+		productJSON = excluded.productJSON,
+		departmentID = excluded.departmentID,
+		updated = excluded.updated,
+		changeSeq = excluded.changeSeq`
+
+// savePriceHistoryQuery appends a price history entry, unless it's the same as the latest one.
+const savePriceHistoryQuery = `
+	INSERT INTO price_history (store, productID, priceCents, wasPriceCents, onSpecial, promotionType, observed)
+	SELECT ?, ?, ?, ?, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM (
+			SELECT priceCents, wasPriceCents, onSpecial, promotionType
+			FROM price_history
+			WHERE store = ? AND productID = ?
+			ORDER BY observed DESC, rowid DESC LIMIT 1
+		) AS latest
+		WHERE latest.priceCents = ? AND latest.wasPriceCents = ? AND latest.onSpecial = ? AND latest.promotionType = ?
+	)`
+
+// saveDepartmentQuery saves a department, replacing what was known about it.
+const saveDepartmentQuery = `
+	INSERT INTO departments (store, departmentID, description, productCount, updated)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(store, departmentID) DO UPDATE SET
+		description = excluded.description,
+		productCount = excluded.productCount,
+		updated = excluded.updated`
+
+// Tx saves a batch of a store's products in a single transaction.
+type Tx struct {
+	tx                   *sql.Tx
+	r                    *Repository
+	saveProductStmt      *sql.Stmt // The DB's prepared statements for this transaction, once they're needed
+	savePriceHistoryStmt *sql.Stmt
+}
+
+// Begin starts a transaction to save products in.
+func (r *Repository) Begin() (*Tx, error) {
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	t := &Tx{tx: tx, r: r}
+	if r.db.postgres {
+		// SQLite only has one writer, but on Postgres other replicas may be saving the same store's products.
+		// Take turns, so change sequences are committed in the order they're handed out.
+		if _, err := t.exec("SELECT pg_advisory_xact_lock(hashtext(?))", r.config.Name); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to lock store: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// Commit commits the saved products.
+func (t *Tx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	t.r.lastScraped.Store(time.Now().UnixNano())
+	return nil
+}
+
+// exec runs a statement in the transaction.
+func (t *Tx) exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.Exec(t.r.db.rebind(query), args...)
+}
+
+// Rollback abandons the transaction. It does nothing if the transaction has already been committed.
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// SaveProduct saves a product, giving it the store's next change sequence. It returns whether the product
+// is new or its price or promotion changed.
+func (t *Tx) SaveProduct(product Product) (bool, error) {
+	if t.saveProductStmt == nil {
+		t.saveProductStmt = t.tx.Stmt(t.r.db.statements.saveProduct)
+	}
+	result, err := t.saveProductStmt.Exec(
+		t.r.config.Name, product.ID, product.Name, product.Description, product.Barcode, product.PriceCents,
+		product.WeightGrams, product.UnitPriceCents, product.UnitType,
+		product.Promotion.WasPriceCents, product.Promotion.Type, product.Promotion.MultibuyQuantity, product.Promotion.MultibuyPriceCents, product.Promotion.MemberOnly,
+		product.RawJSON, product.DepartmentID, product.Updated, t.r.config.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to update product info: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		t.r.db.logger.Warn("Product info not updated.", "store", t.r.config.Name)
+	}
+
+	changed, err := t.savePriceHistory(product.ID, product.priceHistoryEntry())
+	if err != nil {
+		return false, fmt.Errorf("failed to update price history: %w", err)
+	}
+	return changed, nil
+}
+
+// savePriceHistory appends a price history entry for the product, but only if the price,
+// was-price or special status differs from the most recently recorded entry. It returns whether
+// an entry was appended.
+func (t *Tx) savePriceHistory(productID string, entry shared.PriceHistoryEntry) (bool, error) {
+	if t.savePriceHistoryStmt == nil {
+		t.savePriceHistoryStmt = t.tx.Stmt(t.r.db.statements.savePriceHistory)
+	}
+	result, err := t.savePriceHistoryStmt.Exec(
+		t.r.config.Name, productID, entry.PriceCents, entry.WasPriceCents, entry.OnSpecial, entry.PromotionType, entry.Observed,
+		t.r.config.Name, productID, entry.PriceCents, entry.WasPriceCents, entry.OnSpecial, entry.PromotionType)
+	if err != nil {
+		return false, err
+	}
+	appended, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return appended > 0, nil
+}
+
+// SaveProducts saves the products in a single transaction.
+func (r *Repository) SaveProducts(products []Product) error {
+	tx, err := r.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, product := range products {
+		if _, err := tx.SaveProduct(product); err != nil {
+			return fmt.Errorf("failed to save product info: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadProduct loads a product by the store's own ID.
+func (r *Repository) LoadProduct(id string) (Product, error) {
+	var product Product
+	var deptDescription sql.NullString
+	row := r.db.queryRow(`
+	SELECT
+		productID,
+		name,
+		products.description,
+		barcode,
+		priceCents,
+		previousPriceCents,
+		weightGrams,
+		productJSON,
+		products.departmentID,
+		departments.description,
+		products.updated
+	FROM
+		products
+		LEFT JOIN departments ON products.store = departments.store AND products.departmentID = departments.departmentID
+	WHERE products.store = ? AND productID = ? LIMIT 1`, r.config.Name, id)
+	err := row.Scan(
+		&product.ID,
+		&product.Name,
+		&product.Description,
+		&product.Barcode,
+		&product.PriceCents,
+		&product.PreviousPriceCents,
+		&product.WeightGrams,
+		&product.RawJSON,
+		&product.DepartmentID,
+		&deptDescription, // This value comes from a join, so it might be NULL.
+		&product.Updated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return product, shared.ErrProductMissing
+		}
+		return product, fmt.Errorf("failed to query existing product info: %w", err)
+	}
+	if deptDescription.Valid {
+		product.DepartmentDescription = deptDescription.String
+	}
+	return product, nil
+}
+
+// SaveDepartment saves a department, replacing what was known about it.
+func (r *Repository) SaveDepartment(department Department) error {
+	result, err := r.db.statements.saveDepartment.Exec(
+		r.config.Name, department.ID, department.Description, department.ProductCount, department.Updated)
+	if err != nil {
+		return fmt.Errorf("failed to update department ID info: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	} else if rowsAffected == 0 {
+		r.db.logger.Warn("Department not upserted", "store", r.config.Name)
+	}
+	return nil
+}
+
+// LoadDepartments returns every department the store has saved.
+func (r *Repository) LoadDepartments() ([]Department, error) {
+	var departments []Department
+	rows, err := r.db.query("SELECT departmentID, description, productCount, updated FROM departments WHERE store = ?", r.config.Name)
+	if err != nil {
+		return departments, fmt.Errorf("failed to query departmentIDs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var department Department
+		err = rows.Scan(&department.ID, &department.Description, &department.ProductCount, &department.Updated)
+		if err != nil {
+			return departments, fmt.Errorf("failed to scan departmentID: %w", err)
+		}
+		departments = append(departments, department)
+	}
+	return departments, nil
+}
+
+// loadPriceHistory returns the recorded price history of a product, oldest first.
+func (r *Repository) loadPriceHistory(productID string) ([]shared.PriceHistoryEntry, error) {
+	var history []shared.PriceHistoryEntry
+	rows, err := r.db.query(`
+		SELECT priceCents, wasPriceCents, onSpecial, promotionType, observed
+		FROM price_history
+		WHERE store = ? AND productID = ?
+		ORDER BY observed ASC, rowid ASC`, r.config.Name, productID)
+	if err != nil {
+		return history, fmt.Errorf("failed to query price history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry shared.PriceHistoryEntry
+		err = rows.Scan(&entry.PriceCents, &entry.WasPriceCents, &entry.OnSpecial, &entry.PromotionType, &entry.Observed)
+		if err != nil {
+			return history, fmt.Errorf("failed to scan price history: %w", err)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// StartScrapeRun records the start of a sweep over every page of a department, returning the run's ID.
+// Runs older than SCRAPE_RUN_RETENTION are pruned while we're here.
+func (r *Repository) StartScrapeRun(departmentID string, pages int) (int64, error) {
+	now := time.Now()
+	// A department with no pages is done as soon as it's started.
+	var finished interface{}
+	if pages == 0 {
+		finished = now
+	}
+	var runID int64
+	// This writes, so it can't go through the pool that reads.
+	err := r.db.db.QueryRow(r.db.rebind("INSERT INTO scrape_runs (store, departmentID, started, finished, pages) VALUES (?, ?, ?, ?, ?) RETURNING runID"),
+		r.config.Name, departmentID, now, finished, pages).Scan(&runID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert scrape run: %w", err)
+	}
+	cutoff := now.Add(-SCRAPE_RUN_RETENTION)
+	if _, err := r.db.exec("DELETE FROM page_fetches WHERE runID IN (SELECT runID FROM scrape_runs WHERE store = ? AND started < ?)", r.config.Name, cutoff); err != nil {
+		return runID, fmt.Errorf("failed to prune page fetches: %w", err)
+	}
+	if _, err := r.db.exec("DELETE FROM scrape_runs WHERE store = ? AND started < ?", r.config.Name, cutoff); err != nil {
+		return runID, fmt.Errorf("failed to prune scrape runs: %w", err)
+	}
+	return runID, nil
+}
+
+// RecordPageFetch records how fetching a page of a scrape run went, and finishes the run once every one
+// of its pages has been fetched.
+func (r *Repository) RecordPageFetch(runID int64, page, products, changedProducts int, fetchErr error) error {
+	if runID == 0 {
+		// The run couldn't be recorded, so neither can its pages.
+		return nil
+	}
+	var errText string
+	if fetchErr != nil {
+		errText = fetchErr.Error()
+	}
+	tx, err := r.db.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	_, err = tx.Exec(r.db.rebind("INSERT INTO page_fetches (runID, page, fetched, products, changedProducts, error) VALUES (?, ?, ?, ?, ?, ?)"),
+		runID, page, now, products, changedProducts, errText)
+	if err != nil {
+		return fmt.Errorf("failed to insert page fetch: %w", err)
+	}
+	_, err = tx.Exec(r.db.rebind(`
+		UPDATE scrape_runs SET finished = ?
+		WHERE runID = ? AND finished IS NULL AND pages <= (SELECT COUNT(*) FROM page_fetches WHERE runID = ?)`),
+		now, runID, runID)
+	if err != nil {
+		return fmt.Errorf("failed to finish scrape run: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// loadScrapeRuns returns the latest scrape run of each department, and when each was last fully refreshed.
+func (r *Repository) loadScrapeRuns() (map[string]shared.ScrapeRun, map[string]time.Time, error) {
+	latest := make(map[string]shared.ScrapeRun)
+	refreshed := make(map[string]time.Time)
+	rows, err := r.db.query(`
+		SELECT scrape_runs.departmentID, started, finished, pages,
+			COUNT(NULLIF(page_fetches.error, '')), COALESCE(SUM(page_fetches.changedProducts), 0)
+		FROM scrape_runs
+		LEFT JOIN page_fetches ON page_fetches.runID = scrape_runs.runID
+		WHERE scrape_runs.store = ?
+		GROUP BY scrape_runs.runID
+		ORDER BY scrape_runs.runID`, r.config.Name)
+	if err != nil {
+		return latest, refreshed, fmt.Errorf("failed to query scrape runs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var run shared.ScrapeRun
+		var finished sql.NullTime
+		if err := rows.Scan(&id, &run.Started, &finished, &run.Pages, &run.FailedPages, &run.ChangedProducts); err != nil {
+			return latest, refreshed, fmt.Errorf("failed to scan scrape run: %w", err)
+		}
+		run.Finished = finished.Time
+		// Runs are in order, so later ones replace earlier ones.
+		latest[id] = run
+		if finished.Valid && run.FailedPages == 0 {
+			refreshed[id] = run.Finished
+		}
+	}
+	return latest, refreshed, rows.Err()
+}
+
+// sharedProductQuery selects the columns scanned by querySharedProducts.
+const sharedProductQuery = `
+		SELECT
+			productID,
+			products.name,
+			products.description,
+			products.barcode,
+			departments.description,
+			priceCents,
+			previousPriceCents,
+			weightGrams,
+			unitPriceCents,
+			unitType,
+			wasPriceCents,
+			promotionType,
+			multibuyQuantity,
+			multibuyPriceCents,
+			memberOnly,
+			products.updated
+		FROM
+			products
+			LEFT JOIN departments ON products.store = departments.store AND products.departmentID = departments.departmentID
+		WHERE products.store = ?`
+
+// querySharedProducts runs sharedProductQuery with the given extra conditions and converts the results to
+// shared.ProductInfo. The conditions are ANDed with the store, E.G. "AND name != ”".
+func (r *Repository) querySharedProducts(conditions string, args ...interface{}) ([]shared.ProductInfo, error) {
+	var productIDs []shared.ProductInfo
+	var deptDescription sql.NullString
+	rows, err := r.db.query(sharedProductQuery+" "+conditions, append([]interface{}{r.config.Name}, args...)...)
+	if err != nil {
+		return productIDs, fmt.Errorf("failed to query productIDs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var product shared.ProductInfo
+		err = rows.Scan(
+			&product.ID,
+			&product.Name,
+			&product.Description,
+			&product.Barcode,
+			&deptDescription,
+			&product.PriceCents,
+			&product.PreviousPriceCents,
+			&product.WeightGrams,
+			&product.UnitPriceCents,
+			&product.UnitType,
+			&product.Promotion.WasPriceCents,
+			&product.Promotion.Type,
+			&product.Promotion.MultibuyQuantity,
+			&product.Promotion.MultibuyPriceCents,
+			&product.Promotion.MemberOnly,
+			&product.Timestamp)
+		if err != nil {
+			return productIDs, fmt.Errorf("failed to scan productID: %w", err)
+		}
+		if deptDescription.Valid {
+			product.Department = deptDescription.String
+		}
+		product.ID = r.config.IDPrefix + product.ID
+		product.Store = r.config.Name
+		productIDs = append(productIDs, product)
+	}
+	return productIDs, nil
+}
+
+// GetSharedProductsChangedAfter returns up to count products saved after the given change sequence, in the
+// order they were saved, along with the change sequence to read after next time.
+func (r *Repository) GetSharedProductsChangedAfter(changeSeq int64, count int) ([]shared.ProductInfo, int64, error) {
+	// Find where this page ends first. Anything saved after this gets a later change sequence, so it can't
+	// sneak in behind the cursor.
+	var upTo sql.NullInt64
+	err := r.db.queryRow("SELECT MAX(changeSeq) FROM (SELECT changeSeq FROM products WHERE store = ? AND changeSeq > ? ORDER BY changeSeq LIMIT ?) AS page", r.config.Name, changeSeq, count).Scan(&upTo)
+	if err != nil {
+		return nil, changeSeq, fmt.Errorf("failed to query change sequence: %w", err)
+	}
+	if !upTo.Valid {
+		return nil, changeSeq, nil
+	}
+	products, err := r.querySharedProducts("AND products.changeSeq > ? AND products.changeSeq <= ? AND name != '' ORDER BY products.changeSeq", changeSeq, upTo.Int64)
+	if err != nil {
+		return products, changeSeq, err
+	}
+	return products, upTo.Int64, nil
+}
+
+// LatestChangeSeq returns the change sequence of the most recently saved product, or 0 if there are none.
+func (r *Repository) LatestChangeSeq() (int64, error) {
+	var changeSeq int64
+	if err := r.db.queryRow("SELECT COALESCE(MAX(changeSeq), 0) FROM products WHERE store = ?", r.config.Name).Scan(&changeSeq); err != nil {
+		return 0, fmt.Errorf("failed to query change sequence: %w", err)
+	}
+	return changeSeq, nil
+}
+
+// Ping checks the local DB can be read.
+func (r *Repository) Ping() error {
+	var count int
+	if err := r.db.queryRow("SELECT COUNT(*) FROM departments WHERE store = ?", r.config.Name).Scan(&count); err != nil {
+		return fmt.Errorf("failed to query departments: %w", err)
+	}
+	return nil
+}
+
+// LastScraped returns when products were last committed to the DB, or the zero time if never.
+func (r *Repository) LastScraped() time.Time {
+	if ns := r.lastScraped.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// likeEscaper escapes LIKE's wildcards, so they match themselves, E.G. the "%" in "100% juice".
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchProducts returns up to limit products whose name contains the query string.
+func (r *Repository) SearchProducts(query string, limit int) ([]shared.ProductInfo, error) {
+	// SQLite's LIKE ignores case but Postgres' doesn't, so ignore it explicitly.
+	return r.querySharedProducts(`AND LOWER(products.name) LIKE LOWER(?) ESCAPE '\' AND name != '' ORDER BY products.name LIMIT ?`,
+		"%"+likeEscaper.Replace(query)+"%", limit)
+}
+
+// GetPromotedProducts returns every product currently on a promotion or advertising a was price.
+func (r *Repository) GetPromotedProducts() ([]shared.ProductInfo, error) {
+	return r.querySharedProducts("AND (products.promotionType != '' OR products.wasPriceCents > 0) AND name != ''")
+}
+
+// GetProductsWithPriceChanges returns every named product with at least minEntries entries in its price history.
+func (r *Repository) GetProductsWithPriceChanges(minEntries int) ([]shared.ProductInfo, error) {
+	return r.querySharedProducts(`AND products.productID IN (
+		SELECT productID FROM price_history WHERE store = ? GROUP BY productID HAVING COUNT(*) >= ?) AND name != ''`, r.config.Name, minEntries)
+}
+
+// GetSharedProducts returns every named product.
+func (r *Repository) GetSharedProducts() ([]shared.ProductInfo, error) {
+	return r.querySharedProducts("AND name != ''")
+}
+
+// GetSharedProductsByID returns the named products with the given store IDs, E.G. to tell a price observer
+// about the products that were just committed.
+func (r *Repository) GetSharedProductsByID(ids []string) ([]shared.ProductInfo, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return r.querySharedProducts("AND productID IN (?"+strings.Repeat(", ?", len(ids)-1)+") AND name != ''", args...)
+}
+
+// GetProduct returns a single product by its shared (prefixed) ID.
+func (r *Repository) GetProduct(id string) (shared.ProductInfo, error) {
+	if !strings.HasPrefix(id, r.config.IDPrefix) {
+		return shared.ProductInfo{}, shared.ErrProductMissing
+	}
+	products, err := r.querySharedProducts("AND productID = ? LIMIT 1", strings.TrimPrefix(id, r.config.IDPrefix))
+	if err != nil {
+		return shared.ProductInfo{}, err
+	}
+	if len(products) == 0 {
+		return shared.ProductInfo{}, shared.ErrProductMissing
+	}
+	return products[0], nil
+}
+
+// GetProductsByBarcode returns the products whose barcode matches the given GTIN, ignoring leading zeros.
+func (r *Repository) GetProductsByBarcode(gtin string) ([]shared.ProductInfo, error) {
+	gtin = strings.TrimLeft(gtin, "0")
+	if gtin == "" {
+		return []shared.ProductInfo{}, nil
+	}
+	return r.querySharedProducts("AND ltrim(products.barcode, '0') = ?", gtin)
+}
+
+// GetMatchableProducts returns every named product with its brand and size filled in from the raw product JSON,
+// for matching against other stores.
+func (r *Repository) GetMatchableProducts() ([]shared.ProductInfo, error) {
+	var products []shared.ProductInfo
+	rows, err := r.db.query("SELECT productID, name, weightGrams, productJSON FROM products WHERE store = ? AND name != ''", r.config.Name)
+	if err != nil {
+		return products, fmt.Errorf("failed to query matchable products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var product shared.ProductInfo
+		var rawJSON []byte
+		if err := rows.Scan(&product.ID, &product.Name, &product.WeightGrams, &rawJSON); err != nil {
+			return products, fmt.Errorf("failed to scan matchable product: %w", err)
+		}
+		if len(rawJSON) > 0 && r.config.DescribeProduct != nil {
+			if brand, size, err := r.config.DescribeProduct(rawJSON); err != nil {
+				r.db.logger.Debug("Couldn't decode product JSON", "store", r.config.Name, "productID", product.ID, "error", err)
+			} else {
+				product.Brand = brand
+				product.Size = size
+			}
+		}
+		product.ID = r.config.IDPrefix + product.ID
+		product.Store = r.config.Name
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+// GetDepartments returns all the departments known to the store, with how fresh their prices are.
+func (r *Repository) GetDepartments() ([]shared.DepartmentInfo, error) {
+	var departments []shared.DepartmentInfo
+	saved, err := r.LoadDepartments()
+	if err != nil {
+		return departments, err
+	}
+	latestRuns, refreshed, err := r.loadScrapeRuns()
+	if err != nil {
+		return departments, err
+	}
+	for _, dept := range saved {
+		department := shared.DepartmentInfo{
+			ID:            dept.ID,
+			Description:   dept.Description,
+			Store:         r.config.Name,
+			ProductCount:  dept.ProductCount,
+			Updated:       dept.Updated,
+			LastRefreshed: refreshed[dept.ID],
+		}
+		if run, ok := latestRuns[dept.ID]; ok {
+			department.LastRun = &run
+		}
+		departments = append(departments, department)
+	}
+	return departments, nil
+}
+
+// GetProductPriceHistory returns every recorded price of the given product, oldest first.
+// The ID may be given with or without the store's ID prefix.
+func (r *Repository) GetProductPriceHistory(id string) ([]shared.PriceHistoryEntry, error) {
+	return r.loadPriceHistory(strings.TrimPrefix(id, r.config.IDPrefix))
+}
+
+// GetTotalProductCount returns the total number of products the store has saved.
+func (r *Repository) GetTotalProductCount() (int, error) {
+	var count int
+	err := r.db.queryRow("SELECT COUNT(*) FROM products WHERE store = ?", r.config.Name).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query product count: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// How often the department list is checked for new departments and changed product counts.
+const DEPARTMENT_CHECK_INTERVAL = 1 * time.Hour
+
+// Source is a grocery store's website, as far as the Scraper needs it. Stores implement it by fetching and
+// mapping, and leave the rest to the Scraper.
+type Source interface {
+	// FetchDepartments fetches every department that should be scraped, with how many products each has.
+	FetchDepartments(ctx context.Context) ([]Department, error)
+	// FetchProducts fetches one page of a department's products, numbered from 1.
+	FetchProducts(ctx context.Context, departmentID string, page int) ([]Product, error)
+}
+
+// Scraper keeps a store's products up to date by scraping its Source department by department and page by
+// page, recording each as a scrape run. Stores embed it alongside the Repository it embeds.
+type Scraper struct {
+	*Repository
+	Source          Source
+	PageSize        int                            // How many products FetchProducts returns on a full page
+	Workers         int                            // How many pages Run fetches at once
+	MaxAge          time.Duration                  // How long after a department's scraped that it's due again
+	RefreshInterval time.Duration                  // How long Run waits between passes over the departments
+	Include         func(departmentID string) bool // Only the departments it's true for are scraped, if set
+	Logger          *slog.Logger
+	priceObserver   shared.PriceObserver
+}
+
+// departmentPage is one page of a department's product list.
+type departmentPage struct {
+	departmentID string
+	page         int
+	runID        int64 // The scrape run the page is part of
+}
+
+// SetPriceObserver registers an observer to be told about products whenever their prices are committed.
+func (s *Scraper) SetPriceObserver(observer shared.PriceObserver) {
+	s.priceObserver = observer
+}
+
+// observeCommittedPrices passes the freshly committed products to the price observer, if there is one.
+func (s *Scraper) observeCommittedPrices(ids []string) {
+	if s.priceObserver == nil || len(ids) == 0 {
+		return
+	}
+	products, err := s.GetSharedProductsByID(ids)
+	if err != nil {
+		s.Logger.Error("Failed to load committed products for the price observer", "error", err)
+		return
+	}
+	s.priceObserver.ObservePrices(products)
+}
+
+// included returns whether a department should be scraped.
+func (s *Scraper) included(departmentID string) bool {
+	return s.Include == nil || s.Include(departmentID)
+}
+
+// departmentPages lists every page of a department's product list, starting from page 1.
+func (s *Scraper) departmentPages(department Department) []departmentPage {
+	var pages []departmentPage
+	for productCount := 0; productCount < department.ProductCount; productCount += s.PageSize {
+		pages = append(pages, departmentPage{
+			departmentID: department.ID,
+			page:         productCount/s.PageSize + 1,
+		})
+	}
+	return pages
+}
+
+// startDepartmentRun records the start of a scrape run over every page of a department, returning the
+// pages to fetch.
+func (s *Scraper) startDepartmentRun(department Department) []departmentPage {
+	pages := s.departmentPages(department)
+	runID, err := s.StartScrapeRun(department.ID, len(pages))
+	if err != nil {
+		s.Logger.Error("Failed to record scrape run", "department", department.Description, "error", err)
+	}
+	for i := range pages {
+		pages[i].runID = runID
+	}
+	return pages
+}
+
+// refreshDepartments compares the department list on the web with the DB, saving any departments that are
+// new or whose product count has changed. They're marked as due for an update.
+func (s *Scraper) refreshDepartments(ctx context.Context) error {
+	departmentsFromWeb, err := s.Source.FetchDepartments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get department IDs from web: %w", err)
+	}
+	departmentsFromDB, err := s.LoadDepartments()
+	if err != nil {
+		return fmt.Errorf("failed to load department IDs from DB: %w", err)
+	}
+	known := make(map[string]Department, len(departmentsFromDB))
+	for _, dept := range departmentsFromDB {
+		known[dept.ID] = dept
+	}
+	for _, webDepartment := range departmentsFromWeb {
+		if dept, ok := known[webDepartment.ID]; !ok {
+			s.Logger.Info("New department ID", "ID", webDepartment.ID, "Description", webDepartment.Description)
+		} else if dept.ProductCount != webDepartment.ProductCount {
+			s.Logger.Info("Department flagged for update", "oldProductCount", dept.ProductCount, "newProductCount", webDepartment.ProductCount)
+		} else {
+			continue
+		}
+		// Set the update time to the past so we force an update on the next poll.
+		webDepartment.Updated = time.Now().Add(-2 * s.MaxAge)
+		if err := s.SaveDepartment(webDepartment); err != nil {
+			return fmt.Errorf("failed to save department: %w", err)
+		}
+	}
+	return nil
+}
+
+// dueDepartments loads the departments that are due for an update.
+func (s *Scraper) dueDepartments() ([]Department, error) {
+	departments, err := s.LoadDepartments()
+	if err != nil {
+		return nil, err
+	}
+	var due []Department
+	for _, dept := range departments {
+		if !s.included(dept.ID) {
+			s.Logger.Debug("Skipping excluded department", "ID", dept.ID)
+			continue
+		}
+		if time.Since(dept.Updated) < s.MaxAge {
+			s.Logger.Debug("Skipping update of department", "ID", dept.ID, "UpdatedAgo", time.Since(dept.Updated))
+			continue
+		}
+		due = append(due, dept)
+	}
+	return due, nil
+}
+
+// scrapeDepartmentPage fetches one page of a department's product list from the web and writes the
+// products to the DB, transactionfully. It returns how many products were saved. How it went is recorded
+// against the page's scrape run.
+func (s *Scraper) scrapeDepartmentPage(ctx context.Context, dp departmentPage) (int, error) {
+	saved, changed, err := s.saveDepartmentPage(ctx, dp)
+	// A page cut short by shutting down isn't the scraper's fault, so it's left unrecorded.
+	if ctx.Err() == nil {
+		if err := s.RecordPageFetch(dp.runID, dp.page, saved, changed, err); err != nil {
+			s.Logger.Error("Failed to record page fetch", "departmentID", dp.departmentID, "page", dp.page, "error", err)
+		}
+	}
+	return saved, err
+}
+
+// saveDepartmentPage does the work of scrapeDepartmentPage, returning how many products were saved and
+// how many of those changed. Either every product on the page is saved or none are.
+func (s *Scraper) saveDepartmentPage(ctx context.Context, dp departmentPage) (int, int, error) {
+	s.Logger.Debug("Getting product list page", "departmentID", dp.departmentID, "page", dp.page)
+	products, err := s.Source.FetchProducts(ctx, dp.departmentID, dp.page)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get product list page: %w", err)
+	}
+	tx, err := s.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var skippedProductCount, changedProductCount int
+	var savedProductIDs []string
+	for _, product := range products {
+		// Skip products with zero price. Assume something went wrong.
+		if product.PriceCents == 0 {
+			skippedProductCount++
+			continue
+		}
+		product.DepartmentID = dp.departmentID
+		// On Postgres a failed statement aborts the transaction, so the rest of the page can't be saved
+		// either. The whole page is rolled back and fails, to be retried.
+		changed, err := tx.SaveProduct(product)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to save product %s: %w", product.ID, err)
+		}
+		if changed {
+			changedProductCount++
+		}
+		savedProductIDs = append(savedProductIDs, product.ID)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	s.observeCommittedPrices(savedProductIDs)
+	metrics.PagesScraped.Inc(s.StoreName())
+	metrics.ProductsSaved.Add(len(savedProductIDs), s.StoreName())
+	metrics.ZeroPriceSkips.Add(skippedProductCount, s.StoreName())
+	if skippedProductCount > 0 {
+		s.Logger.Debug("Skipped products with zero price", "skippedProductCount", skippedProductCount)
+	}
+	return len(savedProductIDs), changedProductCount, nil
+}
+
+// findDepartment looks up a department by ID or name, ignoring case. If the DB doesn't have it the
+// department list is fetched from the web, where it can also be found by its slug.
+func (s *Scraper) findDepartment(ctx context.Context, department string) (Department, error) {
+	match := func(departments []Department) (Department, bool) {
+		for _, dept := range departments {
+			if strings.EqualFold(dept.ID, department) || strings.EqualFold(dept.Description, department) ||
+				(dept.Slug != "" && strings.EqualFold(dept.Slug, department)) {
+				return dept, true
+			}
+		}
+		return Department{}, false
+	}
+	departmentsFromDB, err := s.LoadDepartments()
+	if err != nil {
+		return Department{}, fmt.Errorf("failed to load departments: %w", err)
+	}
+	if dept, ok := match(departmentsFromDB); ok {
+		return dept, nil
+	}
+	departmentsFromWeb, err := s.Source.FetchDepartments(ctx)
+	if err != nil {
+		return Department{}, fmt.Errorf("failed to get departments from web: %w", err)
+	}
+	if dept, ok := match(departmentsFromWeb); ok {
+		return dept, nil
+	}
+	return Department{}, fmt.Errorf("unknown department %q", department)
+}
+
+// ScrapeDepartment fetches every page of a department once and saves the products to the DB, regardless
+// of when it was last updated. The department can be given by ID or name.
+func (s *Scraper) ScrapeDepartment(ctx context.Context, department string) (shared.ScrapeSummary, error) {
+	summary := shared.ScrapeSummary{Store: s.StoreName()}
+	dept, err := s.findDepartment(ctx, department)
+	if err != nil {
+		return summary, err
+	}
+	summary.Departments = 1
+	for _, dp := range s.startDepartmentRun(dept) {
+		summary.Pages++
+		saved, err := s.scrapeDepartmentPage(ctx, dp)
+		if err != nil {
+			summary.FailedPages++
+			return summary, err
+		}
+		summary.Products += saved
+	}
+	dept.Updated = time.Now()
+	if err := s.SaveDepartment(dept); err != nil {
+		return summary, fmt.Errorf("failed to save department: %w", err)
+	}
+	s.Logger.Info("Scraped department", "department", dept.Description, "products", summary.Products)
+	return summary, nil
+}
+
+// RunOnce makes a single pass over every department that's due for an update, then returns. It's an
+// alternative to Run for running on a schedule. A department with pages that failed is left due, so it's
+// retried on the next pass.
+func (s *Scraper) RunOnce(ctx context.Context) (shared.ScrapeSummary, error) {
+	summary := shared.ScrapeSummary{Store: s.StoreName()}
+	if err := s.refreshDepartments(ctx); err != nil {
+		return summary, err
+	}
+	departments, err := s.dueDepartments()
+	if err != nil {
+		return summary, fmt.Errorf("failed to load departments: %w", err)
+	}
+	for _, dept := range departments {
+		summary.Departments++
+		failed := false
+		for _, dp := range s.startDepartmentRun(dept) {
+			if err := ctx.Err(); err != nil {
+				return summary, err
+			}
+			summary.Pages++
+			saved, err := s.scrapeDepartmentPage(ctx, dp)
+			if err != nil {
+				s.Logger.Error("Error scraping product list page", "departmentID", dp.departmentID, "page", dp.page, "error", err)
+				summary.FailedPages++
+				failed = true
+				continue
+			}
+			summary.Products += saved
+		}
+		if failed {
+			continue
+		}
+		dept.Updated = time.Now()
+		if err := s.SaveDepartment(dept); err != nil {
+			return summary, fmt.Errorf("failed to save department: %w", err)
+		}
+		s.Logger.Info("Updated department", "department", dept.Description)
+	}
+	if summary.FailedPages > 0 {
+		return summary, fmt.Errorf("failed to scrape %d of %d pages", summary.FailedPages, summary.Pages)
+	}
+	return summary, nil
+}
+
+// newDepartmentWorker keeps the departments in the DB up to date with the web.
+func (s *Scraper) newDepartmentWorker(ctx context.Context) {
+	for {
+		if err := s.refreshDepartments(ctx); err != nil {
+			s.Logger.Error("Error checking for new departments", "error", err)
+		}
+		// We don't need to check for departments very often.
+		select {
+		case <-time.After(DEPARTMENT_CHECK_INTERVAL):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// departmentPageUpdateQueueWorker generates a stream of departmentPage structs that are due for an update
+func (s *Scraper) departmentPageUpdateQueueWorker(ctx context.Context, output chan<- departmentPage) {
+	for {
+		departments, err := s.dueDepartments()
+		if err != nil {
+			s.Logger.Error("error loading department IDs. Trying again soon.", "error", err)
+			select {
+			case <-time.After(1 * time.Minute):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, dept := range departments {
+			s.Logger.Debug("Checking department", "ID", dept.ID, "Updated", dept.Updated)
+			for _, dp := range s.startDepartmentRun(dept) {
+				s.Logger.Debug("Adding department page to queue", "ID", dp.departmentID, "page", dp.page)
+				select {
+				case output <- dp:
+				case <-ctx.Done():
+					return
+				}
+			}
+			// Save this department back to the DB to refresh its updated time.
+			dept.Updated = time.Now()
+			if err := s.SaveDepartment(dept); err != nil {
+				s.Logger.Error("error saving department info", "error", err)
+			}
+			s.Logger.Info("Updated department", "department", dept.Description)
+		}
+		// We've done an update of all departments, so we don't need to check for new departments very often.
+		select {
+		case <-time.After(s.RefreshInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// productListPageWorker reads departmentPage structs from the input channel and scrapes each of them
+// until the context is cancelled. A page that's already been fetched is still committed.
+func (s *Scraper) productListPageWorker(ctx context.Context, input <-chan departmentPage) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case dp, ok := <-input:
+			if !ok {
+				return
+			}
+			if _, err := s.scrapeDepartmentPage(ctx, dp); err != nil {
+				s.Logger.Error("Error scraping product list page", "departmentID", dp.departmentID, "page", dp.page, "error", err)
+			}
+		}
+	}
+}
+
+// Run keeps scraping the departments as they fall due until the context is cancelled, then waits for the
+// workers to finish their in-flight pages before returning.
+func (s *Scraper) Run(ctx context.Context) {
+	departmentPageChannel := make(chan departmentPage)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.Workers, 1); i++ {
+		wg.Go(func() { s.productListPageWorker(ctx, departmentPageChannel) })
+	}
+	wg.Go(func() { s.newDepartmentWorker(ctx) })
+	wg.Go(func() { s.departmentPageUpdateQueueWorker(ctx, departmentPageChannel) })
+
+	<-ctx.Done()
+	s.Logger.Info("Exiting scheduler")
+	wg.Wait()
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// mockSource is a store's website with the given departments, each with ProductCount products priced at
+// a dollar, except the first of each page, which is free. Pages past the end of a department fail.
+type mockSource struct {
+	departments []Department
+	broken      string // A department whose pages all fail
+	mu          sync.Mutex
+	fetched     []int // The pages that have been fetched
+}
+
+func (m *mockSource) FetchDepartments(ctx context.Context) ([]Department, error) {
+	return m.departments, nil
+}
+
+func (m *mockSource) FetchProducts(ctx context.Context, departmentID string, page int) ([]Product, error) {
+	m.mu.Lock()
+	m.fetched = append(m.fetched, page)
+	m.mu.Unlock()
+	if departmentID == m.broken {
+		return nil, fmt.Errorf("%s is broken", departmentID)
+	}
+	for _, dept := range m.departments {
+		if dept.ID != departmentID {
+			continue
+		}
+		var products []Product
+		for i := (page - 1) * MOCK_PAGE_SIZE; i < min(page*MOCK_PAGE_SIZE, dept.ProductCount); i++ {
+			product := Product{ID: fmt.Sprintf("%s-%d", departmentID, i), Name: "Product", PriceCents: 100, Updated: time.Now()}
+			if i%MOCK_PAGE_SIZE == 0 {
+				product.PriceCents = 0
+			}
+			products = append(products, product)
+		}
+		if len(products) == 0 {
+			return nil, fmt.Errorf("no page %d of %s", page, departmentID)
+		}
+		return products, nil
+	}
+	return nil, fmt.Errorf("no department %s", departmentID)
+}
+
+const MOCK_PAGE_SIZE = 4
+
+func newTestScraper(t *testing.T, departments ...Department) (*Scraper, *mockSource) {
+	source := &mockSource{departments: departments}
+	return &Scraper{
+		Repository:      openTestDB(t).Repository(StoreConfig{Name: "Aldi", IDPrefix: "aldi_"}),
+		Source:          source,
+		PageSize:        MOCK_PAGE_SIZE,
+		Workers:         2,
+		MaxAge:          10 * time.Minute,
+		RefreshInterval: 20 * time.Second,
+		Logger:          slog.Default(),
+	}, source
+}
+
+type mockPriceObserver struct {
+	products []shared.ProductInfo
+}
+
+func (m *mockPriceObserver) ObservePrices(products []shared.ProductInfo) {
+	m.products = append(m.products, products...)
+}
+
+func TestRefreshDepartments(t *testing.T) {
+	s, source := newTestScraper(t,
+		Department{ID: "fruit", Description: "Fruit", ProductCount: 8},
+		Department{ID: "bakery", Description: "Bakery", ProductCount: 4})
+	s.SaveDepartment(Department{ID: "bakery", Description: "Bakery", ProductCount: 4, Updated: time.Now()})
+
+	if err := s.refreshDepartments(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	due, err := s.dueDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(due); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "fruit", due[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// A changed product count makes a department due again.
+	source.departments[1].ProductCount = 5
+	if err := s.refreshDepartments(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := s.dueDepartments(); len(due) != 2 {
+		t.Errorf("Expected both departments to be due, got %v", due)
+	}
+}
+
+func TestDepartmentPageUpdateQueueWorker(t *testing.T) {
+	s, _ := newTestScraper(t)
+	// We want to get pages from this department, updated an hour ago.
+	s.SaveDepartment(Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE * 3, Updated: time.Now().Add(-1 * time.Hour)})
+	// We don't want to get pages from this department, updated an hour in the future.
+	s.SaveDepartment(Department{ID: "vruit", Description: "Vruit", ProductCount: MOCK_PAGE_SIZE * 3, Updated: time.Now().Add(1 * time.Hour)})
+	// Nor from this one, which is excluded.
+	s.SaveDepartment(Department{ID: "liquor", Description: "Liquor", ProductCount: MOCK_PAGE_SIZE * 3, Updated: time.Now().Add(-1 * time.Hour)})
+	s.Include = func(departmentID string) bool { return departmentID != "liquor" }
+	s.MaxAge = 1 * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	departmentPageChannel := make(chan departmentPage)
+	go s.departmentPageUpdateQueueWorker(ctx, departmentPageChannel)
+
+	for pageIndex := 1; pageIndex <= 3; pageIndex++ {
+		dp := <-departmentPageChannel
+		if want, got := "fruit", dp.departmentID; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+		if want, got := pageIndex, dp.page; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+	// Now test there are no more pages waiting.
+	select {
+	case dp := <-departmentPageChannel:
+		t.Fatal("Expected no more pages, got", dp)
+	case <-time.After(1 * time.Second):
+	}
+}
+
+func TestScrapeDepartmentPage(t *testing.T) {
+	s, _ := newTestScraper(t, Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE})
+	observer := &mockPriceObserver{}
+	s.SetPriceObserver(observer)
+	s.SaveDepartment(Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE, Updated: time.Now()})
+
+	saved, err := s.scrapeDepartmentPage(context.Background(), departmentPage{departmentID: "fruit", page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The free product is skipped.
+	if want, got := MOCK_PAGE_SIZE-1, saved; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := saved, len(observer.products); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	product, err := s.GetProduct("aldi_fruit-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Fruit", product.Department; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if _, err := s.GetProduct("aldi_fruit-0"); err == nil {
+		t.Error("Expected the free product to be skipped")
+	}
+}
+
+func TestScrapeDepartmentPageFailedProduct(t *testing.T) {
+	s, _ := newTestScraper(t, Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE})
+	s.SaveDepartment(Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE, Updated: time.Now()})
+	if _, err := s.db.db.Exec(`CREATE TRIGGER broken_product BEFORE INSERT ON products WHEN NEW.productID = 'fruit-2'
+		BEGIN SELECT RAISE(ABORT, 'broken product'); END`); err != nil {
+		t.Fatal(err)
+	}
+	runID, err := s.StartScrapeRun("fruit", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.scrapeDepartmentPage(context.Background(), departmentPage{departmentID: "fruit", page: 1, runID: runID}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	// None of the page is kept, even the products saved before the failed one.
+	if count, err := s.GetTotalProductCount(); err != nil {
+		t.Fatal(err)
+	} else if want, got := 0, count; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	departments, err := s.GetDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, departments[0].LastRun.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestScrapeDepartment(t *testing.T) {
+	s, _ := newTestScraper(t, Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE * 2, Slug: "fruits"})
+
+	// It's not in the DB yet, so it's found on the web by its slug.
+	summary, err := s.ScrapeDepartment(context.Background(), "FRUITS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, summary.Pages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := (MOCK_PAGE_SIZE-1)*2, summary.Products; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if time.Since(s.LastScraped()) > time.Minute {
+		t.Errorf("Expected the last scrape time to be recorded")
+	}
+	departments, err := s.LoadDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(departments[0].Updated) > time.Minute {
+		t.Errorf("Expected the department's updated time to be refreshed")
+	}
+
+	if _, err := s.ScrapeDepartment(context.Background(), "Nonexistent"); err == nil {
+		t.Errorf("Expected an error")
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	s, source := newTestScraper(t,
+		Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE * 2},
+		Department{ID: "bakery", Description: "Bakery", ProductCount: MOCK_PAGE_SIZE})
+	source.broken = "bakery"
+
+	summary, err := s.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+	if want, got := 2, summary.Departments; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1, summary.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// The department with the failed page is left due.
+	due, err := s.dueDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(due); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "bakery", due[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestScraperScrapeRuns(t *testing.T) {
+	s, source := newTestScraper(t, Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE * 2})
+	if _, err := s.ScrapeDepartment(context.Background(), "fruit"); err != nil {
+		t.Fatal(err)
+	}
+	departments, err := s.GetDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := departments[0].LastRun
+	if run == nil {
+		t.Fatal("Expected a scrape run")
+	}
+	if want, got := 2, run.Pages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, run.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if run.Finished.IsZero() || !departments[0].LastRefreshed.Equal(run.Finished) {
+		t.Errorf("Expected the run to be finished and the department refreshed, got %v and %v", run.Finished, departments[0].LastRefreshed)
+	}
+	if run.ChangedProducts == 0 {
+		t.Errorf("Expected new products to count as changed")
+	}
+	lastRefreshed := departments[0].LastRefreshed
+
+	// Scraping the same prices again changes nothing. Ask for more pages than there are, so some fail.
+	for _, dp := range s.startDepartmentRun(Department{ID: "fruit", ProductCount: MOCK_PAGE_SIZE * 3}) {
+		s.scrapeDepartmentPage(context.Background(), dp)
+	}
+	departments, _ = s.GetDepartments()
+	run = departments[0].LastRun
+	if want, got := 1, run.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, run.ChangedProducts; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if run.Finished.IsZero() {
+		t.Errorf("Expected the run to be finished")
+	}
+	if !departments[0].LastRefreshed.Equal(lastRefreshed) {
+		t.Errorf("Expected a run with failed pages not to count as a refresh")
+	}
+	if want, got := 5, len(source.fetched); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	s, _ := newTestScraper(t,
+		Department{ID: "fruit", Description: "Fruit", ProductCount: MOCK_PAGE_SIZE * 3},
+		Department{ID: "bakery", Description: "Bakery", ProductCount: MOCK_PAGE_SIZE})
+	// The departments may not have been found by the first pass.
+	s.RefreshInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(stopped)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if count, err := s.GetTotalProductCount(); err != nil {
+			t.Fatal(err)
+		} else if count == (MOCK_PAGE_SIZE-1)*4 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Timed out waiting for the departments to be scraped")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the scraper to stop")
+	case <-stopped:
+	}
+}
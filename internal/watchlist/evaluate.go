@@ -0,0 +1,163 @@
+package watchlist
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/notify"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+// triggered decides whether a watch fires for a new price, given the last price the watch saw for the
+// product, if it has seen one.
+func triggered(watch Watch, lastPriceCents int, seen bool, priceCents int) bool {
+	switch watch.Rule {
+	case WATCH_RULE_TARGET_PRICE:
+		// Fire when the price first reaches the target, not on every update while it stays there.
+		return priceCents <= watch.TargetPriceCents && (!seen || lastPriceCents > watch.TargetPriceCents)
+	case WATCH_RULE_ANY_DROP:
+		return seen && priceCents < lastPriceCents
+	}
+	return false
+}
+
+// ObservePrices checks the watches on each product against its new price, recording and dispatching any
+// alerts. It satisfies shared.PriceObserver so the stores can call it as they commit prices.
+func (wl *Watchlist) ObservePrices(products []shared.ProductInfo) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	for _, product := range products {
+		if product.PriceCents == 0 {
+			continue
+		}
+		ids := []string{product.ID}
+		if wl.canonicalIDs != nil {
+			if canonicalID := wl.canonicalIDs.GetCanonicalID(product.ID); canonicalID != "" {
+				ids = append(ids, canonicalID)
+			}
+		}
+		for _, id := range ids {
+			if wl.watched[id] == 0 {
+				continue
+			}
+			alerts, err := wl.evaluate(id, product)
+			if err != nil {
+				wl.logger.Error("Failed to evaluate watches", "product", product.ID, "error", err)
+				continue
+			}
+			for _, alert := range alerts {
+				wl.dispatch(alert)
+			}
+		}
+	}
+}
+
+// evaluate checks every watch on the given product or canonical ID against the product's new price.
+func (wl *Watchlist) evaluate(watchedID string, product shared.ProductInfo) ([]Alert, error) {
+	var alerts []Alert
+	rows, err := wl.db.Query("SELECT watchID, user, productID, rule, targetPriceCents, created FROM watches WHERE productID = ?", watchedID)
+	if err != nil {
+		return alerts, fmt.Errorf("failed to query watches: %w", err)
+	}
+	var watches []Watch
+	for rows.Next() {
+		var watch Watch
+		if err := rows.Scan(&watch.ID, &watch.User, &watch.ProductID, &watch.Rule, &watch.TargetPriceCents, &watch.Created); err != nil {
+			rows.Close()
+			return alerts, fmt.Errorf("failed to scan watch: %w", err)
+		}
+		watches = append(watches, watch)
+	}
+	rows.Close()
+
+	tx, err := wl.db.Begin()
+	if err != nil {
+		return alerts, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, watch := range watches {
+		var lastPriceCents int
+		err := tx.QueryRow("SELECT lastPriceCents FROM watch_state WHERE watchID = ? AND productID = ?", watch.ID, product.ID).Scan(&lastPriceCents)
+		seen := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return alerts, fmt.Errorf("failed to query watch state: %w", err)
+		}
+		if seen && lastPriceCents == product.PriceCents {
+			continue
+		}
+		if triggered(watch, lastPriceCents, seen, product.PriceCents) {
+			previousPriceCents := lastPriceCents
+			if !seen {
+				previousPriceCents = product.PreviousPriceCents
+			}
+			alert := Alert{
+				WatchID:            watch.ID,
+				User:               watch.User,
+				ProductID:          product.ID,
+				Name:               product.Name,
+				Store:              product.Store,
+				Rule:               watch.Rule,
+				TargetPriceCents:   watch.TargetPriceCents,
+				PreviousPriceCents: previousPriceCents,
+				PriceCents:         product.PriceCents,
+				Triggered:          time.Now(),
+			}
+			result, err := tx.Exec(`
+				INSERT INTO alerts (watchID, user, productID, name, store, rule, targetPriceCents, previousPriceCents, priceCents, triggered)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				alert.WatchID, alert.User, alert.ProductID, alert.Name, alert.Store, alert.Rule, alert.TargetPriceCents,
+				alert.PreviousPriceCents, alert.PriceCents, alert.Triggered)
+			if err != nil {
+				return alerts, fmt.Errorf("failed to save alert: %w", err)
+			}
+			if alert.ID, err = result.LastInsertId(); err != nil {
+				return alerts, fmt.Errorf("failed to get alert ID: %w", err)
+			}
+			alerts = append(alerts, alert)
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO watch_state (watchID, productID, lastPriceCents) VALUES (?, ?, ?)",
+			watch.ID, product.ID, product.PriceCents)
+		if err != nil {
+			return alerts, fmt.Errorf("failed to save watch state: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return alerts, nil
+}
+
+// dispatch sends an alert to the user's notifiers.
+func (wl *Watchlist) dispatch(alert Alert) {
+	wl.logger.Info("Watch triggered", "user", alert.User, "product", alert.ProductID, "rule", alert.Rule, "priceCents", alert.PriceCents)
+	if wl.dispatcher == nil {
+		return
+	}
+	title := fmt.Sprintf("%s is down to $%.2f at %s", alert.Name, float64(alert.PriceCents)/100, alert.Store)
+	message := fmt.Sprintf("%s is now $%.2f", alert.Name, float64(alert.PriceCents)/100)
+	if alert.PreviousPriceCents != 0 {
+		message = fmt.Sprintf("%s was $%.2f, now $%.2f", alert.Name, float64(alert.PreviousPriceCents)/100, float64(alert.PriceCents)/100)
+	}
+	if alert.Rule == WATCH_RULE_TARGET_PRICE {
+		message += fmt.Sprintf(", at or below your target of $%.2f", float64(alert.TargetPriceCents)/100)
+	}
+	event := notify.Event{
+		Type:               notify.EVENT_TYPE_WATCHLIST_ALERT,
+		Title:              title,
+		Message:            message,
+		User:               alert.User,
+		ProductID:          alert.ProductID,
+		Name:               alert.Name,
+		Store:              alert.Store,
+		PreviousPriceCents: alert.PreviousPriceCents,
+		PriceCents:         alert.PriceCents,
+		Timestamp:          alert.Triggered,
+	}
+	if alert.PreviousPriceCents != 0 {
+		event.ChangeCents = alert.PriceCents - alert.PreviousPriceCents
+		event.ChangePercent = float64(event.ChangeCents) * 100 / float64(alert.PreviousPriceCents)
+	}
+	wl.dispatcher.Send(event)
+}
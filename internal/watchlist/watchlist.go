@@ -0,0 +1,286 @@
+package watchlist
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tjhowse/aus_grocery_price_database/internal/migrate"
+	"github.com/tjhowse/aus_grocery_price_database/internal/notify"
+)
+
+const DB_SCHEMA_VERSION = 1
+
+// The schema version initBlankDB creates. DBs older than this can't be migrated, so they're backed up
+// and recreated.
+const DB_BASELINE_SCHEMA_VERSION = 1
+
+// DB_SCHEMA brings the DB up to DB_SCHEMA_VERSION. To change the schema add a migration to the end and bump
+// DB_SCHEMA_VERSION to match.
+var DB_SCHEMA = migrate.Schema{
+	Version:         DB_SCHEMA_VERSION,
+	BaselineVersion: DB_BASELINE_SCHEMA_VERSION,
+	Baseline:        initBlankDB,
+}
+
+// Alert when the price falls to or below the watch's target price.
+const WATCH_RULE_TARGET_PRICE = "target_price"
+
+// Alert whenever the price falls.
+const WATCH_RULE_ANY_DROP = "any_drop"
+
+var ErrInvalidWatch = errors.New("invalid watch")
+var ErrWatchMissing = errors.New("no watch found")
+
+// Watch is a user's interest in the price of a product, or of every product matched to a canonical product.
+type Watch struct {
+	ID               int64
+	User             string
+	ProductID        string // A store product ID or a canonical ID
+	Rule             string
+	TargetPriceCents int
+	Created          time.Time
+}
+
+// Alert is a record of a watch being triggered by a price change.
+type Alert struct {
+	ID                 int64
+	WatchID            int64
+	User               string
+	ProductID          string // The store product whose price triggered the watch
+	Name               string
+	Store              string
+	Rule               string
+	TargetPriceCents   int
+	PreviousPriceCents int
+	PriceCents         int
+	Triggered          time.Time
+}
+
+// canonicalIDGetter looks up which canonical product, if any, a store product has been matched to.
+type canonicalIDGetter interface {
+	GetCanonicalID(productID string) string
+}
+
+// Dispatcher delivers alerts to the user, E.G. a notify.Notifier.
+type Dispatcher interface {
+	Send(event notify.Event)
+}
+
+// Watchlist keeps every user's watches and checks them against new prices as the stores commit them.
+type Watchlist struct {
+	db           *sql.DB
+	canonicalIDs canonicalIDGetter
+	dispatcher   Dispatcher
+	watched      map[string]int // How many watches there are for each product or canonical ID
+	mu           sync.Mutex
+	logger       *slog.Logger
+}
+
+// Init opens the watchlist database. The canonical ID getter and dispatcher may be nil.
+func (wl *Watchlist) Init(dbPath string, canonicalIDs canonicalIDGetter, dispatcher Dispatcher) error {
+	wl.logger = slog.With("component", "watchlist")
+	wl.canonicalIDs = canonicalIDs
+	wl.dispatcher = dispatcher
+	if err := wl.initDB(dbPath); err != nil {
+		return err
+	}
+	return wl.loadWatched()
+}
+
+// initBlankDB creates the schema at DB_BASELINE_SCHEMA_VERSION. Don't change it, add a migration to
+// DB_SCHEMA instead.
+func initBlankDB(tx *sql.Tx) error {
+	_, err := tx.Exec(`	CREATE TABLE IF NOT EXISTS watches
+						(	watchID INTEGER PRIMARY KEY AUTOINCREMENT,
+							user TEXT,
+							productID TEXT,
+							rule TEXT,
+							targetPriceCents INTEGER,
+							created DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS watches_product ON watches (productID)")
+	if err != nil {
+		return err
+	}
+	// The last price each watch saw for each product, so a watch only fires once per change.
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS watch_state
+						(	watchID INTEGER,
+							productID TEXT,
+							lastPriceCents INTEGER,
+							PRIMARY KEY (watchID, productID)
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`	CREATE TABLE IF NOT EXISTS alerts
+						(	alertID INTEGER PRIMARY KEY AUTOINCREMENT,
+							watchID INTEGER,
+							user TEXT,
+							productID TEXT,
+							name TEXT,
+							store TEXT,
+							rule TEXT,
+							targetPriceCents INTEGER,
+							previousPriceCents INTEGER,
+							priceCents INTEGER,
+							triggered DATETIME
+						)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS alerts_user ON alerts (user, triggered)")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func openDB(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// initDB opens the database, bringing it up to the current schema.
+func (wl *Watchlist) initDB(dbPath string) error {
+	var err error
+	wl.db, err = migrate.Open(dbPath, openDB, DB_SCHEMA, wl.logger)
+	return err
+}
+
+// loadWatched caches the set of watched IDs so most price updates can be ignored without touching the DB.
+func (wl *Watchlist) loadWatched() error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	wl.watched = make(map[string]int)
+	rows, err := wl.db.Query("SELECT productID, COUNT(*) FROM watches GROUP BY productID")
+	if err != nil {
+		return fmt.Errorf("failed to query watched products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return fmt.Errorf("failed to scan watched product: %w", err)
+		}
+		wl.watched[id] = count
+	}
+	return nil
+}
+
+// AddWatch registers a user's interest in a product or canonical product. The target price is only used by
+// the target price rule.
+func (wl *Watchlist) AddWatch(user, productID, rule string, targetPriceCents int) (Watch, error) {
+	watch := Watch{User: user, ProductID: productID, Rule: rule, TargetPriceCents: targetPriceCents, Created: time.Now()}
+	switch {
+	case user == "" || productID == "":
+		return watch, fmt.Errorf("%w: a user and product ID are required", ErrInvalidWatch)
+	case rule == WATCH_RULE_TARGET_PRICE && targetPriceCents <= 0:
+		return watch, fmt.Errorf("%w: a target price is required", ErrInvalidWatch)
+	case rule == WATCH_RULE_ANY_DROP:
+		watch.TargetPriceCents = 0
+	case rule != WATCH_RULE_TARGET_PRICE:
+		return watch, fmt.Errorf("%w: unknown rule `%s`", ErrInvalidWatch, rule)
+	}
+
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	result, err := wl.db.Exec("INSERT INTO watches (user, productID, rule, targetPriceCents, created) VALUES (?, ?, ?, ?, ?)",
+		watch.User, watch.ProductID, watch.Rule, watch.TargetPriceCents, watch.Created)
+	if err != nil {
+		return watch, fmt.Errorf("failed to save watch: %w", err)
+	}
+	watch.ID, err = result.LastInsertId()
+	if err != nil {
+		return watch, fmt.Errorf("failed to get watch ID: %w", err)
+	}
+	wl.watched[watch.ProductID]++
+	return watch, nil
+}
+
+// RemoveWatch deletes one of a user's watches.
+func (wl *Watchlist) RemoveWatch(user string, watchID int64) error {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+	var productID string
+	err := wl.db.QueryRow("SELECT productID FROM watches WHERE watchID = ? AND user = ?", watchID, user).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrWatchMissing
+	} else if err != nil {
+		return fmt.Errorf("failed to query watch: %w", err)
+	}
+	tx, err := wl.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM watches WHERE watchID = ?", watchID); err != nil {
+		return fmt.Errorf("failed to delete watch: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM watch_state WHERE watchID = ?", watchID); err != nil {
+		return fmt.Errorf("failed to delete watch state: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	wl.watched[productID]--
+	if wl.watched[productID] <= 0 {
+		delete(wl.watched, productID)
+	}
+	return nil
+}
+
+// GetWatches returns all of a user's watches, oldest first.
+func (wl *Watchlist) GetWatches(user string) ([]Watch, error) {
+	var watches []Watch
+	rows, err := wl.db.Query("SELECT watchID, user, productID, rule, targetPriceCents, created FROM watches WHERE user = ? ORDER BY watchID", user)
+	if err != nil {
+		return watches, fmt.Errorf("failed to query watches: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var watch Watch
+		if err := rows.Scan(&watch.ID, &watch.User, &watch.ProductID, &watch.Rule, &watch.TargetPriceCents, &watch.Created); err != nil {
+			return watches, fmt.Errorf("failed to scan watch: %w", err)
+		}
+		watches = append(watches, watch)
+	}
+	return watches, nil
+}
+
+// GetAlerts returns up to limit of a user's most recent alerts, newest first.
+func (wl *Watchlist) GetAlerts(user string, limit int) ([]Alert, error) {
+	var alerts []Alert
+	rows, err := wl.db.Query(`
+		SELECT alertID, watchID, user, productID, name, store, rule, targetPriceCents, previousPriceCents, priceCents, triggered
+		FROM alerts
+		WHERE user = ?
+		ORDER BY triggered DESC, alertID DESC
+		LIMIT ?`, user, limit)
+	if err != nil {
+		return alerts, fmt.Errorf("failed to query alerts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alert Alert
+		err := rows.Scan(&alert.ID, &alert.WatchID, &alert.User, &alert.ProductID, &alert.Name, &alert.Store, &alert.Rule,
+			&alert.TargetPriceCents, &alert.PreviousPriceCents, &alert.PriceCents, &alert.Triggered)
+		if err != nil {
+			return alerts, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
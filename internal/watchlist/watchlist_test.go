@@ -0,0 +1,161 @@
+package watchlist
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/notify"
+	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+)
+
+type MockDispatcher struct {
+	events []notify.Event
+}
+
+func (m *MockDispatcher) Send(event notify.Event) {
+	m.events = append(m.events, event)
+}
+
+type MockCanonicalIDGetter struct {
+	canonicalIDs map[string]string
+}
+
+func (m *MockCanonicalIDGetter) GetCanonicalID(productID string) string {
+	return m.canonicalIDs[productID]
+}
+
+func getInitialisedWatchlist(t *testing.T) (*Watchlist, *MockDispatcher) {
+	dispatcher := &MockDispatcher{}
+	wl := &Watchlist{}
+	err := wl.Init(":memory:", &MockCanonicalIDGetter{canonicalIDs: map[string]string{"coles_id_1": "canonical_1"}}, dispatcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The in-memory DB is shared between tests, so start from a clean slate.
+	for _, table := range []string{"watches", "watch_state", "alerts"} {
+		if _, err := wl.db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wl.loadWatched(); err != nil {
+		t.Fatal(err)
+	}
+	return wl, dispatcher
+}
+
+func observe(wl *Watchlist, id string, priceCents int) {
+	wl.ObservePrices([]shared.ProductInfo{{ID: id, Name: "Milk", Store: "Woolworths", PriceCents: priceCents}})
+}
+
+func TestAddWatch(t *testing.T) {
+	wl, _ := getInitialisedWatchlist(t)
+	for _, test := range []struct {
+		user, productID, rule string
+		target                int
+	}{
+		{"", "woolworths_sku_1", WATCH_RULE_ANY_DROP, 0},
+		{"alice", "", WATCH_RULE_ANY_DROP, 0},
+		{"alice", "woolworths_sku_1", WATCH_RULE_TARGET_PRICE, 0},
+		{"alice", "woolworths_sku_1", "whenever", 0},
+	} {
+		if _, err := wl.AddWatch(test.user, test.productID, test.rule, test.target); !errors.Is(err, ErrInvalidWatch) {
+			t.Errorf("Expected ErrInvalidWatch for %+v, got %v", test, err)
+		}
+	}
+
+	watch, err := wl.AddWatch("alice", "woolworths_sku_1", WATCH_RULE_TARGET_PRICE, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watches, err := wl.GetWatches("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(watches); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := 300, watches[0].TargetPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	if err := wl.RemoveWatch("bob", watch.ID); !errors.Is(err, ErrWatchMissing) {
+		t.Errorf("Expected ErrWatchMissing, got %v", err)
+	}
+	if err := wl.RemoveWatch("alice", watch.ID); err != nil {
+		t.Fatal(err)
+	}
+	watches, err = wl.GetWatches("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(watches); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestTargetPriceWatch(t *testing.T) {
+	wl, dispatcher := getInitialisedWatchlist(t)
+	if _, err := wl.AddWatch("alice", "woolworths_sku_1", WATCH_RULE_TARGET_PRICE, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	observe(wl, "woolworths_sku_1", 400)
+	observe(wl, "woolworths_sku_1", 300)
+	// Staying at or below the target doesn't fire again.
+	observe(wl, "woolworths_sku_1", 300)
+	observe(wl, "woolworths_sku_1", 280)
+	// Rising above and coming back down does.
+	observe(wl, "woolworths_sku_1", 450)
+	observe(wl, "woolworths_sku_1", 250)
+	// Other products don't.
+	observe(wl, "woolworths_sku_2", 100)
+
+	if want, got := 2, len(dispatcher.events); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "Milk was $4.00, now $3.00, at or below your target of $3.00", dispatcher.events[0].Message; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "alice", dispatcher.events[0].User; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	alerts, err := wl.GetAlerts("alice", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 2, len(alerts); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	// Newest first.
+	if want, got := 250, alerts[0].PriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 450, alerts[0].PreviousPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+func TestAnyDropWatch(t *testing.T) {
+	wl, dispatcher := getInitialisedWatchlist(t)
+	if _, err := wl.AddWatch("bob", "canonical_1", WATCH_RULE_ANY_DROP, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first price seen is the baseline.
+	observe(wl, "coles_id_1", 400)
+	observe(wl, "coles_id_1", 390)
+	observe(wl, "coles_id_1", 390)
+	observe(wl, "coles_id_1", 420)
+	observe(wl, "coles_id_1", 380)
+
+	if want, got := 2, len(dispatcher.events); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	if want, got := "coles_id_1", dispatcher.events[0].ProductID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := -10, dispatcher.events[0].ChangeCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
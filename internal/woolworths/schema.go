@@ -274,6 +274,12 @@ type buyAction struct {
 	Target                    interface{} `json:"target"`
 }
 
+// multibuyData is a multibuy offer from a product tag, E.G. 2 for $6.
+type multibuyData struct {
+	Quantity int     `json:"Quantity"`
+	Price    float64 `json:"Price"`
+}
+
 type productListPageProduct struct {
 	TileID                    int             `json:"TileID"`
 	Stockcode                 int             `json:"Stockcode"`
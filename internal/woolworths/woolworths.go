@@ -1,15 +1,15 @@
 package woolworths
 
 import (
-	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 	"golang.org/x/time/rate"
 )
 
@@ -19,74 +19,27 @@ const DEFAULT_LISTING_PAGE_CHECK_INTERVAL = 1 * time.Minute
 
 // Woolworths satisfies the ProductInfoGetter interface to provide a stream of product information from Woolworths.
 type Woolworths struct {
-	baseURL                   string
-	client                    *shared.RLHTTPClient
-	cookieJar                 *cookiejar.Jar // TODO This might not be threadsafe.
-	db                        *sql.DB
-	productMaxAge             time.Duration
-	listingPageUpdateInterval time.Duration
-	filterDepartments         bool // These are used to limit the departments and products for gradual testing.
-	filteredDepartmentIDsSet  map[departmentID]bool
-	logger                    *slog.Logger
+	baseURL                  string
+	client                   *shared.RLHTTPClient
+	cookieJar                *cookiejar.Jar // TODO This might not be threadsafe.
+	filterDepartments        bool           // These are used to limit the departments and products for gradual testing.
+	filteredDepartmentIDsSet map[departmentID]bool
+	logger                   *slog.Logger
+	*store.Scraper
 }
 
-// GetSharedProductsUpdatedAfter provides a list of product IDs that have been updated since the given time
-func (w *Woolworths) GetSharedProductsUpdatedAfter(t time.Time, count int) ([]shared.ProductInfo, error) {
-	var productIDs []shared.ProductInfo
-	var deptDescription sql.NullString
-	rows, err := w.db.Query(`
-		SELECT
-			productID,
-			products.name,
-			products.description,
-			departments.description,
-			priceCents,
-			previousPriceCents,
-			weightGrams,
-			products.updated
-		FROM
-			products
-			LEFT JOIN departments ON products.departmentID = departments.departmentID
-		WHERE products.updated > ? AND name != '' LIMIT ?`, t, count)
-	if err != nil {
-		return productIDs, fmt.Errorf("failed to query productIDs: %w", err)
-	}
-	for rows.Next() {
-		var product shared.ProductInfo
-		err = rows.Scan(
-			&product.ID,
-			&product.Name,
-			&product.Description,
-			&deptDescription,
-			&product.PriceCents,
-			&product.PreviousPriceCents,
-			&product.WeightGrams,
-			&product.Timestamp)
-		if err != nil {
-			return productIDs, fmt.Errorf("failed to scan productID: %w", err)
-		}
-		if deptDescription.Valid {
-			product.Department = deptDescription.String
-		}
-		product.ID = WOOLWORTHS_ID_PREFIX + product.ID
-		product.Store = "Woolworths"
-		productIDs = append(productIDs, product)
+// describeProduct reads the brand and size out of a product's raw JSON.
+func describeProduct(rawJSON []byte) (string, string, error) {
+	var info productListPageProduct
+	if err := json.Unmarshal(rawJSON, &info); err != nil {
+		return "", "", err
 	}
-	return productIDs, nil
-}
-
-// GetTotalProductCount returns the total number of products in the database
-func (w *Woolworths) GetTotalProductCount() (int, error) {
-	var count int
-	err := w.db.QueryRow("SELECT COUNT(*) FROM products").Scan(&count)
-	if err != nil {
-		return 0, fmt.Errorf("failed to query product count: %w", err)
-	}
-	return count, nil
+	brand, _ := info.Brand.(string)
+	return brand, info.PackageSize, nil
 }
 
 // Init sets up the Woolworths struct with the given parameters
-func (w *Woolworths) Init(baseURL string, dbPath string, productMaxAge time.Duration) error {
+func (w *Woolworths) Init(baseURL string, db *store.DB, productMaxAge time.Duration) error {
 	var err error
 
 	w.logger = slog.With("store", "Woolworths")
@@ -105,11 +58,20 @@ func (w *Woolworths) Init(baseURL string, dbPath string, productMaxAge time.Dura
 			Timeout: 30 * time.Second,
 		},
 		Ratelimiter: rate.NewLimiter(rate.Every(100*time.Millisecond), 1),
+		Store:       "Woolworths",
 	}
-	w.productMaxAge = productMaxAge
-	err = w.initDB(dbPath)
-	if err != nil {
-		return err
+	w.Scraper = &store.Scraper{
+		Repository: db.Repository(store.StoreConfig{
+			Name:            "Woolworths",
+			IDPrefix:        WOOLWORTHS_ID_PREFIX,
+			DescribeProduct: describeProduct,
+		}),
+		Source:          w,
+		PageSize:        PRODUCTS_PER_PAGE,
+		Workers:         PRODUCT_INFO_WORKER_COUNT,
+		MaxAge:          productMaxAge,
+		RefreshInterval: DEFAULT_LISTING_PAGE_CHECK_INTERVAL,
+		Logger:          w.logger,
 	}
 	w.filteredDepartmentIDsSet = map[departmentID]bool{
 		"1-E5BEE36E": true, // Fruit & Veg
@@ -128,6 +90,5 @@ func (w *Woolworths) Init(baseURL string, dbPath string, productMaxAge time.Dura
 		// "1_B63CF9E":  true, // Front of store
 
 	}
-	w.listingPageUpdateInterval = DEFAULT_LISTING_PAGE_CHECK_INTERVAL
 	return nil
 }
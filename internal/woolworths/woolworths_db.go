@@ -1,261 +1,150 @@
 package woolworths
 
 import (
-	"database/sql"
-	"fmt"
-	"os"
-	"time"
+	"encoding/json"
+	"math"
 
 	"github.com/shopspring/decimal"
 	"github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 )
 
-const DB_SCHEMA_VERSION = 7
-
-// Initialises the DB with the schema. Note you must bump the DB_SCHEMA_VERSION
-// constant if you change the schema.
-func (w *Woolworths) initBlankDB() error {
-
-	// Drop all tables
-	for _, table := range []string{"schema", "departments", "products"} {
-		// Mildly confused by why this doesn't work? TODO investigate
-		// _, err := w.db.Exec("DROP TABLE IF EXISTS ?", table)
-		_, err := w.db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
-		if err != nil {
-			return err
+// calcUnitPrice normalises the cup price to cents per kilogram, litre or item, falling back to the unit weight.
+func calcUnitPrice(productInfo woolworthsProductInfo) (float64, string, error) {
+	if productInfo.Info.HasCupPrice && productInfo.Info.CupPrice > 0 {
+		unitPriceCents, unitType, err := shared.NormaliseUnitPrice(productInfo.Info.CupPrice*100, productInfo.Info.CupMeasure)
+		if err == nil {
+			return unitPriceCents, unitType, nil
 		}
 	}
-
-	_, err := w.db.Exec("CREATE TABLE IF NOT EXISTS schema (version INTEGER PRIMARY KEY)")
-	if err != nil {
-		return err
-	}
-	_, err = w.db.Exec("INSERT INTO schema (version) VALUES (?)", DB_SCHEMA_VERSION)
-	if err != nil {
-		return err
-	}
-	_, err = w.db.Exec("CREATE TABLE IF NOT EXISTS departments (departmentID TEXT UNIQUE, description TEXT, productCount INTEGER, updated DATETIME)")
-	if err != nil {
-		return err
-	}
-	_, err =
-		w.db.Exec(`	CREATE TABLE IF NOT EXISTS products
-						(	productID TEXT UNIQUE,
-							name TEXT,
-							description TEXT,
-							barcode TEXT,
-							priceCents INTEGER,
-							previousPriceCents INTEGER,
-							weightGrams INTEGER,
-							productJSON TEXT,
-							departmentID TEXT DEFAULT "",
-							updated DATETIME
-						)`)
-	if err != nil {
-		return err
-	}
-	return nil
+	return shared.UnitPriceFromWeight(int(productInfo.Info.Price.Mul(decimal.NewFromInt(100)).IntPart()), productInfo.Info.UnitWeightInGrams)
 }
 
-// backupDB moves the specified DB to the same directory with an ISO8601 timestamp and the schema
-// number prepended to the filename.
-func (w *Woolworths) backupDB(dbPath string, oldSchema int) error {
-	backupName := fmt.Sprintf("%s.%d.%s", dbPath, oldSchema, time.Now().Format("2006-01-02T15:04:05"))
-	err := os.Rename(dbPath, backupName)
+// productFromInfo maps a product from the web to how it's kept in the DB.
+func (w *Woolworths) productFromInfo(productInfo woolworthsProductInfo) store.Product {
+	unitPriceCents, unitType, err := calcUnitPrice(productInfo)
 	if err != nil {
-		return fmt.Errorf("failed to backup existing DB: %w", err)
+		w.logger.Debug("Couldn't calculate unit price", "productID", productInfo.ID, "error", err)
+	}
+	return store.Product{
+		ID:             string(productInfo.ID),
+		Name:           productInfo.Info.DisplayName,
+		Description:    productInfo.Info.Description,
+		Barcode:        productInfo.Info.Barcode,
+		PriceCents:     int(productInfo.Info.Price.Mul(decimal.NewFromInt(100)).IntPart()),
+		WeightGrams:    productInfo.Info.UnitWeightInGrams,
+		UnitPriceCents: unitPriceCents,
+		UnitType:       unitType,
+		Promotion:      promotionFromProductInfo(productInfo),
+		OnSpecial:      productInfo.Info.IsOnSpecial,
+		RawJSON:        productInfo.RawJSON,
+		DepartmentID:   string(productInfo.departmentID),
+		Updated:        productInfo.Updated,
 	}
-	w.logger.Info("Backed up old DB", "old", dbPath, "new", backupName)
-	return nil
 }
 
-func openDB(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?cache=shared")
-	if err != nil {
-		return nil, err
-	}
-	db.SetMaxOpenConns(1)
-	return db, nil
+// Saves product info to the database. It returns whether the product is new or its price or promotion
+// changed.
+func (w *Woolworths) saveProductInfo(tx *store.Tx, productInfo woolworthsProductInfo) (bool, error) {
+	return tx.SaveProduct(w.productFromInfo(productInfo))
 }
 
-func (w *Woolworths) initDB(dbPath string) error {
-	var err error
-	w.db, err = openDB(dbPath)
-	if err != nil {
-		return fmt.Errorf("failed to open DB: %w", err)
+// parseMultibuyData decodes the multibuy offer from a product tag, if there is one.
+func parseMultibuyData(data interface{}) (multibuyData, bool) {
+	var multibuy multibuyData
+	if data == nil {
+		return multibuy, false
 	}
-	var version int
-	err = w.db.QueryRow("SELECT version FROM schema").Scan(&version)
-
-	if err != nil || version != DB_SCHEMA_VERSION {
-		w.logger.Warn("DB schema mismatch", "path", dbPath, "currentVersion", DB_SCHEMA_VERSION, "detectedVersion", version)
-
-		if version != 0 {
-			// If we detected an old schema, backup the DB and create a new one.
-			err = w.db.Close()
-			if err != nil {
-				return fmt.Errorf("failed to close existing DB before backing it up: %w", err)
-			}
-			err = w.backupDB(dbPath, version)
-			if err != nil {
-				return fmt.Errorf("failed to backup existing DB: %w", err)
-			}
-
-			// Open a new DB
-			w.db, err = openDB(dbPath)
-			if err != nil {
-				return fmt.Errorf("failed to open DB: %w", err)
-			}
-		}
-
-		// Create the schema
-		err := w.initBlankDB()
-		if err != nil {
-			return fmt.Errorf("failed to create blank DB: %w", err)
-		} else {
-			w.logger.Info("New blank DB created")
-		}
-	}
-	return nil
-}
-
-// Saves product info to the database
-func (w *Woolworths) saveProductInfo(tx *sql.Tx, productInfo woolworthsProductInfo) error {
-	var err error
-	var result sql.Result
-
-	result, err = tx.Exec(`
-			INSERT INTO products (productID, name, description, barcode, priceCents, previousPriceCents, weightGrams, productJSON, departmentID, updated)
-			VALUES (?, ?, ?, ?, ?, 0, ?, ?, ?, ?)
-			ON CONFLICT(productID) DO UPDATE SET
-				productID = excluded.productID,
-				name = excluded.name,
-				description = excluded.description,
-				barcode = excluded.barcode,
-				priceCents = excluded.priceCents,
-				previousPriceCents = priceCents,
-				weightGrams = excluded.weightGrams,
-				productJSON = excluded.productJSON,
-				departmentID = excluded.departmentID,
-				updated = excluded.updated`,
-		productInfo.ID, productInfo.Info.DisplayName, productInfo.Info.Description, productInfo.Info.Barcode,
-		productInfo.Info.Price.Mul(decimal.NewFromInt(100)).IntPart(),
-		productInfo.Info.UnitWeightInGrams, productInfo.RawJSON, productInfo.departmentID, productInfo.Updated)
-
+	encoded, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to update product info: %w", err)
+		return multibuy, false
 	}
-	if rowsAffected, err := result.RowsAffected(); err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	} else if rowsAffected == 0 {
-		w.logger.Warn("Product info not updated.")
+	if err := json.Unmarshal(encoded, &multibuy); err != nil || multibuy.Quantity == 0 {
+		return multibuy, false
 	}
+	return multibuy, true
+}
 
-	return nil
+// promotionFromProductInfo extracts the promotion the product is currently on, if any.
+func promotionFromProductInfo(productInfo woolworthsProductInfo) shared.Promotion {
+	info := productInfo.Info
+	promotion := shared.Promotion{
+		MemberOnly: info.CentreTag.IsRegisteredRewardCardPromotion || info.CentreTag.MemberPriceData != nil,
+	}
+	// Woolworths report the current price as the was price when there's no discount.
+	if wasPriceCents := int(math.Round(info.WasPrice * 100)); wasPriceCents > int(info.Price.Mul(decimal.NewFromInt(100)).IntPart()) {
+		promotion.WasPriceCents = wasPriceCents
+	}
+	multibuy, hasMultibuy := parseMultibuyData(info.CentreTag.MultibuyData)
+	if hasMultibuy {
+		promotion.MultibuyQuantity = multibuy.Quantity
+		promotion.MultibuyPriceCents = int(math.Round(multibuy.Price * 100))
+	}
+	switch {
+	case info.IsHalfPrice:
+		promotion.Type = shared.PROMOTION_TYPE_HALF_PRICE
+	case info.IsOnSpecial:
+		promotion.Type = shared.PROMOTION_TYPE_SPECIAL
+	case hasMultibuy:
+		promotion.Type = shared.PROMOTION_TYPE_MULTIBUY
+	}
+	return promotion
 }
 
 // Saves product info to the database
 func (w *Woolworths) saveProductInfoNoTx(productInfo woolworthsProductInfo) error {
-	var err error
+	return w.SaveProducts([]store.Product{w.productFromInfo(productInfo)})
+}
 
-	tx, err := w.db.Begin()
-	defer tx.Rollback()
-	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
-	}
-	w.saveProductInfo(tx, productInfo)
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+// departmentFromInfo maps a department from the web to how it's kept in the DB.
+func departmentFromInfo(departmentInfo departmentInfo) store.Department {
+	return store.Department{
+		ID:           string(departmentInfo.NodeID),
+		Description:  departmentInfo.Description,
+		ProductCount: departmentInfo.ProductCount,
+		Updated:      departmentInfo.Updated,
 	}
-	return nil
 }
 
-// Saves product info to the database
+// Saves department info to the database
 func (w *Woolworths) saveDepartment(departmentInfo departmentInfo) error {
-	var err error
-	var result sql.Result
-
-	result, err = w.db.Exec(`
-		INSERT INTO departments (departmentID, description, productCount, updated)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(departmentID) DO UPDATE SET
-			departmentID = excluded.departmentID,
-			description = excluded.description,
-			productCount = excluded.productCount,
-			updated = excluded.updated`,
-		departmentInfo.NodeID, departmentInfo.Description, departmentInfo.ProductCount, departmentInfo.Updated)
-
-	if err != nil {
-		return fmt.Errorf("failed to update department ID info: %w", err)
-	}
-	if rowsAffected, err := result.RowsAffected(); err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
-	} else if rowsAffected == 0 {
-		w.logger.Warn("Department not upserted")
-	}
-
-	return nil
+	return w.SaveDepartment(departmentFromInfo(departmentInfo))
 }
 
 // loadProductInfo loads cached extended product info from the database
 func (w *Woolworths) loadProductInfo(productID productID) (woolworthsProductInfo, error) {
 	var wProdInfo woolworthsProductInfo
-	var deptDescription sql.NullString
-	row := w.db.QueryRow(`
-	SELECT
-		productID,
-		name,
-		products.description,
-		barcode,
-		priceCents,
-		previousPriceCents,
-		weightGrams,
-		productJSON,
-		products.departmentID,
-		departments.description,
-		products.updated
-	FROM
-		products
-		LEFT JOIN departments ON products.departmentID = departments.departmentID
-	WHERE productID = ? LIMIT 1`, productID)
-	err := row.Scan(
-		&wProdInfo.ID,
-		&wProdInfo.Info.DisplayName,
-		&wProdInfo.Info.Description,
-		&wProdInfo.Info.Barcode,
-		&wProdInfo.Info.Price,
-		&wProdInfo.PreviousPrice,
-		&wProdInfo.Info.UnitWeightInGrams,
-		&wProdInfo.RawJSON,
-		&wProdInfo.departmentID,
-		&deptDescription, // This value comes from a join, so it might be NULL.
-		&wProdInfo.Updated)
+	product, err := w.LoadProduct(string(productID))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return wProdInfo, shared.ErrProductMissing
-		}
-		return wProdInfo, fmt.Errorf("failed to query existing product info: %w", err)
-	}
-	if deptDescription.Valid {
-		wProdInfo.departmentDescription = deptDescription.String
-	}
+		return wProdInfo, err
+	}
+	wProdInfo.ID = productID
+	wProdInfo.Info.DisplayName = product.Name
+	wProdInfo.Info.Description = product.Description
+	wProdInfo.Info.Barcode = product.Barcode
+	wProdInfo.Info.Price = decimal.NewFromInt(int64(product.PriceCents))
+	wProdInfo.PreviousPrice = decimal.NewFromInt(int64(product.PreviousPriceCents))
+	wProdInfo.Info.UnitWeightInGrams = product.WeightGrams
+	wProdInfo.RawJSON = product.RawJSON
+	wProdInfo.departmentID = departmentID(product.DepartmentID)
+	wProdInfo.departmentDescription = product.DepartmentDescription
+	wProdInfo.Updated = product.Updated
 	return wProdInfo, nil
 }
 
 func (w *Woolworths) loadDepartmentInfoList() ([]departmentInfo, error) {
 	var departmentInfos []departmentInfo
-	rows, err := w.db.Query("SELECT departmentID, description, productCount, updated FROM departments")
+	departments, err := w.LoadDepartments()
 	if err != nil {
-		return departmentInfos, fmt.Errorf("failed to query departmentIDs: %w", err)
-	}
-	for rows.Next() {
-		var deptInfo departmentInfo
-		err = rows.Scan(&deptInfo.NodeID, &deptInfo.Description, &deptInfo.ProductCount, &deptInfo.Updated)
-		if err != nil {
-			return departmentInfos, fmt.Errorf("failed to scan departmentID: %w", err)
-		}
-		departmentInfos = append(departmentInfos, deptInfo)
+		return departmentInfos, err
+	}
+	for _, dept := range departments {
+		departmentInfos = append(departmentInfos, departmentInfo{
+			NodeID:       departmentID(dept.ID),
+			Description:  dept.Description,
+			ProductCount: dept.ProductCount,
+			Updated:      dept.Updated,
+		})
 	}
 	return departmentInfos, nil
 }
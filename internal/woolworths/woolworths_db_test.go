@@ -1,10 +1,9 @@
 package woolworths
 
 import (
-	"log/slog"
-	"os"
-	"path/filepath"
-	"strconv"
+	"context"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -70,21 +69,9 @@ func TestDepartmentInfo(t *testing.T) {
 	}
 }
 
-func TestDBFail(t *testing.T) {
-
+func TestGetSharedProductsChangedAfter(t *testing.T) {
 	w := Woolworths{}
-	err := w.Init("", "/zingabingo/db.db3", 5*time.Second)
-	if err == nil {
-		t.Fatal("Expected an error")
-	}
-	if want, got := "failed to create blank DB: unable to open database file: no such file or directory", err.Error(); want != got {
-		t.Errorf("Expected %s, got %s", want, got)
-	}
-}
-
-func TestGetSharedProductsUpdatedAfter(t *testing.T) {
-	w := Woolworths{}
-	w.Init(woolworthsServer.URL, ":memory:", 5*time.Second)
+	w.Init(woolworthsServer.URL, openTestDB(), 5*time.Second)
 	w.filterDepartments = false
 	var infoList []woolworthsProductInfo
 	infoList = append(infoList, woolworthsProductInfo{ID: "123455", Info: productListPageProduct{DisplayName: "1", Price: decimal.NewFromFloat(1.5)}, Updated: time.Now().Add(-5 * time.Minute)})
@@ -100,10 +87,15 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 	for _, info := range infoList {
 		w.saveProductInfoNoTx(info)
 	}
-	productIDs, err := w.GetSharedProductsUpdatedAfter(time.Now().Add(-2*time.Minute), 10)
+	// The products before the cursor, the first save of the duplicate and the product with a blank name
+	// are all skipped.
+	productIDs, changeSeq, err := w.GetSharedProductsChangedAfter(3, 10)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if want, got := int64(7), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
 	if want, got := 2, len(productIDs); want != got {
 		t.Fatalf("Expected %d products, got %d", want, got)
 	}
@@ -119,10 +111,13 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 	if want, got := 510, productIDs[1].PriceCents; want != got {
 		t.Errorf("Expected %v, got %v", want, got)
 	}
-	productIDs, err = w.GetSharedProductsUpdatedAfter(time.Now().Add(-2*time.Minute), 1)
+	productIDs, changeSeq, err = w.GetSharedProductsChangedAfter(3, 1)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if want, got := int64(4), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
 	if want, got := 1, len(productIDs); want != got {
 		t.Fatalf("Expected %d products, got %d", want, got)
 	}
@@ -132,6 +127,26 @@ func TestGetSharedProductsUpdatedAfter(t *testing.T) {
 	if want, got := 420, productIDs[0].PriceCents; want != got {
 		t.Errorf("Expected %v, got %v", want, got)
 	}
+	if latest, err := w.LatestChangeSeq(); err != nil {
+		t.Fatal(err)
+	} else if want, got := int64(7), latest; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// Saving a product again moves it after the cursor.
+	w.saveProductInfoNoTx(infoList[0])
+	productIDs, changeSeq, err = w.GetSharedProductsChangedAfter(7, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(productIDs); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := WOOLWORTHS_ID_PREFIX+"123455", productIDs[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := int64(8), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
 
 	if total, err := w.GetTotalProductCount(); err != nil {
 		t.Fatal(err)
@@ -201,55 +216,260 @@ func TestPreviousPrice(t *testing.T) {
 	}
 }
 
-func TestBackupDB(t *testing.T) {
-
-	// Get a temp directory
+func TestPriceHistory(t *testing.T) {
+	w := getInitialisedWoolworths()
+	prices := []float64{1.5, 1.5, 2.0, 2.0, 1.5}
+	for i, price := range prices {
+		inProduct := woolworthsProductInfo{ID: "123455", Info: productListPageProduct{DisplayName: "1", Price: decimal.NewFromFloat(price), WasPrice: 2.0}, Updated: time.Now().Add(time.Duration(i-len(prices)) * time.Minute)}
+		if err := w.saveProductInfoNoTx(inProduct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Flag a special with an unchanged price, which should still be recorded.
+	inProduct := woolworthsProductInfo{ID: "123455", Info: productListPageProduct{DisplayName: "1", Price: decimal.NewFromFloat(1.5), WasPrice: 2.0, IsOnSpecial: true, IsHalfPrice: true}, Updated: time.Now()}
+	if err := w.saveProductInfoNoTx(inProduct); err != nil {
+		t.Fatal(err)
+	}
 
-	tempDirName, err := os.MkdirTemp("", "delme")
+	history, err := w.GetProductPriceHistory(WOOLWORTHS_ID_PREFIX + "123455")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.RemoveAll(tempDirName)
-
-	func() {
-		w := Woolworths{}
-		err = w.Init(woolworthsServer.URL, tempDirName+"/delme.db3", 10*time.Minute)
-		if err != nil {
-			slog.Error("Failed to initialise Woolworths", "error", err)
+	if want, got := 4, len(history); want != got {
+		t.Fatalf("Expected %d history entries, got %d", want, got)
+	}
+	for i, want := range []int{150, 200, 150, 150} {
+		if got := history[i].PriceCents; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
 		}
-	}()
+	}
+	if want, got := 200, history[0].WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := true, history[3].OnSpecial; want != got {
+		t.Errorf("Expected %t, got %t", want, got)
+	}
+	if want, got := "HALF_PRICE", history[3].PromotionType; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
 
-	func() {
-		w := Woolworths{}
-		err = w.Init(woolworthsServer.URL, tempDirName+"/delme.db3", 10*time.Minute)
-		if err != nil {
-			slog.Error("Failed to initialise Woolworths", "error", err)
-		}
-		matches, err := filepath.Glob(tempDirName + "/delme.db3.*")
+	for minEntries, want := range map[int]bool{4: true, 5: false} {
+		products, err := w.GetProductsWithPriceChanges(minEntries)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if want, got := 0, len(matches); want != got {
-			t.Fatalf("Unexpectedly found a backup of the DB that shouldn't've been created.")
+		var got bool
+		for _, product := range products {
+			got = got || product.ID == WOOLWORTHS_ID_PREFIX+"123455"
+		}
+		if want != got {
+			t.Errorf("Expected %t, got %t for %d entries", want, got, minEntries)
 		}
-		// Tweak the schema version to DB_SCHEMA_VERSION-1 to force a backup.
-		w.db.Exec("UPDATE schema SET version = ?", DB_SCHEMA_VERSION-1)
-	}()
+	}
 
-	func() {
-		w := Woolworths{}
-		err = w.Init(woolworthsServer.URL, tempDirName+"/delme.db3", 10*time.Minute)
-		if err != nil {
-			slog.Error("Failed to initialise Woolworths", "error", err)
+	history, err = w.GetProductPriceHistory("999999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(history); want != got {
+		t.Errorf("Expected %d history entries, got %d", want, got)
+	}
+}
+
+func TestSearchAndGetProduct(t *testing.T) {
+	w := getInitialisedWoolworths()
+	w.saveDepartment(departmentInfo{NodeID: "1-E5BEE36E", Description: "Fruit & Veg", ProductCount: 2, Updated: time.Now()})
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "123455", departmentID: "1-E5BEE36E", Info: productListPageProduct{DisplayName: "Cavendish Bananas", Price: decimal.NewFromFloat(1.5)}, Updated: time.Now()})
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "123456", departmentID: "1-E5BEE36E", Info: productListPageProduct{DisplayName: "Red Apples", Price: decimal.NewFromFloat(2.5)}, Updated: time.Now()})
+
+	products, err := w.SearchProducts("banana", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := WOOLWORTHS_ID_PREFIX+"123455", products[0].ID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	product, err := w.GetProduct(WOOLWORTHS_ID_PREFIX + "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Red Apples", product.Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := "Fruit & Veg", product.Department; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if _, err := w.GetProduct("coles_id_123456"); err != shared.ErrProductMissing {
+		t.Errorf("Expected %v, got %v", shared.ErrProductMissing, err)
+	}
+	if _, err := w.GetProduct(WOOLWORTHS_ID_PREFIX + "999999"); err != shared.ErrProductMissing {
+		t.Errorf("Expected %v, got %v", shared.ErrProductMissing, err)
+	}
+
+	departments, err := w.GetDepartments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(departments); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := "Woolworths", departments[0].Store; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestGetProductsByBarcode(t *testing.T) {
+	w := getInitialisedWoolworths()
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "123455", Info: productListPageProduct{DisplayName: "Cavendish Bananas", Barcode: "0264011000002"}, Updated: time.Now()})
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "123456", Info: productListPageProduct{DisplayName: "Red Apples", Barcode: ""}, Updated: time.Now()})
+
+	products, err := w.GetProductsByBarcode("00264011000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1, len(products); want != got {
+		t.Fatalf("Expected %d products, got %d", want, got)
+	}
+	if want, got := "0264011000002", products[0].Barcode; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// Products without a barcode must never match.
+	products, err = w.GetProductsByBarcode("00000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 0, len(products); want != got {
+		t.Errorf("Expected %d products, got %d", want, got)
+	}
+}
+
+func TestGetMatchableProducts(t *testing.T) {
+	w := getInitialisedWoolworths()
+	info := productListPageProduct{DisplayName: "Pauls Full Cream Milk 2L", PackageSize: "2L", Brand: "Pauls"}
+	rawJSON, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "888140", Info: info, RawJSON: rawJSON, Updated: time.Now()})
+
+	products, err := w.GetMatchableProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, product := range products {
+		if product.ID != WOOLWORTHS_ID_PREFIX+"888140" {
+			continue
 		}
-		// Ensure we created a backup of the old database at tempDirName/delme.db3.{DB_SCHEMA_VERSION-1}.{timestamp}
-		matches, err := filepath.Glob(tempDirName + "/delme.db3." + strconv.Itoa(DB_SCHEMA_VERSION-1) + ".*")
-		if err != nil {
-			t.Fatal(err)
+		found = true
+		if want, got := "Pauls", product.Brand; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
 		}
-		if want, got := 1, len(matches); want != got {
-			t.Fatalf("Couldn't find the backed-up file.")
+		if want, got := "2L", product.Size; want != got {
+			t.Errorf("Expected %s, got %s", want, got)
 		}
-	}()
+	}
+	if !found {
+		t.Errorf("Expected product to be matchable")
+	}
+}
+
+func TestUnitPrice(t *testing.T) {
+	w := getInitialisedWoolworths()
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "133211", Info: productListPageProduct{DisplayName: "Cavendish Bananas", Price: decimal.NewFromFloat(0.77), HasCupPrice: true, CupPrice: 4.5, CupMeasure: "1KG"}, Updated: time.Now()})
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "133212", Info: productListPageProduct{DisplayName: "Strawberries", Price: decimal.NewFromFloat(2.9), UnitWeightInGrams: 250}, Updated: time.Now()})
+
+	product, err := w.GetProduct(WOOLWORTHS_ID_PREFIX + "133211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 450.0, product.UnitPriceCents; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+	if want, got := shared.UNIT_TYPE_MASS, product.UnitType; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
 
+	// Without a cup price, fall back to the unit weight.
+	product, err = w.GetProduct(WOOLWORTHS_ID_PREFIX + "133212")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := 1160.0, product.UnitPriceCents; want != got {
+		t.Errorf("Expected %f, got %f", want, got)
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	w := getInitialisedWoolworths()
+	info := productListPageProduct{DisplayName: "Bega Cheese Block 500g", Price: decimal.NewFromFloat(7.5), WasPrice: 7.5}
+	info.CentreTag.MultibuyData = map[string]interface{}{"Quantity": 2, "Price": 12.0}
+	info.CentreTag.IsRegisteredRewardCardPromotion = true
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "244017", Info: info, Updated: time.Now()})
+	w.saveProductInfoNoTx(woolworthsProductInfo{ID: "244018", Info: productListPageProduct{DisplayName: "Tim Tam Original", Price: decimal.NewFromFloat(2.6), WasPrice: 5.2, IsOnSpecial: true, IsHalfPrice: true}, Updated: time.Now()})
+
+	product, err := w.GetProduct(WOOLWORTHS_ID_PREFIX + "244017")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := shared.PROMOTION_TYPE_MULTIBUY, product.Promotion.Type; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	// The was price matches the current price, so isn't a discount.
+	if want, got := 0, product.Promotion.WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 2, product.Promotion.MultibuyQuantity; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 1200, product.Promotion.MultibuyPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := true, product.Promotion.MemberOnly; want != got {
+		t.Errorf("Expected %t, got %t", want, got)
+	}
+
+	product, err = w.GetProduct(WOOLWORTHS_ID_PREFIX + "244018")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := shared.PROMOTION_TYPE_HALF_PRICE, product.Promotion.Type; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := 520, product.Promotion.WasPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+type MockPriceObserver struct {
+	products []shared.ProductInfo
+}
+
+func (m *MockPriceObserver) ObservePrices(products []shared.ProductInfo) {
+	m.products = append(m.products, products...)
+}
+
+func TestObserveCommittedPrices(t *testing.T) {
+	w := getInitialisedWoolworths()
+	observer := &MockPriceObserver{}
+	w.SetPriceObserver(observer)
+	w.saveDepartment(departmentInfo{NodeID: "1-E5BEE36E", Description: "Fruit & Vegetables", ProductCount: PRODUCTS_PER_PAGE, Updated: time.Now().Add(-1 * time.Hour)})
+
+	summary, err := w.ScrapeDepartment(context.Background(), "1-E5BEE36E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := summary.Products, len(observer.products); want != got {
+		t.Fatalf("Expected %d, got %d", want, got)
+	}
+	for _, product := range observer.products {
+		if !strings.HasPrefix(product.ID, WOOLWORTHS_ID_PREFIX) {
+			t.Errorf("Expected %s to have the Woolworths ID prefix", product.ID)
+		}
+	}
 }
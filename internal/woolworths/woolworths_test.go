@@ -1,10 +1,12 @@
 package woolworths
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
+	"github.com/tjhowse/aus_grocery_price_database/internal/metrics"
 	"golang.org/x/time/rate"
 )
 
@@ -30,16 +32,20 @@ func ValidateProduct(t *testing.T, w *Woolworths, id productID, expectedName str
 
 func TestScheduler(t *testing.T) {
 	w := Woolworths{}
-	w.Init(woolworthsServer.URL, ":memory:", 100*time.Second)
+	w.Init(woolworthsServer.URL, openTestDB(), 100*time.Second)
 	w.client.Ratelimiter = rate.NewLimiter(rate.Every(1*time.Millisecond), 1)
-	w.listingPageUpdateInterval = 1 * time.Second
+	w.RefreshInterval = 1 * time.Second
 	w.filteredDepartmentIDsSet = map[departmentID]bool{
 		"1-E5BEE36E": true, // Fruit & Veg
 		"1_DEB537E":  true, // Bakery
 	}
 	w.filterDepartments = true
-	cancel := make(chan struct{})
-	go w.Run(cancel)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
 
 	done := make(chan struct{})
 	go func() {
@@ -61,5 +67,61 @@ func TestScheduler(t *testing.T) {
 
 	}
 
-	close(cancel)
+	cancel()
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for scheduler to stop")
+	case <-stopped:
+	}
+}
+
+func TestScrapeDepartment(t *testing.T) {
+	w := getInitialisedWoolworths()
+	w.saveDepartment(departmentInfo{NodeID: "1-E5BEE36E", Description: "Fruit & Vegetables", ProductCount: PRODUCTS_PER_PAGE * 2, Updated: time.Now().Add(-1 * time.Hour)})
+	pagesBefore := metrics.PagesScraped.Value("Woolworths")
+
+	summary, err := w.ScrapeDepartment(context.Background(), "fruit & vegetables")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Products == 0 {
+		t.Errorf("Expected products to be saved")
+	}
+	if want, got := float64(summary.Pages), metrics.PagesScraped.Value("Woolworths")-pagesBefore; want != got {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	readInfo, err := w.loadProductInfo("144607")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "Strawberries 250g Punnet", readInfo.Info.DisplayName; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if want, got := departmentID("1-E5BEE36E"), readInfo.departmentID; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestRunOnce(t *testing.T) {
+	w := getInitialisedWoolworths()
+	// The mock server only has the first two pages of fruit & veg.
+	summary, err := w.RunOnce(context.Background())
+	if err == nil {
+		t.Errorf("Expected an error")
+	}
+	if summary.Products == 0 {
+		t.Errorf("Expected products to be saved")
+	}
+	if want, got := summary.Pages-2, summary.FailedPages; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	departments, err := w.loadDepartmentInfoList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dept := range departments {
+		if dept.NodeID == "1-E5BEE36E" && time.Since(dept.Updated) < w.MaxAge {
+			t.Errorf("Expected the department to still be due for an update")
+		}
+	}
 }
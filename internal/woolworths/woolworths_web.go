@@ -2,6 +2,7 @@ package woolworths
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 	"regexp"
 	"strconv"
 	"time"
+
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 )
 
 func extractStockCodes(body categoryData) ([]string, error) {
@@ -46,14 +49,14 @@ func extractDepartmentInfos(body fruitVegPage) ([]departmentInfo, error) {
 	return departmentList.Categories, nil
 }
 
-func (w *Woolworths) getDepartmentInfos() ([]departmentInfo, error) {
+func (w *Woolworths) getDepartmentInfos(ctx context.Context) ([]departmentInfo, error) {
 	var req *http.Request
 	var resp *http.Response
 	var err error
 	departmentInfos := []departmentInfo{}
 
 	url := fmt.Sprintf("%s/shop/browse/fruit-veg", w.baseURL)
-	if req, err = http.NewRequest("GET", url, nil); err != nil {
+	if req, err = http.NewRequestWithContext(ctx, "GET", url, nil); err != nil {
 		return departmentInfos, err
 	}
 	resp, err = w.client.Do(req)
@@ -72,17 +75,19 @@ func (w *Woolworths) getDepartmentInfos() ([]departmentInfo, error) {
 	if err != nil {
 		return departmentInfos, err
 	}
-	departmentInfos = w.filterOutDepartments(departmentInfos)
-	// Now we have to populate the product count, since the fruit-veg page doesn't have it.
-	for i, departmentInfo := range departmentInfos {
-		_, count, err := w.getProductIDsAndCountFromListPage(departmentInfo.NodeID, 1)
+	// Now we have to populate the product count, since the fruit-veg page doesn't have it. Departments whose
+	// count can't be read are left out rather than saved with none, so they're retried on the next refresh.
+	var counted []departmentInfo
+	for _, departmentInfo := range w.filterOutDepartments(departmentInfos) {
+		_, count, err := w.getProductIDsAndCountFromListPage(ctx, departmentInfo.NodeID, 1)
 		if err != nil {
 			w.logger.Warn("Failed to get product count for department", "department", departmentInfo.NodeID, "error", err)
 			continue
 		}
-		departmentInfos[i].ProductCount = count
+		departmentInfo.ProductCount = count
+		counted = append(counted, departmentInfo)
 	}
-	return departmentInfos, nil
+	return counted, nil
 }
 
 func extractTotalRecordCount(body categoryData) (int, error) {
@@ -185,7 +190,7 @@ func extractProductInfoFromProductListPage(body []byte) ([]woolworthsProductInfo
 }
 
 // getProductListPage returns the bytes of the product list page for the given department and page number.
-func (w *Woolworths) getProductListPage(department departmentID, page int) ([]byte, error) {
+func (w *Woolworths) getProductListPage(ctx context.Context, department departmentID, page int) ([]byte, error) {
 
 	var url string
 
@@ -196,7 +201,7 @@ func (w *Woolworths) getProductListPage(department departmentID, page int) ([]by
 	w.logger.Debug("Requesting product info page", "department", department, "page", page)
 
 	url = fmt.Sprintf("%s/apis/ui/browse/category", w.baseURL)
-	if req, err := http.NewRequest("POST", url, bytes.NewBufferString(requestBody)); err != nil {
+	if req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBufferString(requestBody)); err != nil {
 		return nil, err
 	} else {
 		// This is the minimal set of headers the request expects to see.
@@ -226,11 +231,11 @@ func (w *Woolworths) getProductListPage(department departmentID, page int) ([]by
 // This queries the Woolworths API to get the product list for a department. It reads
 // the specified page of that department's product list, returning the list of product
 // IDs and the total number of products in the department.
-func (w *Woolworths) getProductIDsAndCountFromListPage(department departmentID, page int) ([]productID, int, error) {
+func (w *Woolworths) getProductIDsAndCountFromListPage(ctx context.Context, department departmentID, page int) ([]productID, int, error) {
 	var totalCount int
 
 	prodIDs := []productID{}
-	body, err := w.getProductListPage(department, page)
+	body, err := w.getProductListPage(ctx, department, page)
 	if err != nil {
 		return prodIDs, 0, err
 	}
@@ -252,12 +257,12 @@ func (w *Woolworths) getProductIDsAndCountFromListPage(department departmentID,
 }
 
 // getProductInfoFromListPage returns the product information from the department list page
-func (w *Woolworths) getProductInfoFromListPage(dp departmentPage) ([]woolworthsProductInfo, error) {
+func (w *Woolworths) getProductInfoFromListPage(ctx context.Context, dp departmentPage) ([]woolworthsProductInfo, error) {
 	productInfos := []woolworthsProductInfo{}
 	var body []byte
 	var err error
 
-	body, err = w.getProductListPage(dp.ID, dp.page)
+	body, err = w.getProductListPage(ctx, dp.ID, dp.page)
 	if err != nil {
 		return productInfos, err
 	}
@@ -284,3 +289,29 @@ func (w *Woolworths) filterOutDepartments(departments []departmentInfo) []depart
 	}
 	return filteredDepartments
 }
+
+// FetchDepartments fetches the departments that aren't filtered out, for the Scraper.
+func (w *Woolworths) FetchDepartments(ctx context.Context) ([]store.Department, error) {
+	departmentInfos, err := w.getDepartmentInfos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	departments := make([]store.Department, len(departmentInfos))
+	for i, departmentInfo := range departmentInfos {
+		departments[i] = departmentFromInfo(departmentInfo)
+	}
+	return departments, nil
+}
+
+// FetchProducts fetches a page of a department's products, for the Scraper.
+func (w *Woolworths) FetchProducts(ctx context.Context, department string, page int) ([]store.Product, error) {
+	productInfos, err := w.getProductInfoFromListPage(ctx, departmentPage{ID: departmentID(department), page: page})
+	if err != nil {
+		return nil, err
+	}
+	products := make([]store.Product, len(productInfos))
+	for i, productInfo := range productInfos {
+		products[i] = w.productFromInfo(productInfo)
+	}
+	return products, nil
+}
@@ -1,6 +1,7 @@
 package woolworths
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,16 +13,25 @@ import (
 	"testing"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/shopspring/decimal"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 	utils "github.com/tjhowse/aus_grocery_price_database/internal/utils"
 )
 
 var woolworthsServer = WoolworthsHTTPServer()
 
-func getInitialisedWoolworths() Woolworths {
-	w := Woolworths{}
-	err := w.Init(woolworthsServer.URL, ":memory:", 10*time.Minute)
+// openTestDB opens a fresh in-memory DB.
+func openTestDB() *store.DB {
+	db, err := store.Open(":memory:")
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func getInitialisedWoolworths() *Woolworths {
+	w := &Woolworths{}
+	err := w.Init(woolworthsServer.URL, openTestDB(), 10*time.Minute)
 	if err != nil {
 		slog.Error("Failed to initialise Woolworths", "error", err)
 	}
@@ -91,7 +101,7 @@ func WoolworthsHTTPServer() *httptest.Server {
 func TestGetProductListPage(t *testing.T) {
 	w := getInitialisedWoolworths()
 
-	prodIDs, count, err := w.getProductIDsAndCountFromListPage("1-E5BEE36E", 1)
+	prodIDs, count, err := w.getProductIDsAndCountFromListPage(context.Background(), "1-E5BEE36E", 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -137,15 +147,20 @@ func TestExtractDepartmentInfos(t *testing.T) {
 func TestGetDepartmentInfos(t *testing.T) {
 	w := getInitialisedWoolworths()
 
-	departmentInfos, err := w.getDepartmentInfos()
+	departmentInfos, err := w.getDepartmentInfos(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	if want, got := departmentID("specialsgroup"), departmentInfos[0].NodeID; want != got {
+	// Only fruit & veg has fixtures, so every other department's count fails and it's left out rather than saved
+	// with no products.
+	if want, got := 1, len(departmentInfos); want != got {
+		t.Fatalf("Expected %d departments, got %d", want, got)
+	}
+	if want, got := departmentID("1-E5BEE36E"), departmentInfos[0].NodeID; want != got {
 		t.Errorf("Expected %s, got %s", want, got)
 	}
-	if want, got := 15, len(departmentInfos); want != got {
-		t.Errorf("Expected %d departments, got %d", want, got)
+	if departmentInfos[0].ProductCount == 0 {
+		t.Error("Expected the department's product count to be read")
 	}
 	for _, departmentInfo := range departmentInfos {
 		fmt.Println(departmentInfo.NodeID, departmentInfo.Description)
@@ -176,7 +191,7 @@ func TestGetProductInfoFromListPage(t *testing.T) {
 		ID:   "1-E5BEE36E",
 		page: 1,
 	}
-	productInfo, err := w.getProductInfoFromListPage(dp)
+	productInfo, err := w.getProductInfoFromListPage(context.Background(), dp)
 	if err != nil {
 		t.Fatal(err)
 	}
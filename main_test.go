@@ -1,12 +1,15 @@
 package main
 
 import (
-	"log/slog"
+	"context"
+	"errors"
+	"reflect"
 	"strconv"
 	"testing"
 	"time"
 
 	shared "github.com/tjhowse/aus_grocery_price_database/internal/shared"
+	"github.com/tjhowse/aus_grocery_price_database/internal/store"
 )
 
 type MockInfluxDB struct {
@@ -16,8 +19,11 @@ type MockInfluxDB struct {
 		field string
 		value interface{}
 	}
-	writtenSystemDatapoints []shared.SystemStatusDatapoint
-	closed                  bool
+	writtenSystemDatapoints     []shared.SystemStatusDatapoint
+	writtenFalseSaleDatapoints  []shared.FalseSaleFinding
+	writtenPriceCycleDatapoints []shared.PriceCycle
+	closed                      bool
+	err                         error // Returned by WriteProductDatapoints, if set
 }
 
 func (i *MockInfluxDB) Init(url, token, org, bucket string) {
@@ -28,9 +34,12 @@ func (i *MockInfluxDB) Init(url, token, org, bucket string) {
 	i.closed = false
 }
 
-func (i *MockInfluxDB) WriteProductDatapoint(info shared.ProductInfo) {
-	i.writtenProductDataPoints = append(i.writtenProductDataPoints, info)
-	slog.Info("Writing product datapoint", "name", info.Name, "store", info.Store, "location", info.Location, "department", info.Department, "cents", info.PriceCents, "grams", info.WeightGrams)
+func (i *MockInfluxDB) WriteProductDatapoints(infos []shared.ProductInfo) error {
+	if i.err != nil {
+		return i.err
+	}
+	i.writtenProductDataPoints = append(i.writtenProductDataPoints, infos...)
+	return nil
 }
 
 func (i *MockInfluxDB) WriteArbitrarySystemDatapoint(field string, value interface{}) {
@@ -44,33 +53,77 @@ func (i *MockInfluxDB) WriteSystemDatapoint(data shared.SystemStatusDatapoint) {
 	i.writtenSystemDatapoints = append(i.writtenSystemDatapoints, data)
 }
 
-func (i *MockInfluxDB) WriteWorker(input <-chan shared.ProductInfo) {
-	for info := range input {
-		i.WriteProductDatapoint(info)
-	}
+func (i *MockInfluxDB) WriteFalseSaleDatapoint(finding shared.FalseSaleFinding) {
+	i.writtenFalseSaleDatapoints = append(i.writtenFalseSaleDatapoints, finding)
+}
+
+func (i *MockInfluxDB) WritePriceCycleDatapoint(cycle shared.PriceCycle) {
+	i.writtenPriceCycleDatapoints = append(i.writtenPriceCycleDatapoints, cycle)
 }
 
 func (i *MockInfluxDB) Close() {
 	i.closed = true
 }
 
+type MockOutbox struct {
+	added      []shared.ProductInfo
+	changeSeqs map[string]int64
+	sinks      []shared.SinkStatus
+	err        error
+}
+
+func (m *MockOutbox) AddFrom(source string, products []shared.ProductInfo, changeSeq int64) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.added = append(m.added, products...)
+	if m.changeSeqs == nil {
+		m.changeSeqs = make(map[string]int64)
+	}
+	m.changeSeqs[source] = changeSeq
+	return nil
+}
+
+func (m *MockOutbox) SinkStatuses() []shared.SinkStatus {
+	return m.sinks
+}
+
+func (m *MockOutbox) ChangeSeq(source string) (int64, error) {
+	return m.changeSeqs[source], nil
+}
+
 type MockGroceryStore struct {
-	url, dbpath   string
+	url           string
+	db            *store.DB
 	productMaxAge time.Duration
+	stopped       bool
+	latest        int64   // The latest change sequence in the store
+	limit         int64   // If set, the store stops saving products once its change sequence gets here
+	readFrom      []int64 // The change sequence of each read
 }
 
-func (m *MockGroceryStore) Init(url string, dbpath string, age time.Duration) error {
+func (m *MockGroceryStore) Init(url string, db *store.DB, age time.Duration) error {
 	m.url = url
-	m.dbpath = dbpath
+	m.db = db
 	m.productMaxAge = age
 	return nil
 }
 
-func (m *MockGroceryStore) Run(chan struct{}) {
+func (m *MockGroceryStore) StoreName() string {
+	return "Test Store"
+}
 
+func (m *MockGroceryStore) Run(ctx context.Context) {
+	<-ctx.Done()
+	m.stopped = true
 }
 
-func (m *MockGroceryStore) GetSharedProductsUpdatedAfter(cutoff time.Time, count int) ([]shared.ProductInfo, error) {
+// GetSharedProductsChangedAfter acts as though the store is saving products faster than they can be read.
+func (m *MockGroceryStore) GetSharedProductsChangedAfter(changeSeq int64, count int) ([]shared.ProductInfo, int64, error) {
+	m.readFrom = append(m.readFrom, changeSeq)
+	if m.limit != 0 && changeSeq >= m.limit {
+		return nil, changeSeq, nil
+	}
 	var productIDs []shared.ProductInfo
 
 	for i := 0; i < count-1; i++ {
@@ -102,17 +155,39 @@ func (m *MockGroceryStore) GetSharedProductsUpdatedAfter(cutoff time.Time, count
 		Timestamp:          time.Now().Add(-5 * time.Minute),
 	})
 
-	return productIDs, nil
+	return productIDs, changeSeq + int64(count), nil
+}
+
+func (m *MockGroceryStore) LatestChangeSeq() (int64, error) {
+	return m.latest, nil
 }
 
 func (m *MockGroceryStore) GetTotalProductCount() (int, error) {
 	return 100, nil
 }
 
+type MockCanonicalIDGetter struct {
+	canonicalIDs map[string]string
+}
+
+func (m *MockCanonicalIDGetter) GetCanonicalID(productID string) string {
+	return m.canonicalIDs[productID]
+}
+
+type MockNotifier struct {
+	notified []shared.ProductInfo
+}
+
+func (m *MockNotifier) Notify(info shared.ProductInfo) {
+	m.notified = append(m.notified, info)
+}
+
 func TestRun(t *testing.T) {
-	mockGroceryStore := MockGroceryStore{}
-	mockGroceryStore2 := MockGroceryStore{}
+	mockGroceryStore := MockGroceryStore{limit: 1000}
+	mockGroceryStore2 := MockGroceryStore{limit: 1000}
 	mockInfluxDB := MockInfluxDB{}
+	mockNotifier := MockNotifier{}
+	mockOutbox := MockOutbox{sinks: []shared.SinkStatus{{Name: "influxdb", Queued: 5}}}
 	config := config{
 
 		InfluxDBURL:                 "a",
@@ -120,52 +195,82 @@ func TestRun(t *testing.T) {
 		InfluxDBOrg:                 "c",
 		InfluxDBBucket:              "d",
 		InfluxUpdateIntervalSeconds: 1,
-		LocalWoolworthsDBPath:       "e",
+		LocalDBPath:                 "e",
 		MaxProductAgeMinutes:        1,
 		WoolworthsURL:               "f",
 		DebugLogging:                false,
 	}
-	mockGroceryStore.Init("", "", 1*time.Minute)
-	mockGroceryStore2.Init("", "", 1*time.Minute)
+	mockGroceryStore.Init("", nil, 1*time.Minute)
+	mockGroceryStore2.Init("", nil, 1*time.Minute)
 	mockInfluxDB.Init("", "", "", "")
 
-	running := true
-
-	go run(&running, &config, &mockInfluxDB, []ProductInfoGetter{&mockGroceryStore, &mockGroceryStore2})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		run(ctx, &config, &mockInfluxDB, &mockOutbox, []ProductInfoGetter{&mockGroceryStore, &mockGroceryStore2}, &MockCanonicalIDGetter{canonicalIDs: map[string]string{"0": "canonical_1"}}, &mockNotifier)
+		close(done)
+	}()
 
 	deadline := time.Now().Add(10 * time.Second)
 	for time.Now().Before(deadline) {
-		if len(mockInfluxDB.writtenProductDataPoints) >= 100 {
+		if len(mockOutbox.added) >= 100 {
 			break
 		}
 		time.Sleep(1 * time.Second)
 	}
-	running = false
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for run to return")
+	}
+	if !mockGroceryStore.stopped || !mockGroceryStore2.stopped {
+		t.Error("Expected the grocery stores to be stopped")
+	}
+	if want, got := []int64{0, 100}, mockGroceryStore.readFrom[:2]; want[0] != got[0] || want[1] != got[1] {
+		t.Errorf("Expected each read to start where the last finished, got %v", got)
+	}
 
-	if want, got := 200, len(mockInfluxDB.writtenProductDataPoints); want != got {
+	// Everything the grocery stores saved is read before returning, however far behind the reads were.
+	if want, got := 2000, len(mockOutbox.added); want != got {
 		t.Fatalf("Expected %d products, got %d", want, got)
 	}
+	if want, got := int64(1000), mockOutbox.changeSeqs["Test Store"]; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	if want, got := "Test Product0", mockOutbox.added[0].Name; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	if want, got := "Test Department0", mockOutbox.added[0].Department; want != got {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
 
-	if want, got := "Test Product0", mockInfluxDB.writtenProductDataPoints[0].Name; want != got {
+	if want, got := "canonical_1", mockOutbox.added[0].CanonicalID; want != got {
 		t.Errorf("Expected %s, got %s", want, got)
 	}
 
-	if want, got := "Test Department0", mockInfluxDB.writtenProductDataPoints[0].Department; want != got {
+	if want, got := "", mockOutbox.added[1].CanonicalID; want != got {
 		t.Errorf("Expected %s, got %s", want, got)
 	}
 
-	if want, got := 100, mockInfluxDB.writtenProductDataPoints[0].PriceCents; want != got {
+	if want, got := 100, mockOutbox.added[0].PriceCents; want != got {
 		t.Errorf("Expected %d, got %d", want, got)
 	}
 
-	if want, got := 102, mockInfluxDB.writtenProductDataPoints[len(mockInfluxDB.writtenProductDataPoints)-1].PriceCents; want != got {
+	if want, got := 102, mockOutbox.added[len(mockOutbox.added)-1].PriceCents; want != got {
 		t.Errorf("Expected %d, got %d", want, got)
 	}
-	if want, got := 101, mockInfluxDB.writtenProductDataPoints[len(mockInfluxDB.writtenProductDataPoints)-1].PreviousPriceCents; want != got {
+	if want, got := 101, mockOutbox.added[len(mockOutbox.added)-1].PreviousPriceCents; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if len(mockNotifier.notified) < 100 {
+		t.Fatalf("Expected every product to be passed to the notifier, got %d", len(mockNotifier.notified))
+	}
+	if want, got := 101, mockNotifier.notified[99].PreviousPriceCents; want != got {
 		t.Errorf("Expected %d, got %d", want, got)
 	}
-	// Give time for the timeseries database to be closed down
-	time.Sleep(2 * time.Second)
 	// if !mockInfluxDB.closed {
 	// 	t.Error("Expected the database to be closed")
 	// }
@@ -210,5 +315,93 @@ func TestRun(t *testing.T) {
 	if want, got := 200, mockInfluxDB.writtenSystemDatapoints[0].TotalProductCount; want != got {
 		t.Errorf("Expected %v, got %v", want, got)
 	}
+	// Each sink's status comes from the outbox.
+	if want, got := mockOutbox.sinks, mockInfluxDB.writtenSystemDatapoints[0].Sinks; !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+}
+
+func TestRunOutboxFailure(t *testing.T) {
+	mockGroceryStore := MockGroceryStore{}
+	mockInfluxDB := MockInfluxDB{}
+	mockNotifier := MockNotifier{}
+	mockOutbox := MockOutbox{err: errors.New("disk full")}
+	config := config{InfluxUpdateIntervalSeconds: 1}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	run(ctx, &config, &mockInfluxDB, &mockOutbox, []ProductInfoGetter{&mockGroceryStore}, &MockCanonicalIDGetter{}, &mockNotifier)
+
+	// Products that couldn't be queued aren't announced, so they aren't announced twice when they're read again.
+	if want, got := 0, len(mockNotifier.notified); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	if want, got := 0, len(mockOutbox.added); want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+	// Nor is the cursor moved on, so they are read again.
+	if len(mockGroceryStore.readFrom) < 2 {
+		t.Fatalf("Expected the store to be read more than once, got %d", len(mockGroceryStore.readFrom))
+	}
+	for _, changeSeq := range mockGroceryStore.readFrom {
+		if want, got := int64(0), changeSeq; want != got {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+}
+
+func TestResumeChangeSeq(t *testing.T) {
+	outbox := &MockOutbox{changeSeqs: map[string]int64{"Test Store": 500}}
+	store := &MockGroceryStore{latest: 1000}
+	if changeSeq, err := resumeChangeSeq(outbox, store); err != nil {
+		t.Fatal(err)
+	} else if want, got := int64(500), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+
+	// A store that's behind the outbox has had its DB recreated.
+	store.latest = 100
+	if changeSeq, err := resumeChangeSeq(outbox, store); err != nil {
+		t.Fatal(err)
+	} else if want, got := int64(0), changeSeq; want != got {
+		t.Errorf("Expected %d, got %d", want, got)
+	}
+}
+
+type MockStoreHealth struct {
+	pingErr     error
+	lastScraped time.Time
+}
 
+func (m *MockStoreHealth) StoreName() string {
+	return "Test Store"
+}
+
+func (m *MockStoreHealth) Ping() error {
+	return m.pingErr
+}
+
+func (m *MockStoreHealth) LastScraped() time.Time {
+	return m.lastScraped
+}
+
+func TestCheckStoreReady(t *testing.T) {
+	now := time.Now()
+	store := &MockStoreHealth{lastScraped: now.Add(-time.Hour)}
+	if err := checkStoreReady(store, 2*time.Hour, now); err != nil {
+		t.Errorf("Expected the store to be ready, got %v", err)
+	}
+	if err := checkStoreReady(store, 30*time.Minute, now); err == nil {
+		t.Errorf("Expected a stale store not to be ready")
+	}
+	store.lastScraped = time.Time{}
+	if err := checkStoreReady(store, 2*time.Hour, now); err == nil {
+		t.Errorf("Expected a store that's never scraped not to be ready")
+	}
+	store.lastScraped = now
+	store.pingErr = errors.New("disk I/O error")
+	if err := checkStoreReady(store, 2*time.Hour, now); err == nil {
+		t.Errorf("Expected a store with a broken DB not to be ready")
+	}
 }